@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"github.com/nyxless/nyx/x/yaml"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 框架内置错误码, 项目中不能重复定义
+var builtinErrors = map[int64]string{
+	0:  "x.ErrSuc",
+	10: "x.ErrOther",
+	11: "x.ErrSystem",
+	12: "x.ErrMethodInvalid",
+	13: "x.ErrParams",
+	14: "x.ErrAuth",
+	15: "x.ErrNoRows",
+	16: "x.ErrTenant",
+}
+
+// 错误码定义位置
+type errDefine struct {
+	code  int64
+	pos   string
+	name  string
+	langs []string
+}
+
+// 列出项目中定义的所有错误码, 并检查重复定义
+// 扫描范围: 代码中的 NewErr 调用、conf 下配置文件中的 err_msg 及 conf/errors 下的多语言错误信息文件
+func listErrors() {
+	dir := "."
+	if len(os.Args) > 2 {
+		dir = os.Args[2]
+	}
+
+	defines, err := scanErrDefines(dir)
+	if err != nil {
+		printError("扫描代码失败: %v", err)
+		return
+	}
+
+	codes := map[int64][]*errDefine{}
+	for code, name := range builtinErrors {
+		codes[code] = append(codes[code], &errDefine{code: code, pos: "(内置)", name: name})
+	}
+
+	for _, d := range defines {
+		codes[d.code] = append(codes[d.code], d)
+	}
+
+	// 配置文件及错误信息文件中的语言
+	extraLangs := map[int64][]string{}
+	for code, langs := range scanConfErrMsg(dir) {
+		extraLangs[code] = append(extraLangs[code], langs...)
+	}
+
+	for code, langs := range scanErrBundles(filepath.Join(dir, "conf", "errors")) {
+		extraLangs[code] = append(extraLangs[code], langs...)
+	}
+
+	sorted := make([]int64, 0, len(codes))
+	for code := range codes {
+		sorted = append(sorted, code)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	duplicates := 0
+	fmt.Printf("%-8s %-24s %-16s %s\n", "CODE", "NAME", "LANGS", "POSITION")
+	for _, code := range sorted {
+		list := codes[code]
+		if len(list) > 1 {
+			duplicates++
+		}
+
+		for _, d := range list {
+			langs := append(append([]string{}, d.langs...), extraLangs[code]...)
+			line := fmt.Sprintf("%-8d %-24s %-16s %s", code, d.name, strings.Join(uniqStrings(langs), ","), d.pos)
+			if len(list) > 1 {
+				line = colorize(line, "red")
+			}
+			fmt.Println(line)
+		}
+	}
+
+	// 只在配置或错误信息文件中出现, 代码中未定义的错误码
+	var undefined []string
+	for code := range extraLangs {
+		if _, ok := codes[code]; !ok {
+			undefined = append(undefined, strconv.FormatInt(code, 10))
+		}
+	}
+	sort.Strings(undefined)
+
+	fmt.Println()
+	if len(undefined) > 0 {
+		printNotice("以下错误码仅在配置或错误信息文件中出现, 代码中未定义: %s", strings.Join(undefined, ", "))
+	}
+
+	if duplicates > 0 {
+		printError("共 %d 个错误码重复定义", duplicates)
+		os.Exit(1)
+	}
+
+	printSuccess("共 %d 个错误码, 无重复定义", len(sorted))
+}
+
+// 扫描 go 文件中的 NewErr(<code>, ...) 调用
+func scanErrDefines(dir string) ([]*errDefine, error) {
+	var defines []*errDefine
+	fset := token.NewFileSet()
+
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			name := d.Name()
+			if path != dir && (strings.HasPrefix(name, ".") || name == "vendor" || name == "node_modules") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return err
+		}
+
+		// 框架自身的内置错误码已在 builtinErrors 中列出
+		if file.Name.Name == "x" && filepath.Base(path) == "error.go" {
+			return nil
+		}
+
+		// 变量名: var ERR_TOKEN = x.NewErr(...)
+		names := map[ast.Expr]string{}
+		ast.Inspect(file, func(n ast.Node) bool {
+			if spec, ok := n.(*ast.ValueSpec); ok {
+				for i, v := range spec.Values {
+					if i < len(spec.Names) {
+						names[v] = spec.Names[i].Name
+					}
+				}
+			}
+			return true
+		})
+
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) == 0 || !isNewErrCall(call.Fun) {
+				return true
+			}
+
+			lit, ok := call.Args[0].(*ast.BasicLit)
+			if !ok || lit.Kind != token.INT {
+				return true
+			}
+
+			code, err := strconv.ParseInt(lit.Value, 0, 32)
+			if err != nil {
+				return true
+			}
+
+			defines = append(defines, &errDefine{
+				code:  code,
+				pos:   fset.Position(call.Pos()).String(),
+				name:  names[call],
+				langs: errCallLangs(call.Args[1:]),
+			})
+
+			return true
+		})
+
+		return nil
+	})
+
+	return defines, err
+}
+
+func isNewErrCall(fun ast.Expr) bool {
+	switch f := fun.(type) {
+	case *ast.Ident:
+		return f.Name == "NewErr"
+	case *ast.SelectorExpr:
+		return f.Sel.Name == "NewErr"
+	}
+
+	return false
+}
+
+// NewErr(code, "CN", "...", "EN", "...") 中的语言列表, 只有一条信息时为默认语言
+func errCallLangs(args []ast.Expr) []string {
+	if len(args) < 2 {
+		return nil
+	}
+
+	var langs []string
+	for i := 0; i+1 < len(args); i += 2 {
+		if lit, ok := args[i].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+			if lang, err := strconv.Unquote(lit.Value); err == nil {
+				langs = append(langs, strings.ToUpper(lang))
+			}
+		}
+	}
+
+	return langs
+}
+
+// 配置文件中的 err_msg
+func scanConfErrMsg(dir string) map[int64][]string {
+	ret := map[int64][]string{}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "conf", "*.conf*"))
+	for _, file := range files {
+		data, err := yaml.NewYaml(file).YamlToMap()
+		if err != nil {
+			continue
+		}
+
+		errMsg, ok := data["err_msg"].(map[string]any)
+		if !ok {
+			continue
+		}
+
+		for code, msgs := range errMsg {
+			c, err := strconv.ParseInt(code, 10, 32)
+			if err != nil {
+				continue
+			}
+
+			if m, ok := msgs.(map[string]any); ok {
+				for lang := range m {
+					ret[c] = append(ret[c], strings.ToUpper(lang))
+				}
+			}
+		}
+	}
+
+	return ret
+}
+
+// 多语言错误信息文件 errors.<lang>.yaml
+func scanErrBundles(dir string) map[int64][]string {
+	ret := map[int64][]string{}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "errors.*.y*ml"))
+	for _, file := range files {
+		parts := strings.Split(filepath.Base(file), ".")
+		if len(parts) != 3 {
+			continue
+		}
+		lang := strings.ToUpper(parts[1])
+
+		data, err := yaml.NewYaml(file).YamlToMap()
+		if err != nil {
+			printNotice("解析 %s 失败: %v", file, err)
+			continue
+		}
+
+		for code := range data {
+			if c, err := strconv.ParseInt(code, 10, 32); err == nil {
+				ret[c] = append(ret[c], lang)
+			}
+		}
+	}
+
+	return ret
+}
+
+func uniqStrings(list []string) []string {
+	seen := map[string]bool{}
+	ret := []string{}
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			ret = append(ret, s)
+		}
+	}
+
+	return ret
+}
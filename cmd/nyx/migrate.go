@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var migrationNameRegex = regexp.MustCompile(`^\w+$`)
+
+// 数据库迁移命令
+// create 在本地生成迁移文件; up | down | status 通过项目的 nyx 脚本运行应用程序执行(-migrate 参数)
+func runMigrate() {
+	if len(os.Args) < 3 {
+		printError("请指定迁移命令: create | up | down | status")
+		return
+	}
+
+	sub := os.Args[2]
+	args := os.Args[3:]
+
+	switch sub {
+	case "create":
+		createMigration(args)
+	case "up", "down", "status":
+		execMigration(sub, args)
+	default:
+		printError("不支持的迁移命令: %s", sub)
+		printNotice(" (支持的迁移命令: create | up | down | status)")
+	}
+}
+
+// 生成迁移文件, sql 类型生成 up/down 两个文件, go 类型生成注册代码
+func createMigration(args []string) {
+	name := ""
+	kind := "sql"
+	dir := "migrations"
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-t":
+			if i+1 < len(args) {
+				kind = args[i+1]
+				i++
+			}
+		case "-d":
+			if i+1 < len(args) {
+				dir = args[i+1]
+				i++
+			}
+		default:
+			name = args[i]
+		}
+	}
+
+	if name == "" || !migrationNameRegex.MatchString(name) {
+		printError("请指定迁移名称(字母、数字、下划线), 如: nyx migrate create create_user")
+		return
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		printError("创建目录失败: %v", err)
+		return
+	}
+
+	version := time.Now().Format("20060102150405")
+
+	switch kind {
+	case "sql":
+		for _, direction := range []string{"up", "down"} {
+			file := filepath.Join(dir, fmt.Sprintf("%s_%s.%s.sql", version, name, direction))
+			content := fmt.Sprintf("-- %s: %s_%s\n\n", strings.ToUpper(direction), version, name)
+			if err := writeNewFile(file, content); err != nil {
+				printError("%v", err)
+				return
+			}
+		}
+	case "go":
+		file := filepath.Join(dir, fmt.Sprintf("%s_%s.go", version, name))
+		content := fmt.Sprintf(`package migrations
+
+import (
+	"context"
+	"github.com/nyxless/nyx/dao/migrate"
+	"github.com/nyxless/nyx/x/db"
+)
+
+func init() {
+	migrate.Register(%s, "%s", func(ctx context.Context, client db.DBClient) error {
+		// up
+		return nil
+	}, func(ctx context.Context, client db.DBClient) error {
+		// down
+		return nil
+	})
+}
+`, version, name)
+
+		if err := writeNewFile(file, content); err != nil {
+			printError("%v", err)
+			return
+		}
+
+		printNotice("go 迁移需在 main.go 中引入 migrations 包, 如: import _ \"<module>/%s\"", filepath.ToSlash(dir))
+	default:
+		printError("不支持的迁移类型: %s (支持: sql | go)", kind)
+	}
+}
+
+func writeNewFile(file, content string) error {
+	if _, err := os.Stat(file); err == nil {
+		return fmt.Errorf("文件 %s 已存在", file)
+	}
+
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		return fmt.Errorf("创建文件失败: %w", err)
+	}
+
+	fmt.Println("创建文件:", file)
+
+	return nil
+}
+
+// 执行迁移: nyx run -migrate <sub> [-migrate_steps N] [-c conf] [-t tags]
+func execMigration(sub string, args []string) {
+	nyxCmd := "nyx"
+	if _, err := os.Stat(nyxCmd); os.IsNotExist(err) {
+		nyxCmd = "tools/nyx"
+		if _, err = os.Stat(nyxCmd); os.IsNotExist(err) {
+			printError("没有找到文件 nyx 或 tools/nyx, 请确认当前是否在项目根目录")
+			return
+		}
+	}
+
+	cmd := exec.Command("sh", nyxCmd, "run")
+	extraArgs := []string{"-migrate", sub}
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-n":
+			if i+1 < len(args) {
+				extraArgs = append(extraArgs, "-migrate_steps", args[i+1])
+				i++
+			}
+		case "-c", "-t":
+			if i+1 < len(args) {
+				cmd.Args = append(cmd.Args, args[i], args[i+1])
+				i++
+			}
+		default:
+			extraArgs = append(extraArgs, args[i])
+		}
+	}
+
+	cmd.Args = append(cmd.Args, "--")
+	cmd.Args = append(cmd.Args, extraArgs...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		printError("执行迁移失败: %v", err)
+	}
+}
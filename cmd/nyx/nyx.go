@@ -32,6 +32,10 @@ func main() {
 		}
 		appName := os.Args[2]
 		createApp(appName)
+	case "errors":
+		listErrors()
+	case "migrate":
+		runMigrate()
 	case "version":
 		printVersion()
 	case "help", "-h", "--help":
@@ -236,7 +240,7 @@ func runNyxShell(command string) {
 
 	if err := cmd.Run(); err != nil {
 		printError("执行命令失败: %v", err)
-		printNotice(" (支持的命令: create | run | build | init | gen | server | help, 详情使用 help 查看)")
+		printNotice(" (支持的命令: create | run | build | init | gen | errors | migrate | server | help, 详情使用 help 查看)")
 	}
 }
 
@@ -259,6 +263,8 @@ Usage: nyx <command> [options]
     -m          运行模式(http,rpc,cli,tcp,ws 可组合, 使用逗号分隔)
     -p          cli模式时，访问URI路径,  格式同 http uri, 如: user/getUserInfo
     -q          cli模式时，参数列表,  格式同 http query, 如: uid=1&username=test
+    -migrate    启动前执行数据库迁移[up | down | status | auto], auto 执行后继续启动服务, 其余执行后退出
+    -migrate_steps  迁移数量
                 
   build         编译应用程序
     -t          构建标签
@@ -296,6 +302,24 @@ Usage: nyx <command> [options]
       -p        rpc方法名前缀，多个相同路径的方法，可统一指定前缀
 
    
+  errors    列出所有错误码(代码中的 NewErr、配置文件 err_msg 及 conf/errors 下的错误信息文件), 并检查重复定义
+    [dir]       项目目录, 默认当前目录
+
+  migrate   数据库迁移, 使用配置 migrate.db 指定的数据库(默认 db_master), 迁移文件位于 migrations 目录
+    create <name>  生成迁移文件, 文件名以时间戳为版本号
+      -t          迁移类型[sql | go], 默认 sql
+      -d          迁移文件目录, 默认 migrations
+    up          执行未执行的迁移
+      -n          执行数量, 默认全部
+      -c          配置文件路径
+      -t          构建标签(使用 go 迁移时)
+    down        回滚已执行的迁移
+      -n          回滚数量, 默认 1
+      -c          配置文件路径
+      -t          构建标签
+    status      查看迁移状态
+      -c          配置文件路径
+
   server    服务管理
     start     启动服务
       -i        应用程序文件, 默认 bin/${APP_NAME}
@@ -2,8 +2,10 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/nyxless/nyx/x"
+	"github.com/nyxless/nyx/x/db"
 	"log"
 	"os"
 	"runtime/debug"
@@ -38,7 +40,7 @@ func (c *Controller) Prepare(ctx context.Context, controller, action, group stri
 	c.Group = group
 	c.ControllerName = controller
 	c.ActionName = action
-	c.Ctx = ctx
+	c.Ctx = db.WithSticky(ctx) //请求内写入后, 使用 c.Ctx 的 Dao 读主库
 } // }}}
 
 func (c *Controller) GetCtx(key string, defaultValues ...string) any { // {{{
@@ -59,6 +61,16 @@ func (c *Controller) SetLang(lang string) { // {{{
 	c.SetCtx(x.ConfLangKey, lang)
 } // }}}
 
+// 当前请求的租户, 由多租户中间件解析
+func (c *Controller) Tenant() string { // {{{
+	return x.GetTenant(c.Ctx)
+} // }}}
+
+// 设置租户, 之后使用 c.Ctx 的 Dao 按该租户读写
+func (c *Controller) SetTenant(tenant string) { // {{{
+	c.Ctx = x.WithTenant(c.Ctx, tenant)
+} // }}}
+
 // 根据捕获的错误获取需要返回的错误码、错误信息及数据
 func (c *Controller) GetErrorResponse(err any) (int32, string, x.MAP) { // {{{
 	var errno int32
@@ -67,6 +79,12 @@ func (c *Controller) GetErrorResponse(err any) (int32, string, x.MAP) { // {{{
 
 	var retdata = make(x.MAP)
 
+	// 被包装的 *x.Error 按其错误码返回
+	var xerr *x.Error
+	if e, ok := err.(error); ok && errors.As(e, &xerr) {
+		err = xerr
+	}
+
 	switch errinfo := err.(type) {
 	case string:
 		errno = x.ErrOther.GetCode()
@@ -75,6 +93,11 @@ func (c *Controller) GetErrorResponse(err any) (int32, string, x.MAP) { // {{{
 		errno = errinfo.GetCode()
 		errmsg = errinfo.GetMessage(c.lang)
 		retdata = errinfo.GetData()
+
+		// 系统错误记录原始错误及调用栈
+		if stack := errinfo.Stack(); len(stack) > 0 {
+			c.SetCtx("debug_trace", errinfo.Error()+"\n"+string(stack))
+		}
 	case error:
 		errno = x.ErrSystem.GetCode()
 		errmsg = errinfo.Error()
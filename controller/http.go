@@ -2,13 +2,18 @@ package controller
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"github.com/nyxless/nyx/x"
+	"github.com/nyxless/nyx/x/db"
 	"io"
 	"log"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 type HTTP struct {
@@ -40,8 +45,14 @@ func (h *HTTP) Prepare(w http.ResponseWriter, r *http.Request, controller, actio
 	guid := h.GetString(x.ConfGuidKey, h.GetHeader(x.ConfGuidKey, x.GetUUID()))
 	h.SetGuid(guid)
 
-	// lang 用于错误信息按语言展示, 依次检查: 请求参数 -> header -> 配置文件 -> 默认
-	lang := h.GetString(x.ConfLangKey, h.GetHeader(x.ConfLangKey, x.DefaultLang))
+	// lang 用于错误信息按语言展示, 依次检查: 请求参数 -> header -> Accept-Language -> 配置文件 -> 默认
+	lang := h.GetString(x.ConfLangKey, h.GetHeader(x.ConfLangKey))
+	if lang == "" {
+		lang = x.NegotiateLang(h.R.Header.Get("Accept-Language"))
+	}
+	if lang == "" {
+		lang = x.DefaultLang
+	}
 	h.SetLang(lang)
 
 	h.SetHeader(x.ConfGuidKey, guid)
@@ -88,6 +99,47 @@ func (h *HTTP) RenderFile(rs io.ReadSeeker, filename string) { // {{{
 	http.ServeContent(h.W, h.R, filename, x.NowTime(), rs)
 } // }}}
 
+// 流式导出查询结果为文件下载, format: db.ExportCSV | db.ExportJSONL | db.ExportXLSX, 见 db.RowIter.Export
+// 客户端断开连接时停止导出并关闭迭代器, 返回 ctx 的错误; 已开始输出后出错时无法再输出错误信息
+func (h *HTTP) RenderExport(iter *db.RowIter, format, filename string, opts ...db.ExportOption) error { // {{{
+	content_type, ok := db.ExportContentTypes[format]
+	if !ok {
+		iter.Close()
+		return fmt.Errorf("unsupported export format: %s", format)
+	}
+
+	h.SetHeader("Content-Type", content_type)
+	h.SetHeader("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"; filename*=UTF-8''%s", asciiFilename(filename), url.PathEscape(filename)))
+	h.SetHeader("X-Content-Type-Options", "nosniff")
+
+	_, err := iter.Export(&ctxWriter{h.R.Context(), h.W}, format, opts...)
+	return err
+} // }}}
+
+// 客户端断开连接后写入返回 ctx 的错误, 使导出中断
+type ctxWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (cw *ctxWriter) Write(p []byte) (int, error) { // {{{
+	if err := cw.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return cw.w.Write(p)
+} // }}}
+
+// Content-Disposition 中 filename 参数的 ASCII 形式, 非 ASCII 字符及引号替换为 _
+func asciiFilename(filename string) string { // {{{
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, filename)
+} // }}}
+
 // 渲染html模板
 func (h *HTTP) RenderHtml(files ...string) { // {{{
 	if h.Tpl == nil {
@@ -135,6 +187,10 @@ func (h *HTTP) Redirect(url string, codes ...int) { // {{{
 
 // 回调方法, 只在HttpServer中使用
 func (h *HTTP) HttpFinal() { // {{{
+	if t := x.GetTiming(h.Ctx); t != nil {
+		t.AddAction(time.Since(h.startTime))
+	}
+
 	//将 ctx 写回 http.Request, 供中间件使用
 	*h.R = *h.R.WithContext(h.Ctx)
 } // }}}
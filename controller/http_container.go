@@ -786,6 +786,13 @@ func (h *httpContainer) render(errno int32, errmsg string, retdata any) { // {{{
 	h.W.Header().Set("Content-Type", "application/json;charset=UTF-8")
 	data := x.JsonEncodeToBytes(resData)
 
+	// 开启 err_status_enabled 时, 按错误码映射返回 http 状态码
+	if x.ConfErrStatusEnabled && errno != 0 {
+		if st, ok := x.GetErrStatus(errno); ok && st.HttpStatus > 0 {
+			h.W.WriteHeader(st.HttpStatus)
+		}
+	}
+
 	h.W.Write(data)
 } // }}}
 
@@ -6,6 +6,8 @@ import (
 	"github.com/nyxless/nyx/x"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -206,6 +208,14 @@ func (r *rpcContainer) RenderError(err any) { // {{{
 	res := r.RenderResponser(errno, errmsg, retdata)
 
 	r.render(res)
+
+	// 开启 err_status_enabled 时, 按错误码映射返回 grpc 状态码, 错误码通过 trailer 中的 errno 传递
+	if x.ConfErrStatusEnabled && errno != 0 && r.ResError == nil {
+		if st, ok := x.GetErrStatus(errno); ok {
+			grpc.SetTrailer(r.Ctx, metadata.Pairs("errno", strconv.Itoa(int(errno))))
+			r.ResError = status.Error(st.GrpcCode, errmsg)
+		}
+	}
 } // }}}
 
 func (r *rpcContainer) RenderStream(data any) error { // {{{
@@ -0,0 +1,309 @@
+package dao
+
+import (
+	"fmt"
+	"github.com/nyxless/nyx/x/db"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 批量写入
+// 用法:
+//
+//	res, err := NewDAOUser().BatchInsert(rows, 500)
+//	res, err := NewDAOUser().BatchUpsert(rows, []string{"name", "score"}, dao.WithBatchBytes(1<<20), dao.WithBatchTx())
+//
+// 所有记录按第一条记录的字段(按字母排序)生成 sql, 每条记录的字段须相同
+// 按行数及估算的字节数自动分批, 每批一条 sql, 每批的占位符数量不超过 65535
+// 默认每批单独提交, 失败时已执行的批次不回滚, 返回已执行批次的统计及错误; WithBatchTx 时所有批次在同一个事务中执行(分库时每个库一个事务)
+// 在事务中(InitTx 或 tx.Run)调用时所有批次在当前事务中执行
+const (
+	DefaultBatchSize  = 500     // 每批的默认行数
+	DefaultBatchBytes = 4 << 20 // 每批的默认字节数, mysql max_allowed_packet 的默认值
+	maxBatchParams    = 65535   // 单条 sql 的占位符数量上限
+)
+
+// 批量写入的统计
+type BatchResult struct {
+	Affected int // 影响的行数
+	Inserted int // 插入的行数
+	Updated  int // 更新的行数
+	Chunks   int // 执行的批次数
+}
+
+type batchOption struct {
+	size  int
+	bytes int
+	tx    bool
+}
+
+type BatchOption func(*batchOption)
+
+// 每批的行数, 默认 DefaultBatchSize
+func WithBatchSize(size int) BatchOption { // {{{
+	return func(o *batchOption) {
+		if size > 0 {
+			o.size = size
+		}
+	}
+} // }}}
+
+// 每批估算的字节数上限, 默认 DefaultBatchBytes, 应小于数据库单条 sql 的大小限制
+func WithBatchBytes(bytes int) BatchOption { // {{{
+	return func(o *batchOption) {
+		if bytes > 0 {
+			o.bytes = bytes
+		}
+	}
+} // }}}
+
+// 所有批次在同一个事务中执行, 任一批次失败时全部回滚
+func WithBatchTx() BatchOption { // {{{
+	return func(o *batchOption) {
+		o.tx = true
+	}
+} // }}}
+
+// 批量插入, chunk_size 为每批的行数, <= 0 时使用 DefaultBatchSize
+func (d *Dao) BatchInsert(rows []map[string]any, chunk_size int, opts ...BatchOption) (*BatchResult, error) { // {{{
+	defer d.trackDB(time.Now())
+
+	for _, row := range rows {
+		d.fillTime(row, d.createdField, false)
+		d.fillTime(row, d.updatedField, false)
+
+		if err := d.fillTenant(row, true); err != nil {
+			return nil, err
+		}
+	}
+
+	e := d.newEvent(OpInsert)
+	e.Records = rows
+
+	res := &BatchResult{}
+	opts = append([]BatchOption{WithBatchSize(chunk_size)}, opts...)
+
+	err := d.withBatchHooks(e, res, func() error {
+		err := d.batch(rows, opts, res, func(client db.DBClient, table string, chunk []map[string]any) (int, error) {
+			if _, err := client.InsertContext(d.getContext(), table, chunk...); err != nil {
+				return 0, err
+			}
+
+			return len(chunk), nil
+		})
+
+		res.Inserted = res.Affected
+		e.Affected = res.Affected
+
+		return err
+	})
+
+	return res, err
+} // }}}
+
+// 批量 upsert, 以主键作为冲突检测字段; update_fields 为冲突时更新的字段, 为空时更新主键、创建时间及租户字段以外的所有字段
+// 插入及更新的行数: mysql 按影响的行数推算(插入计 1 行, 更新计 2 行, 值未变化计 0 行), 存在值未变化的记录时不准确; 其他数据库不区分, 只统计 Affected
+func (d *Dao) BatchUpsert(rows []map[string]any, update_fields []string, opts ...BatchOption) (*BatchResult, error) { // {{{
+	defer d.trackDB(time.Now())
+
+	for _, row := range rows {
+		d.fillTime(row, d.createdField, false)
+		d.fillTime(row, d.updatedField, false)
+
+		if err := d.fillTenant(row, true); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(update_fields) == 0 && len(rows) > 0 {
+		for col := range rows[0] {
+			col = strings.TrimSuffix(col, ":expr")
+			if col != d.primary && col != d.createdField && col != d.tenantField {
+				update_fields = append(update_fields, col)
+			}
+		}
+
+		slices.Sort(update_fields)
+	}
+
+	e := d.newEvent(OpUpsert)
+	e.Records = rows
+
+	res := &BatchResult{}
+	is_mysql := d.dialect().Name() == "mysql"
+
+	return res, d.withBatchHooks(e, res, func() error {
+		return d.batch(rows, opts, res, func(client db.DBClient, table string, chunk []map[string]any) (int, error) {
+			n, err := client.UpsertBatchContext(d.upsertContext(), table, chunk, []string{d.primary}, update_fields)
+			if err != nil {
+				return 0, err
+			}
+
+			if is_mysql {
+				updated := min(max(n-len(chunk), 0), len(chunk))
+				res.Updated += updated
+				res.Inserted += n - 2*updated
+			}
+
+			return n, nil
+		})
+	})
+} // }}}
+
+// 执行批量写入及钩子; 部分批次已提交后失败时, 仍发送事件(不执行 After 钩子), 以便缓存失效等订阅者处理已写入的记录
+func (d *Dao) withBatchHooks(e *Event, res *BatchResult, fn func() error) error { // {{{
+	_, err := d.withHooks(e, func() (int, error) {
+		err := fn()
+		return res.Affected, err
+	})
+
+	if err != nil && res.Affected > 0 && e.Tx == nil {
+		e.Affected = res.Affected
+		publish(e)
+	}
+
+	return err
+} // }}}
+
+// 按目标表分组, 再按行数及字节数分批执行
+func (d *Dao) batch(rows []map[string]any, opts []BatchOption, res *BatchResult, fn func(client db.DBClient, table string, chunk []map[string]any) (int, error)) error { // {{{
+	if len(rows) == 0 || len(rows[0]) == 0 {
+		return fmt.Errorf("no record found")
+	}
+
+	o := &batchOption{size: DefaultBatchSize, bytes: DefaultBatchBytes}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	size := min(o.size, maxBatchParams/len(rows[0]))
+
+	targets, err := d.batchTargets(rows)
+	if err != nil {
+		return err
+	}
+
+	use_tx := o.tx && !d.inTx()
+
+	for _, t := range targets {
+		client := t.writer
+		if use_tx {
+			if client, err = t.writer.BeginContext(d.getContext(), false); err != nil {
+				return err
+			}
+		}
+
+		var affected, chunks int
+		for _, chunk := range chunkRows(t.rows, size, o.bytes) {
+			n, err := fn(client, t.table, chunk)
+			if err != nil {
+				if use_tx {
+					client.Rollback()
+				} else {
+					res.Affected += affected
+					res.Chunks += chunks
+				}
+
+				return err
+			}
+
+			affected += n
+			chunks++
+		}
+
+		if use_tx {
+			if err := client.Commit(); err != nil {
+				return err
+			}
+		}
+
+		res.Affected += affected
+		res.Chunks += chunks
+	}
+
+	return nil
+} // }}}
+
+// 批量写入的目标表及记录, 分表时按分表键分组, 记录中须包含分表键
+func (d *Dao) batchTargets(rows []map[string]any) ([]*shardTarget, error) { // {{{
+	if d.sharding == nil {
+		return []*shardTarget{{table: d.table, writer: d.GetDBWriter(), rows: rows}}, nil
+	}
+
+	groups := map[string][]map[string]any{}
+	var order []string
+
+	for _, row := range rows {
+		val, ok := row[d.sharding.key]
+		if !ok {
+			return nil, fmt.Errorf("shard key %s is required", d.sharding.key)
+		}
+
+		shard, err := d.sharding.strategy.Shard(val)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := groups[shard]; !ok {
+			order = append(order, shard)
+		}
+		groups[shard] = append(groups[shard], row)
+	}
+
+	if len(order) > 1 && d.inTx() {
+		return nil, ErrCrossShardTx
+	}
+
+	targets := make([]*shardTarget, 0, len(order))
+	for _, shard := range order {
+		d.shardVals = []any{groups[shard][0][d.sharding.key]}
+
+		t, err := d.shardTargets(true)
+		if err != nil {
+			return nil, err
+		}
+
+		t[0].rows = groups[shard]
+		targets = append(targets, t[0])
+	}
+
+	return targets, nil
+} // }}}
+
+// 按行数及估算的字节数分批
+func chunkRows(rows []map[string]any, size, bytes int) [][]map[string]any { // {{{
+	var chunks [][]map[string]any
+	start, total := 0, 0
+
+	for i, row := range rows {
+		n := rowSize(row)
+		if i > start && (i-start >= size || total+n > bytes) {
+			chunks = append(chunks, rows[start:i])
+			start, total = i, 0
+		}
+
+		total += n
+	}
+
+	return append(chunks, rows[start:])
+} // }}}
+
+// 估算一条记录在 sql 中占用的字节数
+func rowSize(row map[string]any) int { // {{{
+	size := 2
+	for _, val := range row {
+		switch v := val.(type) {
+		case string:
+			size += len(v) + 3
+		case []byte:
+			size += len(v)*2 + 3
+		case *db.RawExpr:
+			size += len(v.Sql()) + 2
+		default:
+			size += 22
+		}
+	}
+
+	return size
+} // }}}
@@ -0,0 +1,268 @@
+package dao
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/nyxless/nyx/x"
+	"github.com/nyxless/nyx/x/redis"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Dao 查询缓存(WithCache/WithRefreshCache)的二级缓存及写入失效
+// 配置:
+//
+//	dao_cache:
+//	  redis: redis_cache       # redis 配置名, 为空时只使用本地缓存, 写入失效只在本进程内生效
+//	  prefix: "nyx:dao:"       # redis key 前缀
+//	  channel: ""              # 失效通知的 pub/sub 频道, 默认 <prefix>invalidate
+//	  version_ttl: 86400       # redis 中版本号的过期时间(秒), 须大于最长的缓存时间
+//	  local_version_ttl: 60    # 本地保存版本号的时间(秒), 即失效通知丢失时最长的不一致时间
+//
+// 缓存按标签的版本号失效, 版本号是缓存 key 的一部分, 写入后递增版本号, 旧的缓存不再被读取, 过期后自动清除:
+//   - 表版本: 所有查询(包括 Join 的表), 任何写入后递增
+//   - 行版本: GetRecord, 按条件更新或删除(SetRecordBy/DelRecordBy/DelRecords 等)后递增
+//   - 主键版本: GetRecord, 按主键更新或删除(SetRecord/DelRecord/ResetRecord 等)后递增; 只使用本地缓存时以行版本代替
+//
+// 版本号在事务提交后递增(见 Subscribe), 通过 redis INCR 递增并经 pub/sub 通知其他实例
+// 子查询及 UNION 中的表不作为标签, 执行原始 sql 的写入(Execute)不会使缓存失效
+// 读取 redis 失败时不使用缓存, 直接查询 DB
+type tieredCache struct {
+	redis           *redis.RedisClient
+	prefix          string
+	channel         string
+	versionTtl      time.Duration
+	localVersionTtl int
+	mutex           sync.RWMutex
+	versions        map[string]int64 // 只使用本地缓存时的版本号
+	stop            context.CancelFunc
+}
+
+var daoCache *tieredCache
+
+// 初始化 Dao 的二级缓存及写入失效, 由框架在启用 localcache 时调用
+func InitCache() error { // {{{
+	if daoCache != nil {
+		return nil
+	}
+
+	c := &tieredCache{
+		prefix:          x.Conf.GetDefString("nyx:dao:", "dao_cache", "prefix"),
+		versionTtl:      time.Duration(x.Conf.GetDefInt(86400, "dao_cache", "version_ttl")) * time.Second,
+		localVersionTtl: x.Conf.GetDefInt(60, "dao_cache", "local_version_ttl"),
+		versions:        map[string]int64{},
+	}
+
+	c.channel = x.Conf.GetDefString(c.prefix+"invalidate", "dao_cache", "channel")
+
+	if name := x.Conf.GetString("dao_cache", "redis"); name != "" {
+		conf := x.Conf.GetMap(name)
+		if len(conf) == 0 {
+			return fmt.Errorf("redis资源不存在: %s", name)
+		}
+
+		client, err := x.Redis.Get(conf)
+		if err != nil {
+			return err
+		}
+
+		c.redis = client
+		c.subscribe()
+	}
+
+	daoCache = c
+	Subscribe(c.invalidate)
+
+	return nil
+} // }}}
+
+// 停止接收失效通知
+func CloseCache() { // {{{
+	if daoCache != nil && daoCache.stop != nil {
+		daoCache.stop()
+	}
+} // }}}
+
+// 接收其他实例的失效通知
+func (c *tieredCache) subscribe() { // {{{
+	ctx, cancel := context.WithCancel(context.Background())
+	c.stop = cancel
+
+	pubsub := c.redis.Subscribe(ctx, c.channel)
+
+	go func() {
+		defer pubsub.Close()
+
+		ch := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+
+				var versions map[string]int64
+				if err := json.Unmarshal([]byte(msg.Payload), &versions); err != nil {
+					x.Warn("[dao cache] invalid message:", msg.Payload)
+					continue
+				}
+
+				for tag, ver := range versions {
+					if ver > c.localVersion(tag) {
+						c.setLocalVersion(tag, ver)
+					}
+				}
+			}
+		}
+	}()
+} // }}}
+
+// 写入事件使对应标签的版本号递增
+func (c *tieredCache) invalidate(e *Event) { // {{{
+	tags := []string{"t:" + e.Table}
+
+	switch {
+	case e.Op == OpInsert:
+	case e.Id != nil && c.redis != nil:
+		tags = append(tags, "p:"+e.Table+":"+x.AsString(e.Id))
+	default:
+		tags = append(tags, "g:"+e.Table)
+	}
+
+	c.bump(tags...)
+} // }}}
+
+func (c *tieredCache) bump(tags ...string) { // {{{
+	if c.redis == nil {
+		c.mutex.Lock()
+		for _, tag := range tags {
+			c.versions[tag]++
+		}
+		c.mutex.Unlock()
+
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	versions := make(map[string]int64, len(tags))
+	for _, tag := range tags {
+		ver, err := c.redis.Incr(ctx, c.prefix+tag).Result()
+		if err != nil {
+			x.Warn("[dao cache] bump version error:", err)
+			return
+		}
+
+		c.redis.Expire(ctx, c.prefix+tag, c.versionTtl)
+		c.setLocalVersion(tag, ver)
+		versions[tag] = ver
+	}
+
+	if err := c.redis.Publish(ctx, c.channel, x.JsonEncode(versions)).Err(); err != nil {
+		x.Warn("[dao cache] publish error:", err)
+	}
+} // }}}
+
+// 标签的版本号, 本地没有时从 redis 读取
+func (c *tieredCache) version(tag string) (int64, error) { // {{{
+	if c.redis == nil {
+		c.mutex.RLock()
+		defer c.mutex.RUnlock()
+
+		return c.versions[tag], nil
+	}
+
+	if data, err := x.LocalCache.Get([]byte("nyx_ver:" + tag)); err == nil {
+		return strconv.ParseInt(string(data), 10, 64)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	ver, err := c.redis.Get(ctx, c.prefix+tag).Int64()
+	if err != nil && err != redis.Nil {
+		return 0, err
+	}
+
+	c.setLocalVersion(tag, ver)
+
+	return ver, nil
+} // }}}
+
+func (c *tieredCache) localVersion(tag string) int64 { // {{{
+	data, err := x.LocalCache.Get([]byte("nyx_ver:" + tag))
+	if err != nil {
+		return 0
+	}
+
+	ver, _ := strconv.ParseInt(string(data), 10, 64)
+	return ver
+} // }}}
+
+func (c *tieredCache) setLocalVersion(tag string, ver int64) { // {{{
+	x.LocalCache.Set([]byte("nyx_ver:"+tag), []byte(strconv.FormatInt(ver, 10)), c.localVersionTtl)
+} // }}}
+
+// redis 中的缓存 key, ctx 中有租户时带租户前缀
+func (c *tieredCache) remoteKey(ctx context.Context, key []byte) string { // {{{
+	return c.prefix + x.TenantKey(ctx, "c:"+strconv.FormatUint(x.Hash(string(key)), 16))
+} // }}}
+
+func (c *tieredCache) getRemote(key string) ([]byte, error) { // {{{
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	return c.redis.Get(ctx, key).Bytes()
+} // }}}
+
+func (c *tieredCache) setRemote(key string, data []byte, ttl int) { // {{{
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := c.redis.Set(ctx, key, data, time.Duration(ttl)*time.Second).Err(); err != nil {
+		x.Warn("[dao cache] set error:", err)
+	}
+} // }}}
+
+// 当前查询的缓存标签及版本号, 追加在缓存 key 之后; 读取版本号失败时返回 false, 不使用缓存
+func (d *Dao) cacheTags() (string, bool) { // {{{
+	if daoCache == nil {
+		return "", true
+	}
+
+	var tags []string
+	if d.cachePk != nil {
+		tags = append(tags, "g:"+d.table)
+		if daoCache.redis != nil {
+			tags = append(tags, "p:"+d.table+":"+x.AsString(d.cachePk))
+		}
+	} else {
+		tags = append(tags, "t:"+d.table)
+	}
+
+	for _, join := range append(d.leftJoin[:len(d.leftJoin):len(d.leftJoin)], d.innerJoin...) {
+		tags = append(tags, "t:"+join.table)
+	}
+
+	var sb strings.Builder
+	for _, tag := range tags {
+		ver, err := daoCache.version(tag)
+		if err != nil {
+			x.Warn("[dao cache] get version error:", err)
+			return "", false
+		}
+
+		sb.WriteString("#")
+		sb.WriteString(tag)
+		sb.WriteString("=")
+		sb.WriteString(strconv.FormatInt(ver, 10))
+	}
+
+	return sb.String(), true
+} // }}}
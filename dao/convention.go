@@ -0,0 +1,233 @@
+package dao
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/nyxless/nyx/x"
+	"github.com/nyxless/nyx/x/db"
+	"reflect"
+	"time"
+)
+
+// 软删除、时间戳及乐观锁约定
+// 用法:
+//
+//	func NewDAOUser() *DAOUser {
+//		ins := &DAOUser{}
+//		ins.Init()
+//		ins.SetTable("user")
+//		ins.SetPrimary("uid")
+//		ins.SetSoftDelete("deleted_at")             // DelRecord* 改为更新 deleted_at, 查询时忽略已删除的记录
+//		ins.SetTimestamps("created_at", "updated_at") // 写入时自动填充
+//		ins.SetVersionField("version")              // 更新时检查并递增版本号
+//		return ins
+//	}
+//
+//	NewDAOUser().WithTrashed().GetRecord(uid)      // 包括已删除的记录
+//	NewDAOUser().OnlyTrashed().GetRecords()        // 只查询已删除的记录
+//	NewDAOUser().ForceDelete().DelRecord(uid)      // 物理删除
+//	NewDAOUser().Restore(uid)                      // 恢复已删除的记录
+//
+//	user["version"] = 3                            // 读取时的版本号
+//	NewDAOUser().SetRecord(user, uid)              // version = 3 时更新并将 version 置为 4, 否则返回 ErrVersionConflict
+//
+// 时间戳默认使用 x.DateTime() 格式的字符串, 整数时间戳的表可使用 SetTimeFunc(func() any { return x.Now() })
+
+// 乐观锁冲突: 带版本号的更新没有影响任何记录(版本号已变化或记录不存在)
+var ErrVersionConflict = errors.New("version conflict: record has been modified")
+
+// 软删除字段, 为 NULL 时表示未删除
+// 设置后 DelRecord/DelRecordBy/DelRecords 改为将该字段更新为当前时间, 所有查询忽略已删除的记录
+// Join 的表设置了软删除时, 过滤条件放在 ON 中: InnerJoin 忽略已删除的记录, LeftJoin 将其视为不匹配
+func (d *Dao) SetSoftDelete(field string) *Dao { // {{{
+	d.softDeleteField = field
+	return d
+} // }}}
+
+// 自动填充的时间字段, 为空时不填充
+// AddRecord 填充 created 及 updated, ResetRecord 填充 updated, 记录中已包含非零值时不覆盖; ResetRecord 不填充 created, 避免更新时覆盖创建时间
+// SetRecord/SetRecordBy/Restore 及软删除总是将 updated 更新为当前时间
+func (d *Dao) SetTimestamps(created, updated string) *Dao { // {{{
+	d.createdField = created
+	d.updatedField = updated
+	return d
+} // }}}
+
+// 时间字段的值, 默认 x.DateTime()
+func (d *Dao) SetTimeFunc(fn func() any) *Dao { // {{{
+	d.timeFn = fn
+	return d
+} // }}}
+
+// 乐观锁的版本字段
+// SetRecord/SetRecordBy 的记录中包含该字段时, 以其值作为条件更新, 没有影响任何记录时返回 ErrVersionConflict
+// 更新时该字段自动加 1
+func (d *Dao) SetVersionField(field string) *Dao { // {{{
+	d.versionField = field
+	return d
+} // }}}
+
+// 查询包括已软删除的记录
+func (d *Dao) WithTrashed(flag ...bool) *Dao { // {{{
+	d.withTrashed = len(flag) == 0 || flag[0]
+	return d
+} // }}}
+
+// 只查询已软删除的记录
+func (d *Dao) OnlyTrashed(flag ...bool) *Dao { // {{{
+	d.onlyTrashed = len(flag) == 0 || flag[0]
+	return d
+} // }}}
+
+// 设置了软删除时, 物理删除记录
+func (d *Dao) ForceDelete(flag ...bool) *Dao { // {{{
+	d.forceDelete = len(flag) == 0 || flag[0]
+	return d
+} // }}}
+
+// 按主键恢复已软删除的记录
+func (d *Dao) Restore(id any) (int, error) { // {{{
+	if d.softDeleteField == "" {
+		return 0, errors.New("soft delete is not enabled")
+	}
+
+	record := map[string]any{d.softDeleteField: nil}
+	d.fillTime(record, d.updatedField, true)
+
+	defer d.trackDB(time.Now())
+
+	e := d.newEvent(OpUpdate)
+	e.Id = id
+	e.Record = record
+
+	d.shardByPrimary(id)
+	return d.withHooks(e, func() (int, error) {
+		return d.write(func(client db.DBClient, table string) (int, error) {
+			where, params := d.tenantWhere(d.primary+"=?", []any{id})
+			return client.UpdateContext(d.getContext(), table, record, where, params...)
+		}, true)
+	})
+} // }}}
+
+// 查询时软删除的过滤条件
+func (d *Dao) getSoftDelete() string { // {{{
+	with_trashed, only_trashed := d.withTrashed, d.onlyTrashed
+	d.withTrashed, d.onlyTrashed = false, false
+
+	if d.softDeleteField == "" || with_trashed {
+		return ""
+	}
+
+	field := d.dialect().Quote(d.softDeleteField)
+	if d.alias != "" {
+		field = d.alias + "." + field
+	}
+
+	if only_trashed {
+		return field + " IS NOT NULL"
+	}
+
+	return field + " IS NULL"
+} // }}}
+
+// 是否使用软删除, 调用后 ForceDelete 失效
+func (d *Dao) useSoftDelete() bool { // {{{
+	force := d.forceDelete
+	d.forceDelete = false
+
+	return d.softDeleteField != "" && !force
+} // }}}
+
+// 软删除时更新的字段
+func (d *Dao) softDeleteRecord() map[string]any { // {{{
+	record := map[string]any{}
+	d.fillTime(record, d.softDeleteField, true)
+	d.fillTime(record, d.updatedField, true)
+
+	return record
+} // }}}
+
+// 按主键软删除
+func (d *Dao) softDelete(id any) (int, error) { // {{{
+	record := d.softDeleteRecord()
+	where, params := d.tenantWhere(d.primary+"=? AND "+d.dialect().Quote(d.softDeleteField)+" IS NULL", []any{id})
+
+	return d.write(func(client db.DBClient, table string) (int, error) {
+		return client.UpdateContext(d.getContext(), table, record, where, params...)
+	}, true)
+} // }}}
+
+// 按条件软删除一条: 先在主库查询主键, 再按主键软删除
+func (d *Dao) softDeleteBy(e *Event) (int, error) { // {{{
+	id, err := d.UseMaster().getOne(d.primary)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	e.Id = id
+
+	return d.softDelete(id)
+} // }}}
+
+// 按条件软删除所有记录
+func (d *Dao) softDeleteAll() (int, error) { // {{{
+	record := d.softDeleteRecord()
+	where, values := d.getFilter()
+
+	return d.write(func(client db.DBClient, table string) (int, error) {
+		return client.UpdateContext(d.getContext(), table, record, where, values...)
+	}, false)
+} // }}}
+
+// 写入时填充时间字段, force 为 false 时只填充不存在或为零值的字段
+func (d *Dao) fillTime(record map[string]any, field string, force bool) { // {{{
+	if field == "" {
+		return
+	}
+
+	if !force {
+		if val, ok := record[field]; ok && !isZero(val) {
+			return
+		}
+
+		if _, ok := record[field+":expr"]; ok {
+			return
+		}
+	}
+
+	delete(record, field+":expr")
+
+	if d.timeFn != nil {
+		record[field] = d.timeFn()
+	} else {
+		record[field] = x.DateTime()
+	}
+} // }}}
+
+// 更新时的乐观锁: 记录中包含版本字段时返回其值作为条件, 并将版本字段更新为加 1
+func (d *Dao) applyVersion(record map[string]any) (any, bool) { // {{{
+	if d.versionField == "" {
+		return nil, false
+	}
+
+	version, ok := record[d.versionField]
+	record[d.versionField] = db.Raw(d.dialect().Quote(d.versionField) + " + 1")
+
+	return version, ok
+} // }}}
+
+func isZero(val any) bool { // {{{
+	if val == nil {
+		return true
+	}
+
+	rv := reflect.ValueOf(val)
+	if rv.Kind() == reflect.Pointer {
+		return rv.IsNil()
+	}
+
+	return rv.IsZero()
+} // }}}
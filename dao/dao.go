@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"github.com/nyxless/nyx/x"
 	"github.com/nyxless/nyx/x/db"
+	"maps"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -14,6 +16,30 @@ import (
 
 type Dao struct {
 	DBWriter, DBReader   db.DBClient
+	replicas             *x.ReplicaPool
+	sharding             *sharding
+	shardVals            []any
+	having               [][]any
+	unions               []*unionPart
+	beforeHooks          []HookFn
+	afterHooks           []HookFn
+	softDeleteField      string
+	withTrashed          bool
+	onlyTrashed          bool
+	forceDelete          bool
+	createdField         string
+	updatedField         string
+	versionField         string
+	timeFn               func() any
+	cachePk              any
+	masterConf           string
+	slaveConf            string
+	dbConf               string
+	baseTable            string
+	tenant               string
+	tenantScoped         bool
+	tenantField          string
+	withoutTenant        bool
 	intx                 bool //是否使用事务
 	table                string
 	primary              string
@@ -27,9 +53,11 @@ type Dao struct {
 	order                []string
 	group                string
 	filter               [][]any //过滤条件
+	condErr              error   //解析条件时的错误(如无效的 :expr 值), 执行时返回
 	forceMaster          bool    //强制使用主库读，只能通过useMaster 使用一次
 	ctx                  context.Context
-	alias                string //表别名
+	timeout              time.Duration //单条 sql 超时时间, 覆盖 DB 配置中的 query_timeout
+	alias                string        //表别名
 	leftJoin             []*Dao
 	innerJoin            []*Dao
 	onPairs              []*onPair
@@ -79,39 +107,14 @@ func (d *Dao) Init(conf_name ...string) { //{{{
 		master_conf_name = conf_name[0]
 	}
 
-	master_conf := x.Conf.GetMap(master_conf_name)
-	if 0 == len(master_conf) {
-		x.Panic("db资源不存在:" + master_conf_name)
-	}
-
-	slave_conf := master_conf
-
 	if len(conf_name) > 1 {
 		slave_conf_name = conf_name[1]
 	}
 
-	slave_confs := x.Conf.GetMapSlice(slave_conf_name)
-
-	if len(slave_confs) > 0 {
-		idx := x.RandIntn(len(slave_confs))
-		slave_conf = slave_confs[idx]
-	}
-
-	if 0 == len(slave_conf) {
-		fmt.Printf("从库资源不存在[ %s ], 使用主库 [ %s ]\n", slave_conf_name, master_conf_name)
-	}
-
-	var err error
-
 	d.defaultFields = "*"
+	d.masterConf, d.slaveConf = master_conf_name, slave_conf_name
 
-	d.DBWriter, err = x.DB.Get(master_conf)
-	if err != nil {
-		x.Panic(err)
-	}
-
-	d.DBReader, err = x.DB.Get(slave_conf)
-	if err != nil {
+	if err := d.useDB(master_conf_name, slave_conf_name); err != nil {
 		x.Panic(err)
 	}
 
@@ -125,22 +128,94 @@ func (d *Dao) Init(conf_name ...string) { //{{{
 	gob.Register([]map[string]interface{}{})
 } // }}}
 
+// 使用指定配置的主库及从库
+func (d *Dao) useDB(master_conf_name, slave_conf_name string) error { //{{{
+	master_conf := x.Conf.GetMap(master_conf_name)
+	if 0 == len(master_conf) {
+		return fmt.Errorf("db资源不存在:%s", master_conf_name)
+	}
+
+	slave_confs := x.Conf.GetMapSlice(slave_conf_name) //从库不存在时使用主库
+
+	writer, err := x.DB.Named(master_conf_name)
+	if err != nil {
+		return err
+	}
+
+	d.DBWriter, d.DBReader, d.replicas = writer, writer, nil
+	d.dbConf = master_conf_name + "," + slave_conf_name
+
+	if len(slave_confs) > 0 {
+		d.replicas, err = x.DB.GetReplicaPool(master_conf, slave_confs)
+		if err != nil {
+			return err
+		}
+
+		d.DBReader = d.replicas.Pick()
+	}
+
+	return nil
+} // }}}
+
 func (d *Dao) InitTx(tx db.DBClient) { //使用事务{{{
 	d.defaultFields = "*"
 	d.autoOrder = true
 	d.DBWriter = tx
 	d.DBReader = tx
+	d.replicas = nil
 	d.intx = true
 } // }}}
 
+// 指定 ctx 后, 所有 sql 均使用该 ctx 执行, ctx 取消(如客户端断开)时中断执行并返回 *db.CanceledError
+// ctx 中有租户时, 按租户配置切换 DB 及 schema
 func (d *Dao) WithContext(ctx context.Context) *Dao {
 	d.ctx = ctx
+	d.applyTenant()
 
 	return d
 }
 
+// 指定之后每条 sql 的超时时间, 如: NewDAOUser().WithContext(ctx).Timeout(time.Second).GetRecords(...)
+func (d *Dao) Timeout(timeout time.Duration) *Dao { // {{{
+	d.timeout = timeout
+
+	return d
+} // }}}
+
+// 当前 DB 的 sql 方言
+func (d *Dao) dialect() db.Dialect { // {{{
+	if d.DBWriter == nil {
+		return db.MySQL
+	}
+
+	return d.DBWriter.Dialect()
+} // }}}
+
+// 执行 sql 使用的 ctx
+func (d *Dao) getContext() context.Context { // {{{
+	ctx := d.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if d.timeout > 0 {
+		ctx = db.WithQueryTimeout(ctx, d.timeout)
+	}
+
+	return ctx
+} // }}}
+
+// 统计 DB 耗时, 用于访问日志的耗时分解, 配合 defer 使用
+func (d *Dao) trackDB(start time.Time) { // {{{
+	if t := x.GetTiming(d.ctx); t != nil {
+		t.TrackDB(start)
+	}
+} // }}}
+
 func (d *Dao) SetTable(table string) {
 	d.table = table
+	d.baseTable = ""
+	d.applySchema()
 }
 
 func (d *Dao) GetTable() string {
@@ -232,7 +307,7 @@ func (d *Dao) ForUpdate(flag ...bool) *Dao { // {{{
 
 // 使用悲观锁
 func (d *Dao) UseLock(flag ...bool) *Dao { // {{{
-	if !d.intx {
+	if !d.inTx() {
 		x.Notice("[UseLock] lock was ignored since no trans")
 		return d
 	}
@@ -456,13 +531,53 @@ func (d *Dao) on(compare, left_field string, right_fields ...string) *Dao { // {
 	return d
 } // }}}
 
+// 通过 ctx 加入的事务(tx.Run), 不存在时返回 nil
+func (d *Dao) ctxTx() db.DBClient { // {{{
+	if d.intx {
+		return nil
+	}
+
+	return db.GetTx(d.ctx, d.DBWriter)
+} // }}}
+
+// 是否在事务中: InitTx 指定或通过 ctx 加入
+func (d *Dao) inTx() bool { // {{{
+	return d.intx || d.ctxTx() != nil
+} // }}}
+
+// 写操作使用, 同时标记 ctx 中已有写入, 之后同一请求中的读操作使用主库
+func (d *Dao) GetDBWriter() db.DBClient { // {{{
+	if tx := d.ctxTx(); tx != nil {
+		return tx
+	}
+
+	db.MarkWritten(d.ctx)
+
+	return d.DBWriter
+} // }}}
+
+// 事务中读写均使用事务; 否则按从库健康状态及权重选择从库, 无可用从库时使用主库
 func (d *Dao) GetDBReader() db.DBClient { // {{{
+	if tx := d.ctxTx(); tx != nil {
+		d.forceMaster = false
+
+		return tx
+	}
+
 	if d.forceMaster {
 		d.forceMaster = false
 
 		return d.DBWriter
 	}
 
+	if d.replicas != nil {
+		if db.IsWritten(d.ctx) {
+			return d.DBWriter
+		}
+
+		return d.replicas.Pick()
+	}
+
 	return d.DBReader
 } // }}}
 
@@ -471,7 +586,13 @@ func (d *Dao) GetDBReader() db.DBClient { // {{{
 // 例2:parseParams("x=? and y=?", []any{1,2}) 等价于 parseParams("a=? and b=?", 1, 2) //若第二个参数非[]any(如[]int、[]string), 可先使用 AsSlice 进行转换
 // 例3:parseParams(map[string]any{"a":1,"b":2}) 等价于 parseParams("a=? and b=?", 1, 2)
 // 例4:parseParams(map[string]any{"a":1,"b":[]any{2, 3}}) 等价于 parseParams("a=? and b in ('2','3')", 1)
+// 例5:parseParams(map[string]any{"a:in": subDao, "b:gt": Raw("c + ?", 1)}) 等价于 parseParams("a in (子查询 sql) and b > c + ?", 子查询参数..., 1)
 func (d *Dao) parseParams(params ...any) (string, []any) { // {{{
+	return d.parseConds(d.alias, params...)
+} // }}}
+
+// 同 parseParams, map 及 Cond 中的字段使用指定的表别名
+func (d *Dao) parseConds(alias string, params ...any) (string, []any) { // {{{
 	if len(params) == 0 || params[0] == nil {
 		return "", nil
 	}
@@ -497,11 +618,15 @@ func (d *Dao) parseParams(params ...any) (string, []any) { // {{{
 		var part string
 		var vals []any
 
+		var err error
+
 		switch v := p.(type) {
 		case *Cond:
-			part, vals = parseCond(v, d.alias)
+			part, vals, err = parseCond(v, alias, d.dialect())
 		case map[string]any:
-			part, vals = parseMap(v, d.alias)
+			part, vals, err = parseMap(v, alias, d.dialect())
+		case *db.RawExpr:
+			part, vals = v.Sql(), v.Args()
 		case string:
 			part = v
 			vals = nil
@@ -511,6 +636,10 @@ func (d *Dao) parseParams(params ...any) (string, []any) { // {{{
 			vals = nil
 		}
 
+		if err != nil && d.condErr == nil {
+			d.condErr = err
+		}
+
 		if part != "" {
 			allParts = append(allParts, part)
 			allVals = append(allVals, vals...)
@@ -525,27 +654,33 @@ func (d *Dao) parseParams(params ...any) (string, []any) { // {{{
 } // }}}
 
 // 解析逻辑组合
-func parseCond(c *Cond, alias string) (string, []any) { // {{{
+func parseCond(c *Cond, alias string, dialect db.Dialect) (string, []any, error) { // {{{
 	if len(c.conds) == 0 {
-		return "", nil
+		return "", nil, nil
 	}
 
 	var parts []string
 	var vals []any
+	var first_err error
 
 	for _, child := range c.conds {
 		var sql string
 		var val []any
+		var err error
 
 		switch ch := child.(type) {
 		case *Cond:
-			sql, val = parseCond(ch, alias)
+			sql, val, err = parseCond(ch, alias, dialect)
 		case map[string]any:
-			sql, val = parseMap(ch, alias)
+			sql, val, err = parseMap(ch, alias, dialect)
 		default:
 			continue
 		}
 
+		if err != nil && first_err == nil {
+			first_err = err
+		}
+
 		if sql != "" {
 			parts = append(parts, "("+sql+")")
 			vals = append(vals, val...)
@@ -553,27 +688,36 @@ func parseCond(c *Cond, alias string) (string, []any) { // {{{
 	}
 
 	if len(parts) == 0 {
-		return "", nil
+		return "", nil, first_err
 	}
 
 	switch c.op {
 	case "NOT":
-		return "NOT (" + parts[0] + ")", vals
+		return "NOT (" + parts[0] + ")", vals, first_err
 	case "AND", "OR":
-		return strings.Join(parts, " "+c.op+" "), vals
+		return strings.Join(parts, " "+c.op+" "), vals, first_err
 	default:
-		return parts[0], vals
+		return parts[0], vals, first_err
 	}
 } // }}}
 
 // 解析 map 条件（支持后缀运算符）
-func parseMap(m map[string]any, defAlias string) (string, []any) { // {{{
+// 无效的值(如 :expr 的值类型不安全、子查询中的错误)返回错误, 该条件替换为恒假条件
+func parseMap(m map[string]any, defAlias string, dialect db.Dialect) (string, []any, error) { // {{{
 	if len(m) == 0 {
-		return "", nil
+		return "", nil, nil
 	}
 
 	var parts []string
 	var vals []any
+	var first_err error
+
+	fail := func(err error) {
+		parts = append(parts, "1=0")
+		if first_err == nil {
+			first_err = err
+		}
+	}
 
 	for key, val := range m {
 		var alias string
@@ -590,69 +734,105 @@ func parseMap(m map[string]any, defAlias string) (string, []any) { // {{{
 			op := key[idx+1:]
 
 			switch op {
-			case "gt":
-				parts = append(parts, fmt.Sprintf("%s`%s` > ?", alias, field))
-				vals = append(vals, val)
-			case "gte":
-				parts = append(parts, fmt.Sprintf("%s`%s` >= ?", alias, field))
-				vals = append(vals, val)
-			case "lt":
-				parts = append(parts, fmt.Sprintf("%s`%s` < ?", alias, field))
-				vals = append(vals, val)
-			case "lte":
-				parts = append(parts, fmt.Sprintf("%s`%s` <= ?", alias, field))
-				vals = append(vals, val)
-			case "ne":
-				parts = append(parts, fmt.Sprintf("%s`%s` != ?", alias, field))
-				vals = append(vals, val)
-			case "like":
-				parts = append(parts, fmt.Sprintf("%s`%s` LIKE ?", alias, field))
-				vals = append(vals, val)
-			case "notlike":
-				parts = append(parts, fmt.Sprintf("%s`%s` NOT LIKE ?", alias, field))
-				vals = append(vals, val)
+			case "gt", "gte", "lt", "lte", "ne", "like", "notlike":
+				ph, vs, err := bindValue(val)
+				if err != nil {
+					fail(err)
+					continue
+				}
+
+				parts = append(parts, fmt.Sprintf("%s%s %s %s", alias, dialect.Quote(field), compareOps[op], ph))
+				vals = append(vals, vs...)
 			case "in":
-				sql, vs := buildIn(alias, field, "IN", val)
+				sql, vs, err := buildIn(dialect, alias, field, "IN", val)
+				if err != nil {
+					fail(err)
+					continue
+				}
+
 				parts = append(parts, sql)
 				vals = append(vals, vs...)
 			case "notin":
-				sql, vs := buildIn(alias, field, "NOT IN", val)
+				sql, vs, err := buildIn(dialect, alias, field, "NOT IN", val)
+				if err != nil {
+					fail(err)
+					continue
+				}
+
 				parts = append(parts, sql)
 				vals = append(vals, vs...)
 			case "btw":
-				sql, vs := buildBetween(alias, field, val)
+				sql, vs := buildBetween(dialect, alias, field, val)
 				parts = append(parts, sql)
 				vals = append(vals, vs...)
 			case "null":
-				parts = append(parts, fmt.Sprintf("%s`%s` IS NULL", alias, field))
+				parts = append(parts, fmt.Sprintf("%s%s IS NULL", alias, dialect.Quote(field)))
 			case "notnull":
-				parts = append(parts, fmt.Sprintf("%s`%s` IS NOT NULL", alias, field))
+				parts = append(parts, fmt.Sprintf("%s%s IS NOT NULL", alias, dialect.Quote(field)))
 			case "expr":
-				parts = append(parts, fmt.Sprintf("%s`%s` = %v", alias, field, val))
+				// 值为 db.Raw、数值或 bool; 字符串原样拼接(已弃用)
+				ph, vs, err := db.ExprValue(val)
+				if err != nil {
+					fail(fmt.Errorf("%s: %w", field, err))
+					continue
+				}
+
+				parts = append(parts, fmt.Sprintf("%s%s = %s", alias, dialect.Quote(field), ph))
+				vals = append(vals, vs...)
 			default:
 				// 默认等于
-				parts = append(parts, fmt.Sprintf("%s`%s` = ?", alias, field))
-				vals = append(vals, val)
+				ph, vs, err := bindValue(val)
+				if err != nil {
+					fail(err)
+					continue
+				}
+
+				parts = append(parts, fmt.Sprintf("%s%s = %s", alias, dialect.Quote(field), ph))
+				vals = append(vals, vs...)
 			}
 		} else {
 			// 无运算符，默认等于
-			parts = append(parts, fmt.Sprintf("%s`%s` = ?", alias, key))
-			vals = append(vals, val)
+			ph, vs, err := bindValue(val)
+			if err != nil {
+				fail(err)
+				continue
+			}
+
+			parts = append(parts, fmt.Sprintf("%s%s = %s", alias, dialect.Quote(key), ph))
+			vals = append(vals, vs...)
 		}
 	}
 
-	return strings.Join(parts, " AND "), vals
+	return strings.Join(parts, " AND "), vals, first_err
 } // }}}
 
-// 构建 IN 条件
-func buildIn(alias, field, op string, val any) (string, []any) { // {{{
+var compareOps = map[string]string{
+	"gt":      ">",
+	"gte":     ">=",
+	"lt":      "<",
+	"lte":     "<=",
+	"ne":      "!=",
+	"like":    "LIKE",
+	"notlike": "NOT LIKE",
+}
+
+// 构建 IN 条件, 值为子查询(*Dao)或 Raw 时使用 IN (sql)
+func buildIn(dialect db.Dialect, alias, field, op string, val any) (string, []any, error) { // {{{
+	switch v := val.(type) {
+	case *Dao:
+		ph, vs, err := bindValue(v)
+		return fmt.Sprintf("%s%s %s %s", alias, dialect.Quote(field), op, ph), vs, err
+	case *db.RawExpr:
+		return fmt.Sprintf("%s%s %s (%s)", alias, dialect.Quote(field), op, v.Sql()), v.Args(), nil
+	}
+
 	v := x.AsSlice(val)
 
 	if len(v) == 0 {
 		if op == "IN" {
-			return "1=0", nil
+			return "1=0", nil, nil
 		}
-		return "1=1", nil
+		return "1=1", nil, nil
 	}
 
 	places := make([]string, len(v))
@@ -660,17 +840,17 @@ func buildIn(alias, field, op string, val any) (string, []any) { // {{{
 		places[i] = "?"
 	}
 
-	return fmt.Sprintf("%s`%s` %s (%s)", alias, field, op, strings.Join(places, ",")), v
+	return fmt.Sprintf("%s%s %s (%s)", alias, dialect.Quote(field), op, strings.Join(places, ",")), v, nil
 } // }}}
 
 // buildBetween 构建 BETWEEN 条件
-func buildBetween(alias, field string, val any) (string, []any) { // {{{
+func buildBetween(dialect db.Dialect, alias, field string, val any) (string, []any) { // {{{
 	v := x.AsSlice(val)
 	if len(v) != 2 {
 		return "1=0", nil
 	}
 
-	return fmt.Sprintf("%s`%s` BETWEEN ? AND ?", alias, field), v
+	return fmt.Sprintf("%s%s BETWEEN ? AND ?", alias, dialect.Quote(field)), v
 } // }}}
 
 func (d *Dao) Where(params ...any) *Dao { //{{{
@@ -683,6 +863,14 @@ func (d *Dao) SetFilter(params ...any) *Dao { //{{{
 	return d
 } //}}}
 
+// 取出并清除解析条件时的错误
+func (d *Dao) takeCondErr() error { // {{{
+	err := d.condErr
+	d.condErr = nil
+
+	return err
+} // }}}
+
 func (d *Dao) getFilter() (string, []any) { // {{{
 	var where string
 	var values []any
@@ -703,12 +891,32 @@ func (d *Dao) getFilter() (string, []any) { // {{{
 		}
 	}
 
+	if sd := d.getSoftDelete(); sd != "" {
+		if where != "" {
+			where += " AND " + sd
+		} else {
+			where = sd
+		}
+	}
+
+	if tw, tv := d.getTenantFilter(d.alias); tw != "" {
+		if where != "" {
+			where += " AND " + tw
+		} else {
+			where = tw
+		}
+
+		values = append(values, tv...)
+	}
+
 	return where, values
 } // }}}
 
 // 在主库执行 sql 操作
 func (d *Dao) Execute(sql string, params ...any) (int, error) { //{{{
-	return d.DBWriter.Execute(sql, params...)
+	defer d.trackDB(time.Now())
+
+	return d.GetDBWriter().ExecuteContext(d.getContext(), sql, params...)
 } // }}}
 
 // 在从库执行 sql 查询单字段, 返回 any
@@ -720,7 +928,7 @@ func (d *Dao) QueryOne(sql string, params ...any) (any, error) { //{{{
 
 	res, err := d.getCache(func() (int, any, error) {
 
-		val, err := d.GetDBReader().QueryOne(sqlOptions...)
+		val, err := d.GetDBReader().QueryOneContext(d.getContext(), sqlOptions...)
 		if err != nil {
 			return 0, nil, err
 		}
@@ -745,7 +953,7 @@ func (d *Dao) QueryRow(sql string, params ...any) (map[string]any, error) { //{{
 
 	res, err := d.getCache(func() (int, any, error) {
 
-		row, err := d.GetDBReader().QueryRow(sqlOptions...)
+		row, err := d.GetDBReader().QueryRowContext(d.getContext(), sqlOptions...)
 		if err != nil {
 			return 0, nil, err
 		}
@@ -770,7 +978,7 @@ func (d *Dao) Query(sql string, params ...any) ([]map[string]any, error) { //{{{
 
 	res, err := d.getCache(func() (int, any, error) {
 
-		data, err := d.GetDBReader().Query(sqlOptions...)
+		data, err := d.GetDBReader().QueryContext(d.getContext(), sqlOptions...)
 		if err != nil {
 			return 0, nil, err
 		}
@@ -792,32 +1000,137 @@ func (d *Dao) QueryStream(sql string, params ...any) (*db.RowIter, error) { //{{
 		db.WithSql(sql, params),
 		db.WithBytes(d.getUseBytes()),
 	}
-	return d.GetDBReader().QueryStream(sqlOptions...)
+	defer d.trackDB(time.Now())
+	return d.GetDBReader().QueryStreamContext(d.getContext(), sqlOptions...)
 } // }}}
 
 // 插入新记录, 支持批量
 func (d *Dao) AddRecord(records ...map[string]any) (int, error) { //{{{
-	return d.DBWriter.Insert(d.table, records...)
+	defer d.trackDB(time.Now())
+
+	for _, record := range records {
+		d.fillTime(record, d.createdField, false)
+		d.fillTime(record, d.updatedField, false)
+
+		if err := d.fillTenant(record, true); err != nil {
+			return 0, err
+		}
+	}
+
+	e := d.newEvent(OpInsert)
+	e.Records = records
+
+	return d.withHooks(e, func() (int, error) {
+		var id int
+		var err error
+
+		if d.sharding != nil {
+			id, err = d.addShardRecords(records)
+		} else {
+			id, err = d.GetDBWriter().InsertContext(db.WithReturning(d.getContext(), d.primary), d.table, records...)
+		}
+
+		if err == nil {
+			e.Affected = len(records)
+			if len(records) == 1 && id > 0 {
+				e.Id = id
+			}
+		}
+
+		return id, err
+	})
 } // }}}
 
 // 按主键更新记录, id 参数为主键值
 func (d *Dao) SetRecord(record map[string]any, id any) (int, error) { //{{{
+	record = maps.Clone(record)
 	delete(record, d.primary)
-	return d.DBWriter.Update(d.table, record, d.primary+"=?", id)
+	d.shardByPrimary(id)
+
+	e := d.newEvent(OpUpdate)
+	e.Id = id
+
+	return d.setRecord(e, record, d.primary+"=?", id)
 } // }}}
 
 // 按条件更新记录
 func (d *Dao) SetRecordBy(record map[string]any, where string, params ...any) (int, error) { //{{{
-	return d.DBWriter.Update(d.table, record, where, params...)
+	record = maps.Clone(record)
+
+	e := d.newEvent(OpUpdate)
+	e.Where = where
+	e.Args = params
+
+	return d.setRecord(e, record, where, params...)
+} // }}}
+
+// record 为调用方记录的副本, 填充时间、租户及版本字段不影响调用方, 版本冲突后可使用原记录重试
+func (d *Dao) setRecord(e *Event, record map[string]any, where string, params ...any) (int, error) { //{{{
+	defer d.trackDB(time.Now())
+
+	if err := d.fillTenant(record, false); err != nil {
+		return 0, err
+	}
+
+	// 更新时总是刷新 updated, 从 GetRecord 读出再写回的记录中带有旧值
+	d.fillTime(record, d.updatedField, true)
+	e.Record = record
+
+	version, check := d.applyVersion(record)
+	if check {
+		where = "(" + where + ") AND " + d.dialect().Quote(d.versionField) + "=?"
+		params = append(append([]any{}, params...), version)
+	}
+
+	where, params = d.tenantWhere(where, params)
+
+	return d.withHooks(e, func() (int, error) {
+		n, err := d.write(func(client db.DBClient, table string) (int, error) {
+			return client.UpdateContext(d.getContext(), table, record, where, params...)
+		}, false)
+
+		if err == nil && check && n == 0 {
+			return 0, ErrVersionConflict
+		}
+
+		return n, err
+	})
 } // }}}
 
 // upsert 操作
 func (d *Dao) ResetRecord(record map[string]any) (int, error) { //{{{
-	return d.DBWriter.Upsert(d.table, record, d.primary)
+	defer d.trackDB(time.Now())
+
+	if d.sharding != nil && len(d.shardVals) == 0 {
+		val, ok := record[d.sharding.key]
+		if !ok {
+			return 0, fmt.Errorf("shard key %s is required", d.sharding.key)
+		}
+
+		d.Shard(val)
+	}
+
+	d.fillTime(record, d.updatedField, false)
+
+	if err := d.fillTenant(record, true); err != nil {
+		return 0, err
+	}
+
+	e := d.newEvent(OpUpsert)
+	e.Id = record[d.primary]
+	e.Record = record
+
+	return d.withHooks(e, func() (int, error) {
+		return d.write(func(client db.DBClient, table string) (int, error) {
+			return client.UpsertContext(d.upsertContext(), table, record, d.primary)
+		}, false)
+	})
 } // }}}
 
 // 按主键查询记录
 func (d *Dao) GetRecord(id any) (map[string]any, error) { //{{{
+	d.shardByPrimary(id)
+
 	var primary string
 	if d.alias != "" {
 		primary = d.alias + "." + d.primary
@@ -825,20 +1138,32 @@ func (d *Dao) GetRecord(id any) (map[string]any, error) { //{{{
 		primary = d.primary
 	}
 
+	where := primary + "=?"
+	if sd := d.getSoftDelete(); sd != "" {
+		where += " AND " + sd
+	}
+
+	args := []any{id}
+	if tw, tv := d.getTenantFilter(d.alias); tw != "" {
+		where += " AND " + tw
+		args = append(args, tv...)
+	}
+
 	sqlOptions := []db.FnSqlOption{
 		db.WithTable(d.table),
 		db.WithFields(d.GetFields()),
 		db.WithAlias(d.alias),
 		db.WithLeftJoin(d.parseJoin(d.leftJoin)),
 		db.WithInnerJoin(d.parseJoin(d.innerJoin)),
-		db.WithWhere(primary+"=?", []any{id}),
+		db.WithWhere(where, args),
 		db.WithBytes(d.getUseBytes()),
 		db.WithLock(d.getLock()),
 	}
 
+	d.cachePk = id
 	res, err := d.getCache(func() (int, any, error) {
 
-		row, err := d.GetDBReader().GetRow(sqlOptions...)
+		row, err := d.queryRow(sqlOptions)
 		if err != nil {
 			return 0, nil, err
 		}
@@ -866,6 +1191,11 @@ func (d *Dao) parseJoin(joinons []*Dao) []string { // {{{
 	for i, v := range joinons {
 		var join string
 
+		// Join 的表使用同一 ctx, 按相同租户过滤及选择 schema
+		if v.ctx == nil && d.ctx != nil {
+			v.WithContext(d.ctx)
+		}
+
 		tbl := v.GetTable()
 		al := v.alias
 		if al == "" {
@@ -889,6 +1219,18 @@ func (d *Dao) parseJoin(joinons []*Dao) []string { // {{{
 			join += d.alias + "." + p.Left + on + al + "." + p.Right
 		}
 
+		// 软删除条件放在 ON 中, LeftJoin 时已删除的记录视为不匹配, 不影响主表的记录
+		if sd := v.getSoftDelete(); sd != "" {
+			if len(v.onPairs) == 0 {
+				join += " ON " + sd
+			} else {
+				join += " AND " + sd
+			}
+
+			// getFilter 中不再重复添加
+			v.withTrashed = true
+		}
+
 		joins = append(joins, join)
 
 		if len(v.fields) > 0 {
@@ -902,6 +1244,10 @@ func (d *Dao) parseJoin(joinons []*Dao) []string { // {{{
 		if where, vals := v.getFilter(); where != "" {
 			d.SetFilter(where, vals)
 		}
+
+		if err := v.takeCondErr(); err != nil && d.condErr == nil {
+			d.condErr = err
+		}
 	}
 
 	return joins
@@ -909,19 +1255,51 @@ func (d *Dao) parseJoin(joinons []*Dao) []string { // {{{
 
 // 按主键删除数据
 func (d *Dao) DelRecord(id any) (int, error) { //{{{
+	d.shardByPrimary(id)
+
+	e := d.newEvent(OpDelete)
+	e.Id = id
+
+	if d.useSoftDelete() {
+		defer d.trackDB(time.Now())
+
+		e.Soft = true
+		return d.withHooks(e, func() (int, error) {
+			return d.softDelete(id)
+		})
+	}
+
 	sqlOptions := []db.FnSqlOption{
 		db.WithTable(d.table),
-		db.WithWhere(d.primary+"=?", []any{id}),
+		db.WithWhere(d.tenantWhere(d.primary+"=?", []any{id})),
 		db.WithLimits("1"),
 	}
 
-	return d.DBWriter.Delete(sqlOptions...)
+	defer d.trackDB(time.Now())
+	return d.withHooks(e, func() (int, error) {
+		return d.write(func(client db.DBClient, table string) (int, error) {
+			return client.DeleteContext(d.getContext(), withShardTable(sqlOptions, table)...)
+		}, true)
+	})
 } // }}}
 
 // 删除符合条件的数据 (一条)
 func (d *Dao) DelRecordBy(params ...any) (int, error) { //{{{
 	d.SetFilter(params...)
 
+	e := d.newEvent(OpDelete)
+
+	if d.useSoftDelete() {
+		defer d.trackDB(time.Now())
+
+		e.Soft = true
+		return d.withHooks(e, func() (int, error) {
+			return d.softDeleteBy(e)
+		})
+	}
+
+	d.WithTrashed()
+
 	sqlOptions := []db.FnSqlOption{
 		db.WithTable(d.table),
 		db.WithOrder(d.getOrder(false)),
@@ -929,19 +1307,40 @@ func (d *Dao) DelRecordBy(params ...any) (int, error) { //{{{
 		db.WithLimits("1"),
 	}
 
-	return d.DBWriter.Delete(sqlOptions...)
+	defer d.trackDB(time.Now())
+	return d.withHooks(e, func() (int, error) {
+		return d.write(func(client db.DBClient, table string) (int, error) {
+			return client.DeleteContext(d.getContext(), withShardTable(sqlOptions, table)...)
+		}, true)
+	})
 } // }}}
 
 // 删除所有符合条件的数据 (Is Dangerous!)
 func (d *Dao) DelRecords(params ...any) (int, error) { //{{{
 	d.SetFilter(params...)
 
+	e := d.newEvent(OpDelete)
+
+	if d.useSoftDelete() {
+		defer d.trackDB(time.Now())
+
+		e.Soft = true
+		return d.withHooks(e, d.softDeleteAll)
+	}
+
+	d.WithTrashed()
+
 	sqlOptions := []db.FnSqlOption{
 		db.WithTable(d.table),
 		db.WithWhere(d.getFilter()),
 	}
 
-	return d.DBWriter.Delete(sqlOptions...)
+	defer d.trackDB(time.Now())
+	return d.withHooks(e, func() (int, error) {
+		return d.write(func(client db.DBClient, table string) (int, error) {
+			return client.DeleteContext(d.getContext(), withShardTable(sqlOptions, table)...)
+		}, false)
+	})
 } // }}}
 
 func (d *Dao) getOne(field string, params ...any) (any, error) { //{{{
@@ -955,6 +1354,8 @@ func (d *Dao) getOne(field string, params ...any) (any, error) { //{{{
 		db.WithInnerJoin(d.parseJoin(d.innerJoin)),
 		db.WithIdx(d.getIndex()),
 		db.WithGroup(d.getGroup()),
+		db.WithHaving(d.getHaving()),
+		db.WithUnion(d.getUnion()),
 		db.WithOrder(d.getOrder(false)),
 		db.WithWhere(d.getFilter()),
 		db.WithBytes(d.getUseBytes()),
@@ -962,7 +1363,7 @@ func (d *Dao) getOne(field string, params ...any) (any, error) { //{{{
 	}
 
 	res, err := d.getCache(func() (int, any, error) {
-		res, err := d.GetDBReader().GetOne(sqlOptions...)
+		res, err := d.queryOne(sqlOptions, false)
 		return 0, res, err
 	}, sqlOptions)
 
@@ -994,6 +1395,8 @@ func (d *Dao) GetValues(field string, params ...any) ([]any, error) { //{{{
 		db.WithInnerJoin(d.parseJoin(d.innerJoin)),
 		db.WithIdx(d.getIndex()),
 		db.WithGroup(d.getGroup()),
+		db.WithHaving(d.getHaving()),
+		db.WithUnion(d.getUnion()),
 		db.WithOrder(d.getOrder(false)),
 		db.WithWhere(d.getFilter()),
 		db.WithBytes(d.getUseBytes()),
@@ -1002,7 +1405,7 @@ func (d *Dao) GetValues(field string, params ...any) ([]any, error) { //{{{
 
 	res, err := d.getCache(func() (int, any, error) {
 
-		list, err := d.GetDBReader().GetAll(sqlOptions...)
+		list, err := d.queryAll(sqlOptions)
 		if err != nil {
 			return 0, nil, err
 		}
@@ -1036,6 +1439,8 @@ func (d *Dao) GetUniqueValues(field string, params ...any) ([]any, error) { //{{
 		db.WithInnerJoin(d.parseJoin(d.innerJoin)),
 		db.WithIdx(d.getIndex()),
 		db.WithGroup(d.getGroup()),
+		db.WithHaving(d.getHaving()),
+		db.WithUnion(d.getUnion()),
 		db.WithOrder(d.getOrder(false)),
 		db.WithWhere(d.getFilter()),
 		db.WithBytes(d.getUseBytes()),
@@ -1044,7 +1449,7 @@ func (d *Dao) GetUniqueValues(field string, params ...any) ([]any, error) { //{{
 
 	res, err := d.getCache(func() (int, any, error) {
 
-		list, err := d.GetDBReader().GetAll(sqlOptions...)
+		list, err := d.queryAll(sqlOptions)
 		if err != nil {
 			return 0, nil, err
 		}
@@ -1083,6 +1488,8 @@ func (d *Dao) GetValuesMap(keyfield, valfield string, params ...any) (map[any]an
 		db.WithInnerJoin(d.parseJoin(d.innerJoin)),
 		db.WithIdx(d.getIndex()),
 		db.WithGroup(d.getGroup()),
+		db.WithHaving(d.getHaving()),
+		db.WithUnion(d.getUnion()),
 		db.WithOrder(d.getOrder(false)),
 		db.WithWhere(d.getFilter()),
 		db.WithBytes(d.getUseBytes()),
@@ -1091,7 +1498,7 @@ func (d *Dao) GetValuesMap(keyfield, valfield string, params ...any) (map[any]an
 
 	res, err := d.getCache(func() (int, any, error) {
 
-		list, err := d.GetDBReader().GetAll(sqlOptions...)
+		list, err := d.queryAll(sqlOptions)
 		if err != nil {
 			return 0, nil, err
 		}
@@ -1118,6 +1525,8 @@ func (d *Dao) GetGroupMap(keyfield string, params ...any) (map[any]map[string]an
 		db.WithInnerJoin(d.parseJoin(d.innerJoin)),
 		db.WithIdx(d.getIndex()),
 		db.WithGroup(d.getGroup()),
+		db.WithHaving(d.getHaving()),
+		db.WithUnion(d.getUnion()),
 		db.WithOrder(d.getOrder(false)),
 		db.WithWhere(d.getFilter()),
 		db.WithBytes(d.getUseBytes()),
@@ -1126,7 +1535,7 @@ func (d *Dao) GetGroupMap(keyfield string, params ...any) (map[any]map[string]an
 
 	res, err := d.getCache(func() (int, any, error) {
 
-		list, err := d.GetDBReader().GetAll(sqlOptions...)
+		list, err := d.queryAll(sqlOptions)
 		if err != nil {
 			return 0, nil, err
 		}
@@ -1153,6 +1562,8 @@ func (d *Dao) GetGroupMaps(keyfield string, params ...any) (map[any][]map[string
 		db.WithInnerJoin(d.parseJoin(d.innerJoin)),
 		db.WithIdx(d.getIndex()),
 		db.WithGroup(d.getGroup()),
+		db.WithHaving(d.getHaving()),
+		db.WithUnion(d.getUnion()),
 		db.WithOrder(d.getOrder(false)),
 		db.WithWhere(d.getFilter()),
 		db.WithBytes(d.getUseBytes()),
@@ -1161,7 +1572,7 @@ func (d *Dao) GetGroupMaps(keyfield string, params ...any) (map[any][]map[string
 
 	res, err := d.getCache(func() (int, any, error) {
 
-		list, err := d.GetDBReader().GetAll(sqlOptions...)
+		list, err := d.queryAll(sqlOptions)
 		if err != nil {
 			return 0, nil, err
 		}
@@ -1190,13 +1601,14 @@ func (d *Dao) GetCount(params ...any) (int, error) { //{{{
 		db.WithInnerJoin(d.parseJoin(d.innerJoin)),
 		db.WithIdx(d.getIndex()),
 		db.WithGroup(d.getGroup()),
+		db.WithHaving(d.getHaving()),
 		db.WithWhere(d.getFilter()),
 		db.WithLock(d.getLock()),
 	}
 
 	res, err := d.getCache(func() (int, any, error) {
 
-		count, err := d.GetDBReader().GetOne(sqlOptions...)
+		count, err := d.queryOne(sqlOptions, true)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				return 0, nil, nil
@@ -1245,7 +1657,7 @@ func (d *Dao) GetRecordBy(params ...any) (map[string]any, error) { //{{{
 
 	res, err := d.getCache(func() (int, any, error) {
 
-		row, err := d.GetDBReader().GetRow(sqlOptions...)
+		row, err := d.queryRow(sqlOptions)
 		if err != nil {
 			return 0, nil, err
 		}
@@ -1273,6 +1685,8 @@ func (d *Dao) GetRecords(params ...any) ([]map[string]any, error) { //{{{
 
 	idx := d.getIndex()
 	group := d.getGroup()
+	having, having_vals := d.getHaving()
+	union, union_vals := d.getUnion()
 	where, values := d.getFilter()
 
 	sqlOptions := []db.FnSqlOption{
@@ -1281,6 +1695,8 @@ func (d *Dao) GetRecords(params ...any) ([]map[string]any, error) { //{{{
 		db.WithAlias(d.alias),
 		db.WithIdx(idx),
 		db.WithGroup(group),
+		db.WithHaving(having, having_vals),
+		db.WithUnion(union, union_vals),
 		db.WithOrder(d.getOrder(true)),
 		db.WithLimits(d.getLimit()),
 		db.WithLeftJoin(left_join),
@@ -1291,7 +1707,7 @@ func (d *Dao) GetRecords(params ...any) ([]map[string]any, error) { //{{{
 	}
 
 	getRecordsFn := func() (int, any, error) {
-		res, err := d.GetDBReader().GetAll(sqlOptions...)
+		res, err := d.queryAll(sqlOptions)
 		return 0, res, err
 	}
 
@@ -1303,14 +1719,20 @@ func (d *Dao) GetRecords(params ...any) ([]map[string]any, error) { //{{{
 			var list []map[string]any
 			var err error
 
-			db_reader := d.GetDBReader()
-			list, err = db_reader.GetAll(sqlOptions...)
+			list, err = d.queryAll(sqlOptions)
 
 			if err != nil {
 				return 0, nil, err
 			}
 
-			count, err = db_reader.GetOne(db.WithTable(d.table), db.WithAlias(d.alias), db.WithLeftJoin(left_join), db.WithInnerJoin(inner_join), db.WithFields("count(1) as total"), db.WithIdx(idx), db.WithGroup(group), db.WithWhere(where, values))
+			countOptions := []db.FnSqlOption{db.WithTable(d.table), db.WithAlias(d.alias), db.WithLeftJoin(left_join), db.WithInnerJoin(inner_join), db.WithFields("count(1) as total"), db.WithIdx(idx), db.WithWhere(where, values)}
+
+			// 分组或合并后的行数: 以完整查询为子查询统计
+			if group != "" || having != "" || union != "" {
+				countOptions = append(slices.Clip(sqlOptions), db.WithCountWrap(true))
+			}
+
+			count, err = d.queryOne(countOptions, true)
 			if err != nil {
 				if err == sql.ErrNoRows {
 					return 0, nil, nil
@@ -1334,9 +1756,23 @@ func (d *Dao) GetRecords(params ...any) ([]map[string]any, error) { //{{{
 func (d *Dao) getCache(fn func() (int, any, error), opts []db.FnSqlOption) (res any, err error) { //{{{
 	use_cache, ttl, refreshInterval, callbackFn := d.getUseCache()
 
+	if t := x.GetTiming(d.ctx); t != nil {
+		query := fn
+		fn = func() (int, any, error) {
+			defer t.TrackDB(time.Now())
+			return query()
+		}
+	}
+
+	var tags string
+	if use_cache && x.LocalCache != nil {
+		tags, use_cache = d.cacheTags()
+	}
+	d.cachePk = nil
+
 	var num int
 	if use_cache && x.LocalCache != nil {
-		cache_data, hit, err := d.getFromCache(fn, opts, ttl, refreshInterval, callbackFn)
+		cache_data, hit, err := d.getFromCache(fn, opts, tags, ttl, refreshInterval, callbackFn)
 		if err != nil {
 			return nil, err
 		}
@@ -1357,9 +1793,18 @@ func (d *Dao) getCache(fn func() (int, any, error), opts []db.FnSqlOption) (res
 	return res, err
 } // }}}
 
-func (d *Dao) getFromCache(fn func() (int, any, error), opts []db.FnSqlOption, ttl, refreshInterval int, callbackFn CacheCallbackFn) (*CacheData, bool, error) { // {{{
-	key := d.getCacheKey(opts)
+func (d *Dao) getFromCache(fn func() (int, any, error), opts []db.FnSqlOption, tags string, ttl, refreshInterval int, callbackFn CacheCallbackFn) (*CacheData, bool, error) { // {{{
+	key := append(d.getCacheKey(opts), tags...)
 	cacheFn := func() ([]byte, bool, error) {
+		remote := daoCache != nil && daoCache.redis != nil
+		var remote_key string
+		if remote {
+			remote_key = daoCache.remoteKey(d.ctx, key)
+			if data, err := daoCache.getRemote(remote_key); err == nil {
+				return data, true, nil
+			}
+		}
+
 		num, res, err := fn()
 		if nil != err {
 			return nil, false, err
@@ -1371,6 +1816,10 @@ func (d *Dao) getFromCache(fn func() (int, any, error), opts []db.FnSqlOption, t
 			return nil, false, err
 		}
 
+		if remote {
+			daoCache.setRemote(remote_key, data, max(ttl, refreshInterval))
+		}
+
 		return data, true, nil
 	}
 
@@ -1436,12 +1885,16 @@ func (d *Dao) getCacheKey(opts []db.FnSqlOption) []byte { // {{{
 		sb.WriteString("#")
 		sb.WriteString(so.GetGroup())
 		sb.WriteString("#")
+		sb.WriteString(so.GetHaving())
+		sb.WriteString("#")
+		sb.WriteString(so.GetUnion())
+		sb.WriteString("#")
 		sb.WriteString(so.GetOrder())
 		sb.WriteString("#")
 		sb.WriteString(so.GetLimits())
 	}
 
-	for _, v := range so.GetVals() {
+	for _, v := range so.GetAllVals() {
 		sb.WriteString(",")
 		sb.WriteString(x.AsString(v))
 	}
@@ -1450,7 +1903,10 @@ func (d *Dao) getCacheKey(opts []db.FnSqlOption) []byte { // {{{
 		sb.WriteString("#1")
 	}
 
-	return []byte(sb.String())
+	sb.WriteString(d.shardCacheKey())
+
+	// 租户可能映射到不同的 DB, 相同的 sql 按租户区分缓存
+	return []byte(x.TenantKey(d.ctx, sb.String()))
 } // }}}
 
 type CacheData struct {
@@ -0,0 +1,539 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/nyxless/nyx/x"
+	"github.com/nyxless/nyx/x/db"
+	"modernc.org/sqlite"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// 使用 sqlite 运行 dao 测试:
+// db_sqlite 使用 SQLite 方言; db_sqlite_pg 使用 $n 占位符及 RETURNING(与 postgres 相同), 用于在 sqlite 上验证 Rebind 及 RETURNING
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "nyx_dao_test")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	code := func() int {
+		defer os.RemoveAll(dir)
+
+		if err := setupTestDB(dir); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		defer x.DB.Close()
+
+		return m.Run()
+	}()
+
+	os.Exit(code)
+}
+
+// sqlite 上模拟 postgres 的占位符及 RETURNING
+type sqlitePgDialect struct {
+	db.Dialect
+}
+
+func (s sqlitePgDialect) Rebind(query string) string {
+	return db.PostgreSQL.Rebind(query)
+}
+
+func (s sqlitePgDialect) Returning(primary string) string {
+	return db.PostgreSQL.Returning(primary)
+}
+
+func setupTestDB(dir string) error {
+	sql.Register("sqlite_pg", &sqlite.Driver{})
+	db.RegisterDialect("sqlite_pg", sqlitePgDialect{db.SQLite})
+	x.RegisterSqlDriver("sqlite_pg", func(conf x.MAP) string {
+		return x.AsString(conf["database"])
+	})
+
+	conf_file := filepath.Join(dir, "app.conf")
+	conf := fmt.Sprintf("db_sqlite:\n    type: sqlite\n    database: %s\n\ndb_sqlite_pg:\n    type: sqlite_pg\n    database: %s\n",
+		filepath.Join(dir, "sqlite.db"), filepath.Join(dir, "sqlite_pg.db"))
+
+	if err := os.WriteFile(conf_file, []byte(conf), 0644); err != nil {
+		return err
+	}
+
+	var err error
+	x.Conf, _, err = x.NewConfig(conf_file)
+	if err != nil {
+		return err
+	}
+
+	x.DB = x.NewDBProxy()
+
+	return nil
+}
+
+var testConfs = []string{"db_sqlite", "db_sqlite_pg"}
+
+// 列名使用保留字, 验证引用
+func newTestDao(t *testing.T, conf_name string) *Dao {
+	t.Helper()
+
+	d := &Dao{}
+	d.Init(conf_name)
+	d.SetTable("items")
+	d.SetPrimary("id")
+
+	if _, err := d.Execute(`DROP TABLE IF EXISTS items`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.Execute(`CREATE TABLE items (id INTEGER PRIMARY KEY AUTOINCREMENT, "order" INTEGER, "group" TEXT, name TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+func testDao(conf_name string) *Dao {
+	d := &Dao{}
+	d.Init(conf_name)
+	d.SetTable("items")
+	d.SetPrimary("id")
+
+	return d
+}
+
+func TestDaoQuote(t *testing.T) {
+	for _, conf_name := range testConfs {
+		t.Run(conf_name, func(t *testing.T) {
+			newTestDao(t, conf_name)
+
+			id, err := testDao(conf_name).AddRecord(map[string]any{"order": 1, "group": "a", "name": "x"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := testDao(conf_name).SetRecord(map[string]any{"order": 2, "group": "b"}, id); err != nil {
+				t.Fatal(err)
+			}
+
+			row, err := testDao(conf_name).GetRecordBy(map[string]any{"group": "b", "order:gte": 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if x.AsInt(row["id"]) != id || x.AsInt(row["order"]) != 2 {
+				t.Fatalf("unexpected row: %v", row)
+			}
+		})
+	}
+}
+
+func TestDaoRebind(t *testing.T) {
+	for _, conf_name := range testConfs {
+		t.Run(conf_name, func(t *testing.T) {
+			newTestDao(t, conf_name)
+
+			for i := 1; i <= 3; i++ {
+				if _, err := testDao(conf_name).AddRecord(map[string]any{"order": i, "group": "g", "name": fmt.Sprintf("n%d", i)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// 字符串常量中的 ? 不作为占位符
+			rows, err := testDao(conf_name).Order("id").GetRecords(`"group" = ? AND name <> '?' AND "order" IN (?, ?)`, "g", 2, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(rows) != 2 || x.AsString(rows[0]["name"]) != "n2" || x.AsString(rows[1]["name"]) != "n3" {
+				t.Fatalf("unexpected rows: %v", rows)
+			}
+
+			n, err := testDao(conf_name).GetCount(map[string]any{"group": "g", "order:in": []any{1, 3}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if n != 2 {
+				t.Fatalf("count = %d, want 2", n)
+			}
+		})
+	}
+}
+
+func TestDaoUpsert(t *testing.T) {
+	for _, conf_name := range testConfs {
+		t.Run(conf_name, func(t *testing.T) {
+			newTestDao(t, conf_name)
+
+			if _, err := testDao(conf_name).ResetRecord(map[string]any{"id": 7, "order": 1, "group": "a", "name": "x"}); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := testDao(conf_name).ResetRecord(map[string]any{"id": 7, "order": 2, "group": "a", "name": "y"}); err != nil {
+				t.Fatal(err)
+			}
+
+			rows, err := testDao(conf_name).GetRecords()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(rows) != 1 || x.AsInt(rows[0]["order"]) != 2 || x.AsString(rows[0]["name"]) != "y" {
+				t.Fatalf("unexpected rows: %v", rows)
+			}
+
+			res, err := testDao(conf_name).BatchUpsert([]map[string]any{
+				{"id": 7, "order": 3, "group": "a", "name": "z"},
+				{"id": 8, "order": 4, "group": "a", "name": "w"},
+			}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.Affected < 2 {
+				t.Fatalf("affected = %d", res.Affected)
+			}
+
+			row, err := testDao(conf_name).GetRecord(7)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if x.AsString(row["name"]) != "z" {
+				t.Fatalf("unexpected row: %v", row)
+			}
+		})
+	}
+}
+
+func TestDaoLimit(t *testing.T) {
+	for _, conf_name := range testConfs {
+		t.Run(conf_name, func(t *testing.T) {
+			newTestDao(t, conf_name)
+
+			for i := 1; i <= 5; i++ {
+				if _, err := testDao(conf_name).AddRecord(map[string]any{"order": i, "group": "g", "name": fmt.Sprintf("n%d", i)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			rows, err := testDao(conf_name).Order("id").Limit(2).GetRecords()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(rows) != 2 || x.AsInt(rows[0]["id"]) != 1 {
+				t.Fatalf("unexpected rows: %v", rows)
+			}
+
+			// offset, limit
+			rows, err = testDao(conf_name).Order("id").Limit(1, 2).GetRecords()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(rows) != 2 || x.AsInt(rows[0]["id"]) != 2 || x.AsInt(rows[1]["id"]) != 3 {
+				t.Fatalf("unexpected rows: %v", rows)
+			}
+
+			// DELETE ... LIMIT 使用 rowid 子查询
+			n, err := testDao(conf_name).DelRecordBy(map[string]any{"group": "g"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if n != 1 {
+				t.Fatalf("deleted = %d, want 1", n)
+			}
+		})
+	}
+}
+
+func TestDaoReturning(t *testing.T) {
+	for _, conf_name := range testConfs {
+		t.Run(conf_name, func(t *testing.T) {
+			newTestDao(t, conf_name)
+
+			for want := 1; want <= 3; want++ {
+				id, err := testDao(conf_name).AddRecord(map[string]any{"order": want, "group": "g", "name": "n"})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if id != want {
+					t.Fatalf("id = %d, want %d", id, want)
+				}
+			}
+		})
+	}
+}
+
+type testItem struct {
+	Id    int64  `db:"id,omitempty"`
+	Order int    `db:"order"`
+	Group string `db:"group"`
+	Name  string `db:"name,omitempty"`
+}
+
+// omitempty 字段只在部分记录中为零值时, 批量写入使用字段的并集
+func TestTypedBatchOmitempty(t *testing.T) {
+	d := newTestDao(t, "db_sqlite")
+	items := NewTyped[testItem](d)
+
+	if _, err := items.AddRecord(&testItem{Order: 1, Name: "a"}, &testItem{Order: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewTyped[testItem](testDao("db_sqlite")).BatchInsert([]*testItem{{Order: 3}, {Order: 4, Name: "d"}}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := NewTyped[testItem](testDao("db_sqlite")).Order("id").GetRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 4 || list[0].Name != "a" || list[1].Name != "" || list[3].Name != "d" || list[3].Id != 4 {
+		t.Fatalf("unexpected records: %+v", list)
+	}
+}
+
+// 字符串形式的整数与整数分到同一分表
+func TestHashShard(t *testing.T) {
+	s := HashShard(16)
+	for _, val := range []any{123, int64(123), "123", []byte("123"), 123.0} {
+		shard, err := s.Shard(val)
+		if err != nil || shard != "11" {
+			t.Fatalf("HashShard(%#v) = %q, %v, want 11", val, shard, err)
+		}
+	}
+
+	if _, err := s.Shard("abc"); err == nil {
+		t.Fatal("HashShard should reject non-integer string keys")
+	}
+
+	a, _ := HashStringShard(16).Shard("abc")
+	b, _ := HashStringShard(16).Shard([]byte("abc"))
+	if a != b || a != strconv.Itoa(x.Crc32("abc")%16) {
+		t.Fatalf("HashStringShard = %q, %q", a, b)
+	}
+}
+
+// 游标中大于 2^53 的整数不丢失精度
+func TestCursorPrecision(t *testing.T) {
+	vals := []any{int64(1<<60 + 1), uint64(1<<63 + 1), "a", 1.5}
+	cursor := encodeCursor(vals, "id asc,")
+
+	got, err := decodeCursor(cursor, "id asc,")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != len(vals) {
+		t.Fatalf("decodeCursor = %v", got)
+	}
+
+	for i := range vals {
+		if got[i] != vals[i] {
+			t.Fatalf("decodeCursor[%d] = %#v, want %#v", i, got[i], vals[i])
+		}
+	}
+
+	if _, err := decodeCursor(cursor, "id desc,"); err == nil {
+		t.Fatal("cursor with another sign should be rejected")
+	}
+}
+
+// 含 UNION 或 GROUP BY 时, WithCount 统计合并或分组后的行数
+func TestWithCountUnionGroup(t *testing.T) {
+	newTestDao(t, "db_sqlite")
+
+	for i := 1; i <= 5; i++ {
+		if _, err := testDao("db_sqlite").AddRecord(map[string]any{"order": i, "group": fmt.Sprintf("g%d", i%2), "name": "n"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var total int
+	rows, err := testDao("db_sqlite").SetFields("id").Where(map[string]any{"group": "g1"}).
+		UnionAll(testDao("db_sqlite").SetFields("id").Where(map[string]any{"group": "g0"})).
+		WithCount(&total).Limit(2).GetRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 2 || total != 5 {
+		t.Fatalf("union: rows = %d, total = %d, want 2, 5", len(rows), total)
+	}
+
+	rows, err = testDao("db_sqlite").SetFields(`"group"`, "count(1) as n").Group(`"group"`).WithCount(&total).GetRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 2 || total != 2 {
+		t.Fatalf("group: rows = %d, total = %d, want 2, 2", len(rows), total)
+	}
+}
+
+func TestConventions(t *testing.T) {
+	conv := func() *Dao {
+		d := &Dao{}
+		d.Init("db_sqlite")
+		d.SetTable("notes")
+		d.SetPrimary("id")
+		d.SetSoftDelete("deleted_at")
+		d.SetTimestamps("created_at", "updated_at")
+		d.SetVersionField("version")
+		return d
+	}
+
+	newTestDao(t, "db_sqlite")
+	d := conv()
+	if _, err := d.Execute(`DROP TABLE IF EXISTS notes`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.Execute(`CREATE TABLE notes (id INTEGER PRIMARY KEY AUTOINCREMENT, item_id INTEGER, body TEXT, version INTEGER DEFAULT 1, created_at TEXT, updated_at TEXT, deleted_at TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := conv().AddRecord(map[string]any{"item_id": 1, "body": "a", "updated_at": "2000-01-01 00:00:00"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 版本冲突后使用原记录重试, 记录未被修改
+	record := map[string]any{"body": "b", "version": 2, "updated_at": "2000-01-01 00:00:00"}
+	if _, err := conv().SetRecord(record, 1); err != ErrVersionConflict {
+		t.Fatalf("SetRecord with stale version: err = %v", err)
+	}
+
+	if record["version"] != 2 || record["updated_at"] != "2000-01-01 00:00:00" {
+		t.Fatalf("caller record modified: %v", record)
+	}
+
+	record["version"] = 1
+	if n, err := conv().SetRecord(record, 1); err != nil || n != 1 {
+		t.Fatalf("SetRecord retry: n = %d, err = %v", n, err)
+	}
+
+	row, err := conv().GetRecord(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if x.AsInt(row["version"]) != 2 || row["updated_at"] == "2000-01-01 00:00:00" {
+		t.Fatalf("after update: %v", row)
+	}
+
+	// LeftJoin 的表中已删除的记录视为不匹配
+	if _, err := testDao("db_sqlite").AddRecord(map[string]any{"order": 1, "name": "n"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := conv().DelRecord(1); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := testDao("db_sqlite").Alias("i").SetFields("i.id").
+		LeftJoin(conv().Alias("n").On("id", "item_id").SetFields("body")).GetRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 1 || rows[0]["body"] != nil {
+		t.Fatalf("left join soft deleted: %v", rows)
+	}
+}
+
+func TestTenantUpsertGuard(t *testing.T) {
+	newTestDao(t, "db_sqlite")
+
+	tenant := func(tid string) *Dao {
+		d := testDao("db_sqlite")
+		d.SetTenantScope("group")
+		return d.WithContext(x.WithTenant(context.Background(), tid))
+	}
+
+	if _, err := tenant("a").AddRecord(map[string]any{"id": 1, "name": "a1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 与其他租户的记录主键冲突时不作修改
+	if _, err := tenant("b").ResetRecord(map[string]any{"id": 1, "name": "b1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tenant("b").BatchUpsert([]map[string]any{{"id": 1, "name": "b2"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	row, err := testDao("db_sqlite").GetRecord(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if row["group"] != "a" || row["name"] != "a1" {
+		t.Fatalf("cross tenant upsert: %v", row)
+	}
+
+	if _, err := tenant("a").ResetRecord(map[string]any{"id": 1, "name": "a2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if row, _ := testDao("db_sqlite").GetRecord(1); row["name"] != "a2" {
+		t.Fatalf("same tenant upsert: %v", row)
+	}
+}
+
+func TestExprCondition(t *testing.T) {
+	newTestDao(t, "db_sqlite")
+
+	if _, err := testDao("db_sqlite").AddRecord(map[string]any{"order": 1, "name": "n"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := testDao("db_sqlite").GetRecords(map[string]any{"order:expr": 1})
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("numeric :expr: rows = %v, err = %v", rows, err)
+	}
+
+	if _, err := testDao("db_sqlite").GetRecords(map[string]any{"order:expr": []byte("1")}); err == nil {
+		t.Fatal("invalid :expr value should return an error")
+	}
+
+	if _, err := testDao("db_sqlite").DelRecords(map[string]any{"order:expr": struct{}{}}); err == nil {
+		t.Fatal("invalid :expr value in delete should return an error")
+	}
+}
+
+func TestCompareLargeIds(t *testing.T) {
+	const base = int64(1) << 60
+
+	if c := compareValues(base+1, base); c != 1 {
+		t.Fatalf("compareValues(2^60+1, 2^60) = %d", c)
+	}
+
+	if c := compareValues(uint64(1)<<63, int64(-1)); c != 1 {
+		t.Fatalf("compareValues(uint64 2^63, -1) = %d", c)
+	}
+
+	if c := compareValues(strconv.FormatInt(base, 10), base+1); c != -1 {
+		t.Fatalf("compareValues(\"2^60\", 2^60+1) = %d", c)
+	}
+
+	rows := []map[string]any{{"id": base}, {"id": base + 2}, {"id": base + 1}}
+	sortRows(rows, "id desc")
+
+	for i, want := range []int64{base + 2, base + 1, base} {
+		if rows[i]["id"] != want {
+			t.Fatalf("sortRows id desc = %v", rows)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"github.com/nyxless/nyx/x"
+	"github.com/nyxless/nyx/x/db"
+	"runtime/debug"
+	"slices"
+	"sync"
+)
+
+// 写操作的钩子及变更事件
+// 用法:
+//
+//	// 全局钩子, 所有 Dao 的写操作都会执行
+//	dao.OnBefore(func(e *dao.Event) error {
+//		if e.Op == dao.OpDelete && e.Table == "user" {
+//			return errors.New("user can not be deleted")
+//		}
+//		return nil
+//	})
+//
+//	// 单个 Dao 的钩子, 通常在 NewDAOXxx 中注册
+//	ins.OnAfter(func(e *dao.Event) error {
+//		_, err := NewDAOAuditLog().WithContext(e.Ctx).AddRecord(...) // 使用 e.Ctx 时在同一事务中执行
+//		return err
+//	})
+//
+//	// 订阅提交后的变更事件, 如缓存失效、outbox
+//	dao.Subscribe(func(e *dao.Event) {
+//		cache.Del(fmt.Sprintf("%s:%v", e.Table, e.Id))
+//	})
+//
+// 钩子在写操作所在的 goroutine 及事务中同步执行: Before 返回错误时不执行写操作, After 返回错误时作为写操作的错误返回(tx.Run 中将回滚)
+// 事件在写操作成功且事务提交后发送给订阅者, 事务回滚(包括回滚到 SAVEPOINT)时丢弃; 不在事务中时写操作成功后立即发送
+// 旧值需要额外查询, 事件中不提供
+const (
+	OpInsert = "insert"
+	OpUpdate = "update"
+	OpUpsert = "upsert"
+	OpDelete = "delete"
+)
+
+// 写操作事件
+type Event struct {
+	Ctx      context.Context
+	Tx       db.DBClient // 当前事务, 不在事务中时为 nil
+	Op       string      // OpInsert | OpUpdate | OpUpsert | OpDelete
+	Table    string
+	Primary  string           // 主键字段名
+	Id       any              // 主键值: SetRecord/DelRecord/Restore 的参数, 单条 AddRecord 返回的自增 id, DelRecordBy 删除的记录
+	Records  []map[string]any // AddRecord 的记录
+	Record   map[string]any   // 更新的字段, Before 钩子中可修改
+	Where    string           // SetRecordBy 的条件
+	Args     []any
+	Soft     bool // 软删除
+	Affected int  // 影响的行数, After 钩子及事件中有效
+}
+
+type HookFn func(e *Event) error
+
+type subscriber struct {
+	id int
+	fn func(*Event)
+}
+
+var (
+	hookMutex    sync.RWMutex
+	beforeHooks  []HookFn
+	afterHooks   []HookFn
+	subscribers  []*subscriber
+	subscriberId int
+)
+
+// 注册全局的写操作前钩子
+func OnBefore(fn HookFn) { // {{{
+	hookMutex.Lock()
+	beforeHooks = append(beforeHooks, fn)
+	hookMutex.Unlock()
+} // }}}
+
+// 注册全局的写操作后钩子
+func OnAfter(fn HookFn) { // {{{
+	hookMutex.Lock()
+	afterHooks = append(afterHooks, fn)
+	hookMutex.Unlock()
+} // }}}
+
+// 订阅提交后的变更事件, 返回取消订阅的函数
+// fn 同步执行, 耗时的处理应自行异步执行
+func Subscribe(fn func(e *Event)) func() { // {{{
+	hookMutex.Lock()
+	subscriberId++
+	id := subscriberId
+	subscribers = append(subscribers, &subscriber{id, fn})
+	hookMutex.Unlock()
+
+	return func() {
+		hookMutex.Lock()
+		subscribers = slices.DeleteFunc(slices.Clone(subscribers), func(s *subscriber) bool {
+			return s.id == id
+		})
+		hookMutex.Unlock()
+	}
+} // }}}
+
+// 注册当前 Dao 的写操作前钩子, 在全局钩子之后执行
+func (d *Dao) OnBefore(fn HookFn) *Dao { // {{{
+	d.beforeHooks = append(d.beforeHooks, fn)
+	return d
+} // }}}
+
+// 注册当前 Dao 的写操作后钩子, 在全局钩子之后执行
+func (d *Dao) OnAfter(fn HookFn) *Dao { // {{{
+	d.afterHooks = append(d.afterHooks, fn)
+	return d
+} // }}}
+
+func (d *Dao) newEvent(op string) *Event { // {{{
+	e := &Event{
+		Ctx:     d.getContext(),
+		Op:      op,
+		Table:   d.table,
+		Primary: d.primary,
+	}
+
+	if tx := d.ctxTx(); tx != nil {
+		e.Tx = tx
+	} else if d.intx {
+		e.Tx = d.DBWriter
+	}
+
+	return e
+} // }}}
+
+// 执行写操作及钩子, 成功后发送事件
+func (d *Dao) withHooks(e *Event, fn func() (int, error)) (int, error) { // {{{
+	hookMutex.RLock()
+	befores := append(beforeHooks[:len(beforeHooks):len(beforeHooks)], d.beforeHooks...)
+	afters := append(afterHooks[:len(afterHooks):len(afterHooks)], d.afterHooks...)
+	has_subscribers := len(subscribers) > 0
+	hookMutex.RUnlock()
+
+	for _, hook := range befores {
+		if err := hook(e); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := fn()
+	if err != nil {
+		return n, err
+	}
+
+	// 插入操作的返回值为自增 id, 影响行数由 fn 设置
+	if e.Op != OpInsert {
+		e.Affected = n
+	}
+
+	for _, hook := range afters {
+		if err := hook(e); err != nil {
+			return n, err
+		}
+	}
+
+	if has_subscribers {
+		if e.Tx != nil {
+			db.AfterCommit(e.Tx, func() { publish(e) })
+		} else {
+			publish(e)
+		}
+	}
+
+	return n, nil
+} // }}}
+
+func publish(e *Event) { // {{{
+	hookMutex.RLock()
+	subs := subscribers
+	hookMutex.RUnlock()
+
+	for _, sub := range subs {
+		func() {
+			defer func() {
+				if err := recover(); err != nil {
+					x.Warn("[dao] event subscriber panic:", fmt.Sprintf("%v\n%s", err, debug.Stack()))
+				}
+			}()
+
+			sub.fn(e)
+		}()
+	}
+} // }}}
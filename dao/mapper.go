@@ -0,0 +1,353 @@
+package dao
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"github.com/nyxless/nyx/x"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// 结构体字段与表字段的映射, 使用 db tag:
+//
+//	Uid     int64          `db:"uid"`               // 字段名
+//	Name    sql.NullString `db:"name"`              // 可为 NULL 的字段, 也可使用指针类型 *string
+//	Avatar  string         `db:"avatar,omitempty"`  // 写入时零值忽略
+//	Profile *Profile       `db:"profile,json"`      // json 字段, 读取时解析, 写入时编码
+//	Ctime   time.Time      `db:"ctime,readonly"`    // 只读, 写入时忽略
+//	Ext     string         `db:"-"`                 // 忽略
+//
+// 未指定 tag 时使用字段名的蛇形格式(如 UserName => user_name), 匿名嵌入的结构体字段展开处理
+type fieldInfo struct {
+	column    string
+	index     []int
+	omitempty bool
+	json      bool
+	readonly  bool
+}
+
+type typeInfo struct {
+	fields  []*fieldInfo
+	columns map[string]*fieldInfo
+}
+
+var (
+	typeInfoCache sync.Map // reflect.Type => *typeInfo
+
+	timeType    = reflect.TypeOf(time.Time{})
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	valuerType  = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+)
+
+// 取得结构体的映射信息, 按类型缓存
+func getTypeInfo(t reflect.Type) *typeInfo { // {{{
+	if info, ok := typeInfoCache.Load(t); ok {
+		return info.(*typeInfo)
+	}
+
+	info := &typeInfo{columns: map[string]*fieldInfo{}}
+	parseFields(t, nil, info)
+
+	actual, _ := typeInfoCache.LoadOrStore(t, info)
+	return actual.(*typeInfo)
+} // }}}
+
+func parseFields(t reflect.Type, parent []int, info *typeInfo) { // {{{
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, has_tag := f.Tag.Lookup("db")
+		if tag == "-" {
+			continue
+		}
+
+		index := append(append([]int{}, parent...), i)
+
+		// 匿名嵌入的结构体(未指定 tag 时)展开
+		if f.Anonymous && !has_tag {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+
+			if ft.Kind() == reflect.Struct && ft != timeType {
+				parseFields(ft, index, info)
+				continue
+			}
+		}
+
+		if !f.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = toSnake(f.Name)
+		}
+
+		field := &fieldInfo{column: name, index: index}
+		for _, opt := range strings.Split(opts, ",") {
+			switch strings.TrimSpace(opt) {
+			case "omitempty":
+				field.omitempty = true
+			case "json":
+				field.json = true
+			case "readonly":
+				field.readonly = true
+			}
+		}
+
+		// 外层字段优先
+		if _, ok := info.columns[name]; ok {
+			continue
+		}
+
+		info.fields = append(info.fields, field)
+		info.columns[name] = field
+	}
+} // }}}
+
+// UserName => user_name, UID => uid
+func toSnake(name string) string { // {{{
+	runes := []rune(name)
+	var sb strings.Builder
+
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				sb.WriteByte('_')
+			}
+			sb.WriteRune(unicode.ToLower(r))
+		} else {
+			sb.WriteRune(r)
+		}
+	}
+
+	return sb.String()
+} // }}}
+
+// 结构体的所有表字段名, 可用于 SetFields
+func Columns[T any]() []string { // {{{
+	info := getTypeInfo(reflect.TypeFor[T]())
+
+	columns := make([]string, len(info.fields))
+	for i, f := range info.fields {
+		columns[i] = f.column
+	}
+
+	return columns
+} // }}}
+
+// 将查询结果映射到结构体
+func ScanRow[T any](row map[string]any) (*T, error) { // {{{
+	if row == nil {
+		return nil, nil
+	}
+
+	obj := new(T)
+	rv := reflect.ValueOf(obj).Elem()
+	info := getTypeInfo(rv.Type())
+
+	for column, val := range row {
+		field, ok := info.columns[column]
+		if !ok {
+			continue
+		}
+
+		fv := fieldByIndex(rv, field.index)
+		if err := setValue(fv, val, field.json); err != nil {
+			return nil, fmt.Errorf("scan column %s: %v", column, err)
+		}
+	}
+
+	return obj, nil
+} // }}}
+
+func ScanRows[T any](rows []map[string]any) ([]*T, error) { // {{{
+	list := make([]*T, 0, len(rows))
+	for _, row := range rows {
+		obj, err := ScanRow[T](row)
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, obj)
+	}
+
+	return list, nil
+} // }}}
+
+// 按 index 取字段, 途经的 nil 指针自动初始化
+func fieldByIndex(v reflect.Value, index []int) reflect.Value { // {{{
+	for i, idx := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+
+		v = v.Field(idx)
+	}
+
+	return v
+} // }}}
+
+func setValue(fv reflect.Value, val any, is_json bool) error { // {{{
+	if val == nil {
+		fv.SetZero()
+		return nil
+	}
+
+	if is_json {
+		data := x.AsBytes(val)
+		if len(data) == 0 {
+			fv.SetZero()
+			return nil
+		}
+
+		return x.JsonUnmarshal(data, fv.Addr().Interface())
+	}
+
+	// sql.NullString 等实现了 sql.Scanner 的类型
+	if fv.Addr().Type().Implements(scannerType) {
+		return fv.Addr().Interface().(sql.Scanner).Scan(val)
+	}
+
+	if fv.Kind() == reflect.Pointer {
+		elem := reflect.New(fv.Type().Elem())
+		if err := setValue(elem.Elem(), val, false); err != nil {
+			return err
+		}
+
+		fv.Set(elem)
+		return nil
+	}
+
+	if fv.Type() == timeType {
+		fv.Set(reflect.ValueOf(x.AsTime(val)))
+		return nil
+	}
+
+	rv := reflect.ValueOf(val)
+	if rv.Type().AssignableTo(fv.Type()) {
+		fv.Set(rv)
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(x.AsString(val))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		fv.SetInt(x.AsInt64(val))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		fv.SetUint(x.AsUint64(val))
+	case reflect.Float32, reflect.Float64:
+		fv.SetFloat(x.AsFloat64(val))
+	case reflect.Bool:
+		fv.SetBool(x.AsBool(val))
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() == reflect.Uint8 {
+			fv.SetBytes(x.AsBytes(val))
+			return nil
+		}
+		fallthrough
+	default:
+		if rv.Type().ConvertibleTo(fv.Type()) {
+			fv.Set(rv.Convert(fv.Type()))
+			return nil
+		}
+
+		return fmt.Errorf("cannot convert %T to %s", val, fv.Type())
+	}
+
+	return nil
+} // }}}
+
+// 将结构体转换为写入的记录
+// 指定 columns 时只包含这些字段(零值也写入), 否则包含所有非只读字段, omitempty 字段为零值时忽略
+func toRecord(obj any, columns ...string) (map[string]any, error) { // {{{
+	rv := reflect.ValueOf(obj)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil, fmt.Errorf("record is nil")
+		}
+		rv = rv.Elem()
+	}
+
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("record must be struct, got %s", rv.Type())
+	}
+
+	info := getTypeInfo(rv.Type())
+	record := map[string]any{}
+
+	for _, field := range info.fields {
+		if len(columns) > 0 {
+			if !slices.Contains(columns, field.column) {
+				continue
+			}
+		} else if field.readonly {
+			continue
+		}
+
+		fv, ok := fieldByIndexRead(rv, field.index)
+		if !ok {
+			continue
+		}
+
+		if len(columns) == 0 && field.omitempty && fv.IsZero() {
+			continue
+		}
+
+		val, err := fieldValue(fv, field.json)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", field.column, err)
+		}
+
+		record[field.column] = val
+	}
+
+	return record, nil
+} // }}}
+
+// 按 index 读取字段, 途经 nil 指针时返回 false
+func fieldByIndexRead(v reflect.Value, index []int) (reflect.Value, bool) { // {{{
+	for i, idx := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+
+		v = v.Field(idx)
+	}
+
+	return v, true
+} // }}}
+
+func fieldValue(fv reflect.Value, is_json bool) (any, error) { // {{{
+	if fv.Kind() == reflect.Pointer && fv.IsNil() {
+		return nil, nil
+	}
+
+	if is_json {
+		return string(x.JsonEncodeToBytes(fv.Interface())), nil
+	}
+
+	// sql.NullString 等实现了 driver.Valuer 的类型
+	if fv.Type().Implements(valuerType) {
+		return fv.Interface().(driver.Valuer).Value()
+	}
+
+	if fv.Kind() == reflect.Pointer {
+		return fieldValue(fv.Elem(), false)
+	}
+
+	return fv.Interface(), nil
+} // }}}
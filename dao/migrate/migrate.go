@@ -0,0 +1,600 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"github.com/nyxless/nyx/x"
+	"github.com/nyxless/nyx/x/db"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 数据库版本迁移
+//
+// migrations 目录下的 sql 文件, 按版本号(建议使用时间戳)排序执行:
+//
+//	20240101120000_create_user.up.sql
+//	20240101120000_create_user.down.sql
+//
+// 也可以使用 go 代码, 在项目的 migrations 包中注册(需在 main.go 中引入该包):
+//
+//	func init() {
+//		migrate.Register(20240101120000, "create_user", up, down)
+//	}
+//
+// 已执行的版本记录在 schema_migrations 表中, 执行期间通过 schema_migrations_lock 表加锁, 保证只有一个实例执行
+
+// 使用 go 代码实现的迁移函数, client 为事务
+type MigrateFunc func(ctx context.Context, client db.DBClient) error
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      MigrateFunc
+	Down    MigrateFunc
+	UpSql   string
+	DownSql string
+	Source  string // 来源: sql 文件路径或 go
+}
+
+// 迁移状态
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	Source    string
+}
+
+var (
+	registered   = map[int64]*Migration{}
+	registeredMu sync.Mutex
+
+	migrationFileRegex = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+)
+
+// 注册 go 代码实现的迁移, 一般在 init 中调用
+func Register(version int64, name string, up, down MigrateFunc) { // {{{
+	registeredMu.Lock()
+	defer registeredMu.Unlock()
+
+	if _, ok := registered[version]; ok {
+		panic(fmt.Sprintf("migration version %d already registered", version))
+	}
+
+	registered[version] = &Migration{
+		Version: version,
+		Name:    name,
+		Up:      up,
+		Down:    down,
+		Source:  "go",
+	}
+} // }}}
+
+type Migrator struct {
+	client      db.DBClient
+	dir         string
+	table       string
+	lockTTL     time.Duration
+	lockTimeout time.Duration
+	owner       string
+}
+
+type FuncOption func(*Migrator)
+
+// 版本记录表名, 默认 schema_migrations
+func WithTable(table string) FuncOption { // {{{
+	return func(m *Migrator) {
+		m.table = table
+	}
+} // }}}
+
+// 等待其他实例释放锁的最长时间, 默认 1 分钟
+func WithLockTimeout(timeout time.Duration) FuncOption { // {{{
+	return func(m *Migrator) {
+		m.lockTimeout = timeout
+	}
+} // }}}
+
+// 锁的有效期, 超过后视为异常退出遗留的锁, 默认 10 分钟
+func WithLockTTL(ttl time.Duration) FuncOption { // {{{
+	return func(m *Migrator) {
+		m.lockTTL = ttl
+	}
+} // }}}
+
+func NewMigrator(client db.DBClient, dir string, opts ...FuncOption) *Migrator { // {{{
+	host, _ := os.Hostname()
+
+	m := &Migrator{
+		client:      client,
+		dir:         dir,
+		table:       "schema_migrations",
+		lockTTL:     10 * time.Minute,
+		lockTimeout: time.Minute,
+		owner:       fmt.Sprintf("%s:%d", host, os.Getpid()),
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+} // }}}
+
+// 使用 x.DB 及配置创建: migrate.db(默认 db_master)、migrate.dir(默认 migrations)、migrate.table
+func NewDefaultMigrator() (*Migrator, error) { // {{{
+	conf_name := x.Conf.GetDefString("db_master", "migrate", "db")
+
+	conf := x.Conf.GetMap(conf_name)
+	if len(conf) == 0 {
+		return nil, fmt.Errorf("db资源不存在: %s", conf_name)
+	}
+
+	client, err := x.DB.Named(conf_name)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := x.Conf.GetDefString("migrations", "migrate", "dir")
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(x.AppRoot, dir)
+	}
+
+	return NewMigrator(client, dir,
+		WithTable(x.Conf.GetDefString("schema_migrations", "migrate", "table")),
+		WithLockTimeout(time.Duration(x.Conf.GetDefInt(60, "migrate", "lock_timeout"))*time.Second),
+	), nil
+} // }}}
+
+// 所有迁移: migrations 目录下的 sql 文件及注册的 go 迁移, 按版本号排序
+func (m *Migrator) Migrations() ([]*Migration, error) { // {{{
+	all := map[int64]*Migration{}
+
+	registeredMu.Lock()
+	for version, mig := range registered {
+		all[version] = mig
+	}
+	registeredMu.Unlock()
+
+	entries, err := os.ReadDir(m.dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		matches := migrationFileRegex.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || matches == nil {
+			continue
+		}
+
+		version, _ := strconv.ParseInt(matches[1], 10, 64)
+		file := filepath.Join(m.dir, entry.Name())
+
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := all[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: matches[2], Source: file}
+			all[version] = mig
+		} else if mig.Source == "go" {
+			return nil, fmt.Errorf("migration version %d defined in both go and %s", version, file)
+		}
+
+		if matches[3] == "up" {
+			mig.UpSql = string(content)
+			mig.Source = file
+		} else {
+			mig.DownSql = string(content)
+		}
+	}
+
+	list := make([]*Migration, 0, len(all))
+	for _, mig := range all {
+		list = append(list, mig)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Version < list[j].Version
+	})
+
+	return list, nil
+} // }}}
+
+// 执行未执行的迁移, steps <= 0 时执行全部, 返回执行的迁移
+func (m *Migrator) Up(ctx context.Context, steps int) ([]*Migration, error) { // {{{
+	var done []*Migration
+
+	err := m.withLock(ctx, func() error {
+		migrations, applied, err := m.load(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range migrations {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+
+			if steps > 0 && len(done) >= steps {
+				break
+			}
+
+			if err := m.apply(ctx, mig, true); err != nil {
+				return err
+			}
+
+			done = append(done, mig)
+		}
+
+		return nil
+	})
+
+	return done, err
+} // }}}
+
+// 回滚已执行的迁移, steps <= 0 时回滚 1 个, 返回回滚的迁移
+func (m *Migrator) Down(ctx context.Context, steps int) ([]*Migration, error) { // {{{
+	if steps <= 0 {
+		steps = 1
+	}
+
+	var done []*Migration
+
+	err := m.withLock(ctx, func() error {
+		migrations, applied, err := m.load(ctx)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			mig := migrations[i]
+			if _, ok := applied[mig.Version]; !ok {
+				continue
+			}
+
+			if err := m.apply(ctx, mig, false); err != nil {
+				return err
+			}
+
+			done = append(done, mig)
+		}
+
+		return nil
+	})
+
+	return done, err
+} // }}}
+
+// 所有迁移的执行状态, 包括记录表中存在但已找不到定义的版本
+func (m *Migrator) Status(ctx context.Context) ([]*Status, error) { // {{{
+	migrations, applied, err := m.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*Status
+	for _, mig := range migrations {
+		st := &Status{Version: mig.Version, Name: mig.Name, Source: mig.Source}
+		if at, ok := applied[mig.Version]; ok {
+			st.Applied = true
+			st.AppliedAt = at
+			delete(applied, mig.Version)
+		}
+
+		list = append(list, st)
+	}
+
+	for version, at := range applied {
+		list = append(list, &Status{Version: version, Applied: true, AppliedAt: at, Source: "(missing)"})
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Version < list[j].Version
+	})
+
+	return list, nil
+} // }}}
+
+// 读取所有迁移及已执行的版本
+func (m *Migrator) load(ctx context.Context) ([]*Migration, map[int64]time.Time, error) { // {{{
+	if err := m.ensureTable(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	migrations, err := m.Migrations()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := m.client.QueryContext(ctx, db.WithSql("SELECT version, applied_at FROM "+m.table, nil))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	applied := map[int64]time.Time{}
+	for _, row := range rows {
+		applied[x.AsInt64(row["version"])] = time.Unix(x.AsInt64(row["applied_at"]), 0)
+	}
+
+	return migrations, applied, nil
+} // }}}
+
+func (m *Migrator) ensureTable(ctx context.Context) error { // {{{
+	_, err := m.client.ExecuteContext(ctx, "CREATE TABLE IF NOT EXISTS "+m.table+" (version BIGINT NOT NULL PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at BIGINT NOT NULL)")
+	if err != nil {
+		return fmt.Errorf("create table %s: %v", m.table, err)
+	}
+
+	_, err = m.client.ExecuteContext(ctx, "CREATE TABLE IF NOT EXISTS "+m.table+"_lock (id INT NOT NULL PRIMARY KEY, owner VARCHAR(255) NOT NULL, locked_at BIGINT NOT NULL)")
+	if err != nil {
+		return fmt.Errorf("create table %s_lock: %v", m.table, err)
+	}
+
+	return nil
+} // }}}
+
+// 在事务中执行单个迁移并记录版本
+func (m *Migrator) apply(ctx context.Context, mig *Migration, up bool) (err error) { // {{{
+	fn, sqls := mig.Down, mig.DownSql
+	if up {
+		fn, sqls = mig.Up, mig.UpSql
+	}
+
+	if fn == nil && strings.TrimSpace(sqls) == "" {
+		if up {
+			return fmt.Errorf("migration %d_%s has no up", mig.Version, mig.Name)
+		}
+		return fmt.Errorf("migration %d_%s has no down", mig.Version, mig.Name)
+	}
+
+	tx, err := m.client.BeginContext(ctx, false)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%v", e)
+		}
+
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("migration %d_%s: %v", mig.Version, mig.Name, err)
+		}
+	}()
+
+	if fn != nil {
+		err = fn(ctx, tx)
+	} else {
+		for _, stmt := range SplitStatements(sqls, m.client.Dialect()) {
+			if _, err = tx.ExecuteContext(ctx, stmt); err != nil {
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if up {
+		_, err = tx.InsertContext(ctx, m.table, map[string]any{
+			"version":    mig.Version,
+			"name":       mig.Name,
+			"applied_at": time.Now().Unix(),
+		})
+	} else {
+		_, err = tx.DeleteContext(ctx, db.WithTable(m.table), db.WithWhere("version=?", []any{mig.Version}))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+} // }}}
+
+// 加锁执行, 锁被占用时每秒重试, 直到超时
+func (m *Migrator) withLock(ctx context.Context, fn func() error) error { // {{{
+	if err := m.ensureTable(ctx); err != nil {
+		return err
+	}
+
+	lock_table := m.table + "_lock"
+	deadline := time.Now().Add(m.lockTimeout)
+
+	for {
+		// 清理异常退出遗留的锁
+		m.client.DeleteContext(ctx, db.WithTable(lock_table), db.WithWhere("id=1 AND locked_at<?", []any{time.Now().Add(-m.lockTTL).Unix()}))
+
+		_, err := m.client.InsertContext(ctx, lock_table, map[string]any{
+			"id":        1,
+			"owner":     m.owner,
+			"locked_at": time.Now().Unix(),
+		})
+
+		if err == nil {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			owner, _ := m.client.QueryOneContext(ctx, db.WithSql("SELECT owner FROM "+lock_table+" WHERE id=1", nil))
+			return fmt.Errorf("migration is locked by %v", owner)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+
+	defer m.client.DeleteContext(context.Background(), db.WithTable(lock_table), db.WithWhere("id=1 AND owner=?", []any{m.owner}))
+
+	stop := m.heartbeat(lock_table)
+	defer stop()
+
+	return fn()
+} // }}}
+
+// 持有锁期间每 lockTTL/3 刷新 locked_at, 避免执行时间超过 lockTTL 的迁移被其他实例视为遗留的锁而清理
+// 返回的函数停止刷新, 须在释放锁之前调用
+func (m *Migrator) heartbeat(lock_table string) func() { // {{{
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(max(m.lockTTL/3, time.Second))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				n, err := m.client.UpdateContext(ctx, lock_table, map[string]any{"locked_at": time.Now().Unix()}, "id=1 AND owner=?", m.owner)
+				cancel()
+
+				if err != nil {
+					x.Warn("[migrate] refresh lock error:", err)
+				} else if n == 0 {
+					x.Warn("[migrate] lock is lost, owner:", m.owner)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+} // }}}
+
+// 按分号拆分 sql 文件中的多条语句, 跳过字符串中的分号及注释; dialect 为空时按 mysql 处理
+// 字符串中连续两个引号表示引号本身; mysql 的字符串支持反斜杠转义, postgres 只在 E'...' 中支持
+// postgres 的 $$ ... $$ 或 $tag$ ... $tag$ (如函数体)整体作为字符串
+func SplitStatements(content string, dialect ...db.Dialect) []string { // {{{
+	name := "mysql"
+	if len(dialect) > 0 && dialect[0] != nil {
+		name = dialect[0].Name()
+	}
+
+	var stmts []string
+	var sb strings.Builder
+	var quote byte
+	var escape bool
+
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+
+		if quote != 0 {
+			sb.WriteByte(c)
+
+			switch {
+			case c == '\\' && escape && i+1 < len(content):
+				i++
+				sb.WriteByte(content[i])
+			case c == quote && i+1 < len(content) && content[i+1] == quote:
+				i++
+				sb.WriteByte(content[i])
+			case c == quote:
+				quote = 0
+			}
+
+			continue
+		}
+
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			escape = c != '`' && (name == "mysql" || name == "postgres" && c == '\'' && isEscapeString(content, i))
+			sb.WriteByte(c)
+		case c == '$' && name == "postgres" && (i == 0 || !isIdentChar(content[i-1])):
+			tag := dollarTag(content[i:])
+			if tag == "" {
+				sb.WriteByte(c)
+				break
+			}
+
+			end := strings.Index(content[i+len(tag):], tag)
+			if end < 0 {
+				end = len(content)
+			} else {
+				end = i + len(tag) + end + len(tag)
+			}
+
+			sb.WriteString(content[i:end])
+			i = end - 1
+		case c == '-' && i+1 < len(content) && content[i+1] == '-', c == '#' && name == "mysql":
+			// 单行注释
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+			sb.WriteByte('\n')
+		case c == '/' && i+1 < len(content) && content[i+1] == '*':
+			// 多行注释
+			end := strings.Index(content[i+2:], "*/")
+			if end < 0 {
+				i = len(content)
+			} else {
+				i += end + 3
+			}
+		case c == ';':
+			if stmt := strings.TrimSpace(sb.String()); stmt != "" {
+				stmts = append(stmts, stmt)
+			}
+			sb.Reset()
+		default:
+			sb.WriteByte(c)
+		}
+	}
+
+	if stmt := strings.TrimSpace(sb.String()); stmt != "" {
+		stmts = append(stmts, stmt)
+	}
+
+	return stmts
+} // }}}
+
+// postgres 的 E'...' 字符串
+func isEscapeString(content string, i int) bool { // {{{
+	if i == 0 || content[i-1] != 'E' && content[i-1] != 'e' {
+		return false
+	}
+
+	return i == 1 || !isIdentChar(content[i-2])
+} // }}}
+
+// content 开头的 dollar 引号标记, 如 $$ 或 $body$; $1 等占位符返回空
+func dollarTag(content string) string { // {{{
+	for j := 1; j < len(content); j++ {
+		c := content[j]
+		if c == '$' {
+			return content[:j+1]
+		}
+
+		if !isIdentChar(c) || j == 1 && c >= '0' && c <= '9' {
+			return ""
+		}
+	}
+
+	return ""
+} // }}}
+
+func isIdentChar(c byte) bool { // {{{
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+} // }}}
@@ -0,0 +1,42 @@
+package migrate
+
+import (
+	"github.com/nyxless/nyx/x/db"
+	"slices"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	cases := []struct {
+		dialect db.Dialect
+		content string
+		want    []string
+	}{
+		{nil, "INSERT INTO t VALUES ('it\\'s; ok'); SELECT 1", []string{"INSERT INTO t VALUES ('it\\'s; ok')", "SELECT 1"}},
+		{db.MySQL, `INSERT INTO t VALUES ("a\"; b", 'c''; d'); -- x; y` + "\nSELECT 2; # z;", []string{`INSERT INTO t VALUES ("a\"; b", 'c''; d')`, "SELECT 2"}},
+		{db.PostgreSQL, `INSERT INTO t VALUES ('C:\', 'a''; b', E'x\'; y'); SELECT 3`, []string{`INSERT INTO t VALUES ('C:\', 'a''; b', E'x\'; y')`, "SELECT 3"}},
+		{
+			db.PostgreSQL,
+			"CREATE FUNCTION f() RETURNS int AS $$ BEGIN RETURN 1; END; $$ LANGUAGE plpgsql;\nCREATE FUNCTION g() RETURNS int AS $body$ SELECT $1; $body$ LANGUAGE sql; SELECT 4",
+			[]string{
+				"CREATE FUNCTION f() RETURNS int AS $$ BEGIN RETURN 1; END; $$ LANGUAGE plpgsql",
+				"CREATE FUNCTION g() RETURNS int AS $body$ SELECT $1; $body$ LANGUAGE sql",
+				"SELECT 4",
+			},
+		},
+		{db.SQLite, "INSERT INTO t VALUES ('a\\'); SELECT 5", []string{"INSERT INTO t VALUES ('a\\')", "SELECT 5"}},
+	}
+
+	for _, c := range cases {
+		var got []string
+		if c.dialect == nil {
+			got = SplitStatements(c.content)
+		} else {
+			got = SplitStatements(c.content, c.dialect)
+		}
+
+		if !slices.Equal(got, c.want) {
+			t.Errorf("SplitStatements(%q) = %q, want %q", c.content, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package dao
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/nyxless/nyx/x"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 未指定或超出范围时使用的每页条数
+var (
+	DefaultPageSize = 20
+	MaxPageSize     = 1000
+)
+
+// 分页结果
+// Paginate: Total 为总数, Page 为当前页
+// Cursor: Next 为下一页的游标, 没有更多数据时为空
+type Page[T any] struct {
+	Items   []T    `json:"items"`
+	Total   int    `json:"total,omitempty"`
+	Page    int    `json:"page,omitempty"`
+	Size    int    `json:"size"`
+	Next    string `json:"next,omitempty"`
+	HasMore bool   `json:"has_more"`
+}
+
+// 按页码分页, page 从 1 开始, 同时查询总数; 支持 Order/WithCache 等链式方法
+// 如: NewDAOUser().Order("ctime desc").Paginate(page, 20, map[string]any{"status": 1})
+func (d *Dao) Paginate(page, size int, params ...any) (*Page[map[string]any], error) { // {{{
+	page = max(page, 1)
+	size = pageSize(size)
+
+	var total int
+	list, err := d.Limit((page-1)*size, size).WithCount(&total).GetRecords(params...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Page[map[string]any]{
+		Items:   list,
+		Total:   total,
+		Page:    page,
+		Size:    size,
+		HasMore: page*size < total,
+	}, nil
+} // }}}
+
+// 游标(keyset)分页, cursor 为上一页返回的 Next, 首页传空
+// 按 Order 指定的字段排序(默认按主键倒序), 未包含主键时自动追加主键保证顺序唯一, 查询结果中须包含这些字段
+// 不查询总数, 深翻页时性能不受影响; 游标经过签名, 与排序不一致或被篡改时返回 x.ErrParams
+// 如: NewDAOFeed().Order("ctime desc").Cursor(cursor, 20, map[string]any{"uid": uid})
+func (d *Dao) Cursor(cursor string, size int, params ...any) (*Page[map[string]any], error) { // {{{
+	size = pageSize(size)
+
+	fields := d.cursorFields()
+
+	orders := make([]string, len(fields))
+	for i, f := range fields {
+		orders[i] = f.expr + " " + f.direction()
+	}
+	d.order = nil
+	d.Order(orders...)
+
+	if cursor != "" {
+		vals, err := decodeCursor(cursor, cursorSign(fields))
+		if err == nil && len(vals) != len(fields) {
+			err = fmt.Errorf("invalid cursor")
+		}
+
+		if err != nil {
+			return nil, x.ErrParams.With("cursor").Wrap(err)
+		}
+
+		d.SetFilter(keysetWhere(d, fields, vals))
+	}
+
+	list, err := d.Limit(size + 1).GetRecords(params...)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page[map[string]any]{
+		Items: list,
+		Size:  size,
+	}
+
+	if len(list) > size {
+		page.Items = list[:size]
+		page.HasMore = true
+
+		last := page.Items[size-1]
+		vals := make([]any, len(fields))
+		for i, f := range fields {
+			val, ok := last[f.name]
+			if !ok {
+				return nil, fmt.Errorf("cursor field %s not found in result", f.name)
+			}
+
+			vals[i] = cursorValue(val)
+		}
+
+		page.Next = encodeCursor(vals, cursorSign(fields))
+	}
+
+	return page, nil
+} // }}}
+
+func pageSize(size int) int { // {{{
+	if size <= 0 {
+		return DefaultPageSize
+	}
+
+	return min(size, MaxPageSize)
+} // }}}
+
+type cursorField struct {
+	expr string // 排序表达式, 如 t.ctime
+	name string // 结果中的字段名, 如 ctime
+	desc bool
+}
+
+func (f *cursorField) direction() string { // {{{
+	if f.desc {
+		return "DESC"
+	}
+
+	return "ASC"
+} // }}}
+
+// 游标分页的排序字段: Order 指定的字段 + 主键
+func (d *Dao) cursorFields() []*cursorField { // {{{
+	var fields []*cursorField
+	has_primary := false
+
+	for _, order := range d.order {
+		for _, part := range strings.Split(order, ",") {
+			words := strings.Fields(part)
+			if len(words) == 0 {
+				continue
+			}
+
+			name := words[0]
+			if dot := strings.LastIndex(name, "."); dot >= 0 {
+				name = name[dot+1:]
+			}
+			name = strings.Trim(name, "`\"")
+
+			if name == d.primary {
+				has_primary = true
+			}
+
+			fields = append(fields, &cursorField{
+				expr: words[0],
+				name: name,
+				desc: len(words) > 1 && strings.EqualFold(words[1], "desc"),
+			})
+		}
+	}
+
+	if !has_primary {
+		desc := true
+		if len(fields) > 0 {
+			desc = fields[len(fields)-1].desc
+		}
+
+		expr := d.primary
+		if d.alias != "" {
+			expr = d.alias + "." + expr
+		}
+
+		fields = append(fields, &cursorField{expr: expr, name: d.primary, desc: desc})
+	}
+
+	return fields
+} // }}}
+
+// (a < ?) OR (a = ? AND b < ?) ...
+func keysetWhere(d *Dao, fields []*cursorField, vals []any) (string, []any) { // {{{
+	dialect := d.dialect()
+
+	columns := make([]string, len(fields))
+	for i, f := range fields {
+		prefix := ""
+		if dot := strings.LastIndex(f.expr, "."); dot >= 0 {
+			prefix = f.expr[:dot+1]
+		} else if d.alias != "" {
+			prefix = d.alias + "."
+		}
+
+		columns[i] = prefix + dialect.Quote(f.name)
+	}
+
+	var ors []string
+	var args []any
+
+	for i, f := range fields {
+		var ands []string
+		for j := 0; j < i; j++ {
+			ands = append(ands, columns[j]+" = ?")
+			args = append(args, vals[j])
+		}
+
+		op := " > ?"
+		if f.desc {
+			op = " < ?"
+		}
+
+		ands = append(ands, columns[i]+op)
+		args = append(args, vals[i])
+
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+
+	return "(" + strings.Join(ors, " OR ") + ")", args
+} // }}}
+
+// 游标中的值, 时间转换为数据库可比较的格式
+func cursorValue(val any) any { // {{{
+	switch v := val.(type) {
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05.999999")
+	case []byte:
+		return string(v)
+	}
+
+	return val
+} // }}}
+
+// 排序方式的标识, 游标只能用于相同的排序
+func cursorSign(fields []*cursorField) string { // {{{
+	var sb strings.Builder
+	for _, f := range fields {
+		sb.WriteString(f.name)
+		sb.WriteString(" ")
+		sb.WriteString(f.direction())
+		sb.WriteString(",")
+	}
+
+	return sb.String()
+} // }}}
+
+// 游标签名使用的密钥, 配置 cursor_secret, 未配置时使用进程启动时生成的随机密钥(多实例部署时须配置)
+var cursorSecret = x.RandStr(32)
+
+func getCursorSecret() string { // {{{
+	if x.ConfCursorSecret != "" {
+		return x.ConfCursorSecret
+	}
+
+	return cursorSecret
+} // }}}
+
+// base64(json) + "." + 签名
+func encodeCursor(vals []any, sign string) string { // {{{
+	payload := base64.RawURLEncoding.EncodeToString(x.JsonEncodeToBytes(vals))
+
+	return payload + "." + x.Sha256(sign+payload, getCursorSecret())
+} // }}}
+
+func decodeCursor(cursor, sign string) ([]any, error) { // {{{
+	payload, mac, ok := strings.Cut(cursor, ".")
+	if !ok || len(mac) != 64 || !x.VerifySha256(mac, sign+payload, getCursorSecret()) {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// 数字按原文解析, 避免大于 2^53 的整数(如雪花 id)转换为 float64 后丢失精度
+	var vals []any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&vals); err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	for i, val := range vals {
+		if num, ok := val.(json.Number); ok {
+			vals[i] = cursorNumber(num)
+		}
+	}
+
+	return vals, nil
+} // }}}
+
+// 游标中的数字: 整数使用 int64(超出范围时 uint64), 其他使用 float64
+func cursorNumber(num json.Number) any { // {{{
+	if n, err := num.Int64(); err == nil {
+		return n
+	}
+
+	if n, err := strconv.ParseUint(num.String(), 10, 64); err == nil {
+		return n
+	}
+
+	if f, err := num.Float64(); err == nil {
+		return f
+	}
+
+	return num.String()
+} // }}}
@@ -0,0 +1,232 @@
+package dao
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/nyxless/nyx/x"
+	"github.com/nyxless/nyx/x/db"
+	"strings"
+)
+
+// 原生 sql 片段, 见 db.Raw
+// 作为条件值: map[string]any{"score:gt": dao.Raw("avg_score * ?", 1.5)}
+// 作为独立条件: Where(dao.Raw("FIND_IN_SET(?, tags)", tag))
+// 作为写入值: SetRecord(map[string]any{"views": dao.Raw("views + ?", 1)}, id)
+func Raw(sql string, args ...any) *db.RawExpr { // {{{
+	return db.Raw(sql, args...)
+} // }}}
+
+type unionPart struct {
+	all bool
+	dao *Dao
+}
+
+// HAVING 条件, 参数同 Where; map 中的字段不添加表别名, 可直接使用聚合字段的别名
+// 如: Group("uid").SetFields("uid, count(1) as cnt").Having(map[string]any{"cnt:gt": 10}).GetRecords()
+func (d *Dao) Having(params ...any) *Dao { // {{{
+	d.having = append(d.having, params)
+	return d
+} // }}}
+
+func (d *Dao) getHaving() (string, []any) { // {{{
+	var parts []string
+	var values []any
+
+	for _, params := range d.having {
+		w, v := d.parseConds("", params...)
+		if w != "" {
+			parts = append(parts, w)
+			values = append(values, v...)
+		}
+	}
+
+	d.having = nil
+
+	return strings.Join(parts, " AND "), values
+} // }}}
+
+// UNION 合并其他查询的结果(去重), 当前 Dao 的 Order 及 Limit 作用于合并后的结果
+// 被合并的 Dao 中指定了 Order 或 Limit 时, 作为子查询合并
+// 如: NewDAOUser().SetFields("uid, name").Where(...).Union(NewDAOUserArchive().SetFields("uid, name").Where(...)).Order("uid desc").GetRecords()
+func (d *Dao) Union(others ...*Dao) *Dao { // {{{
+	for _, o := range others {
+		d.unions = append(d.unions, &unionPart{dao: o})
+	}
+
+	return d
+} // }}}
+
+// UNION ALL 合并其他查询的结果(不去重)
+func (d *Dao) UnionAll(others ...*Dao) *Dao { // {{{
+	for _, o := range others {
+		d.unions = append(d.unions, &unionPart{all: true, dao: o})
+	}
+
+	return d
+} // }}}
+
+func (d *Dao) getUnion() (string, []any) { // {{{
+	if len(d.unions) == 0 {
+		return "", nil
+	}
+
+	var sb strings.Builder
+	var values []any
+
+	for i, u := range d.unions {
+		if u.all {
+			sb.WriteString(" UNION ALL ")
+		} else {
+			sb.WriteString(" UNION ")
+		}
+
+		wrap := len(u.dao.order) > 0 || u.dao.limit != ""
+		query, vals := u.dao.ToSql()
+		if err := u.dao.takeCondErr(); err != nil && d.condErr == nil {
+			d.condErr = err
+		}
+
+		if wrap {
+			fmt.Fprintf(&sb, "SELECT * FROM (%s) nyx_union_%d", query, i)
+		} else {
+			sb.WriteString(query)
+		}
+
+		values = append(values, vals...)
+	}
+
+	d.unions = nil
+
+	return sb.String(), values
+} // }}}
+
+// 生成查询 sql 及参数, 不执行; 用于子查询、UNION 或调试
+// 子查询作为条件值时自动调用, 如: map[string]any{"uid:in": NewDAOOrder().SetFields("uid").Where(map[string]any{"amount:gt": 100})}
+func (d *Dao) ToSql(params ...any) (string, []any) { // {{{
+	d.SetFilter(params...)
+
+	left_join := d.parseJoin(d.leftJoin)
+	inner_join := d.parseJoin(d.innerJoin)
+	having, having_vals := d.getHaving()
+	union, union_vals := d.getUnion()
+
+	so := &db.SqlOption{}
+	for _, opt := range []db.FnSqlOption{
+		db.WithTable(d.table),
+		db.WithFields(d.GetFields()),
+		db.WithAlias(d.alias),
+		db.WithIdx(d.getIndex()),
+		db.WithGroup(d.getGroup()),
+		db.WithHaving(having, having_vals),
+		db.WithUnion(union, union_vals),
+		db.WithOrder(d.getOrder(false)),
+		db.WithLimits(d.getLimit()),
+		db.WithLeftJoin(left_join),
+		db.WithInnerJoin(inner_join),
+		db.WithWhere(d.getFilter()),
+		db.WithLock(d.getLock()),
+		db.WithDialect(d.dialect()),
+	} {
+		opt(so)
+	}
+
+	return so.ToSql()
+} // }}}
+
+// 条件值的占位符及参数: 子查询(*Dao)及 Raw 展开为 sql 片段, 其他值使用 ?
+func bindValue(val any) (string, []any, error) { // {{{
+	if sub, ok := val.(*Dao); ok {
+		query, vals := sub.ToSql()
+		return "(" + query + ")", vals, sub.takeCondErr()
+	}
+
+	ph, vals := db.BindValue(val)
+	return ph, vals, nil
+} // }}}
+
+// 求和, 分表时合计各分表的结果
+func (d *Dao) Sum(field string, params ...any) (float64, error) { // {{{
+	res, err := d.aggregate("SUM", field, params...)
+	return x.AsFloat64(res), err
+} // }}}
+
+// 平均值, 分表时按各分表的总和及数量计算
+func (d *Dao) Avg(field string, params ...any) (float64, error) { // {{{
+	res, err := d.aggregate("AVG", field, params...)
+	return x.AsFloat64(res), err
+} // }}}
+
+// 最大值, 没有记录时返回 nil
+func (d *Dao) Max(field string, params ...any) (any, error) { // {{{
+	return d.aggregate("MAX", field, params...)
+} // }}}
+
+// 最小值, 没有记录时返回 nil
+func (d *Dao) Min(field string, params ...any) (any, error) { // {{{
+	return d.aggregate("MIN", field, params...)
+} // }}}
+
+// 聚合查询, 每个分表返回一行后合并, 未分表时只有一行
+func (d *Dao) aggregate(fn, field string, params ...any) (any, error) { // {{{
+	d.SetFilter(params...)
+
+	fields := fmt.Sprintf("%s(%s) AS nyx_val", fn, field)
+	if fn == "AVG" {
+		fields = fmt.Sprintf("SUM(%s) AS nyx_val, COUNT(%s) AS nyx_cnt", field, field)
+	}
+
+	sqlOptions := []db.FnSqlOption{
+		db.WithTable(d.table),
+		db.WithFields(fields),
+		db.WithAlias(d.alias),
+		db.WithLeftJoin(d.parseJoin(d.leftJoin)),
+		db.WithInnerJoin(d.parseJoin(d.innerJoin)),
+		db.WithIdx(d.getIndex()),
+		db.WithWhere(d.getFilter()),
+		db.WithLock(d.getLock()),
+	}
+
+	return d.getCache(func() (int, any, error) {
+		rows, err := d.queryAll(sqlOptions)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return 0, nil, nil
+			}
+			return 0, nil, err
+		}
+
+		var res any
+		var sum float64
+		var cnt int64
+
+		for _, row := range rows {
+			val := row["nyx_val"]
+			if val == nil {
+				continue
+			}
+
+			switch fn {
+			case "SUM":
+				sum += x.AsFloat64(val)
+				res = sum
+			case "AVG":
+				sum += x.AsFloat64(val)
+				cnt += x.AsInt64(row["nyx_cnt"])
+			case "MAX":
+				if res == nil || compareValues(val, res) > 0 {
+					res = val
+				}
+			case "MIN":
+				if res == nil || compareValues(val, res) < 0 {
+					res = val
+				}
+			}
+		}
+
+		if fn == "AVG" && cnt > 0 {
+			res = sum / float64(cnt)
+		}
+
+		return 0, res, nil
+	}, sqlOptions)
+} // }}}
@@ -0,0 +1,879 @@
+package dao
+
+import (
+	"cmp"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/nyxless/nyx/x"
+	"github.com/nyxless/nyx/x/db"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 运行时分表
+// 用法:
+//
+//	func NewDAOOrder() *DAOOrder {
+//		ins := &DAOOrder{}
+//		ins.Init()
+//		ins.SetTable("order")
+//		ins.SetPrimary("id")
+//		ins.SetSharding("uid", dao.HashShard(16)) // 按 uid 分为 order_0 ~ order_15; 字符串分表键使用 dao.HashStringShard(16)
+//		// 分库: ins.SetSharding("uid", dao.HashShard(16), "db_order0", "db_order1:db_order1_slave")
+//		return ins
+//	}
+//
+//	NewDAOOrder().GetRecords(map[string]any{"uid": uid})           // 只查询一个分表
+//	NewDAOOrder().GetRecords(map[string]any{"uid:in": uids})       // 查询 uids 所在的分表
+//	NewDAOOrder().Order("ctime desc").Limit(10).GetRecords()       // 查询所有分表, 合并后排序分页
+//	NewDAOOrder().Shard(uid).SetRecordBy(record, "status=?", 1)    // 指定分表
+//
+// 分表键从 Where 中的 map 条件(包括 AND 组合)、Shard 方法或主键(分表键为主键时)中获取, 无法确定时查询所有分表
+// 查询所有分表时, 合并结果后按 Order 排序并按 Limit 分页; GetCount 及 WithCount 为各分表之和; Group 不做合并
+// Query/QueryRow/QueryOne/QueryStream/Execute 执行原始 sql, 不做分表路由
+// 事务中(InitTx 或 tx.Run)只允许操作一个分表, 且该分表所在的库须与事务一致, 否则返回 ErrCrossShardTx
+var ErrCrossShardTx = errors.New("cross-shard transaction is not supported")
+
+// 分表策略
+type ShardStrategy interface {
+	// 按分表键的值返回分表后缀, 分表名为: 表名_后缀
+	Shard(val any) (string, error)
+
+	// 所有分表后缀, 用于无法确定分表时查询所有分表
+	Shards() []string
+}
+
+type sharding struct {
+	key      string
+	strategy ShardStrategy
+	dbs      []string
+}
+
+type shardTarget struct {
+	shard  string
+	table  string
+	writer db.DBClient
+	reader db.DBClient
+	rows   []map[string]any // 批量写入时该分表的记录
+}
+
+// 设置分表键及分表策略, dbs 为分库时各库的配置名, 格式: 主库配置名[:从库配置名]
+// 分表按序号(哈希、范围分表)或后缀的 crc32(日期分表)对库的数量取模分配到各库
+func (d *Dao) SetSharding(key string, strategy ShardStrategy, dbs ...string) *Dao { // {{{
+	d.sharding = &sharding{
+		key:      key,
+		strategy: strategy,
+		dbs:      dbs,
+	}
+
+	return d
+} // }}}
+
+// 指定分表键的值, 只操作这些值所在的分表
+func (d *Dao) Shard(vals ...any) *Dao { // {{{
+	d.shardVals = append(d.shardVals, vals...)
+	return d
+} // }}}
+
+// 分表键为主键时, 按主键值确定分表
+func (d *Dao) shardByPrimary(id any) { // {{{
+	if d.sharding != nil && d.sharding.key == d.primary && len(d.shardVals) == 0 {
+		d.shardVals = []any{id}
+	}
+} // }}}
+
+// 本次操作涉及的分表后缀
+func (d *Dao) resolveShards() ([]string, error) { // {{{
+	vals := d.shardVals
+	if len(vals) == 0 {
+		vals = findShardVals(d.filter, d.sharding.key)
+	}
+
+	if len(vals) == 0 {
+		return d.sharding.strategy.Shards(), nil
+	}
+
+	var shards []string
+	for _, val := range vals {
+		shard, err := d.sharding.strategy.Shard(val)
+		if err != nil {
+			return nil, err
+		}
+
+		if !slices.Contains(shards, shard) {
+			shards = append(shards, shard)
+		}
+	}
+
+	return shards, nil
+} // }}}
+
+// 本次操作的目标分表, write 为 true 时为写操作
+func (d *Dao) shardTargets(write bool) ([]*shardTarget, error) { // {{{
+	shards, err := d.resolveShards()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("no shard found for table %s", d.table)
+	}
+
+	in_tx := d.intx || db.InTx(d.ctx)
+	if in_tx && len(shards) > 1 {
+		return nil, ErrCrossShardTx
+	}
+
+	targets := make([]*shardTarget, 0, len(shards))
+
+	// 未分库, 使用 Dao 的 DB
+	if len(d.sharding.dbs) == 0 {
+		var writer, reader db.DBClient
+		if write {
+			writer = d.GetDBWriter()
+		} else {
+			reader = d.GetDBReader()
+		}
+
+		for _, shard := range shards {
+			targets = append(targets, &shardTarget{shard: shard, table: d.table + "_" + shard, writer: writer, reader: reader})
+		}
+
+		return targets, nil
+	}
+
+	if d.intx {
+		return nil, ErrCrossShardTx
+	}
+
+	for _, shard := range shards {
+		writer, reader, err := d.shardDB(shard)
+		if err != nil {
+			return nil, err
+		}
+
+		// 使用 tx.Run 时, 分表所在的库须已开启事务
+		if in_tx {
+			tx := db.GetTx(d.ctx, writer)
+			if tx == nil {
+				return nil, ErrCrossShardTx
+			}
+
+			writer, reader = tx, tx
+		} else if write {
+			db.MarkWritten(d.ctx)
+		}
+
+		targets = append(targets, &shardTarget{shard: shard, table: d.table + "_" + shard, writer: writer, reader: reader})
+	}
+
+	d.forceMaster = false
+
+	return targets, nil
+} // }}}
+
+// 分表所在的库
+func (d *Dao) shardDB(shard string) (db.DBClient, db.DBClient, error) { // {{{
+	dbs := d.sharding.dbs
+
+	var idx int
+	if s, ok := d.sharding.strategy.(interface{ Index(string) int }); ok {
+		idx = s.Index(shard) % len(dbs)
+	} else {
+		idx = x.Crc32(shard) % len(dbs)
+	}
+
+	master_name, slave_name, _ := strings.Cut(dbs[idx], ":")
+	master_name = x.TenantDBName(d.ctx, master_name)
+	if slave_name != "" {
+		slave_name = x.TenantDBName(d.ctx, slave_name)
+	}
+
+	master_conf := x.Conf.GetMap(master_name)
+	if 0 == len(master_conf) {
+		return nil, nil, fmt.Errorf("db资源不存在: %s", master_name)
+	}
+
+	writer, err := x.DB.Named(master_name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if slave_name == "" || d.forceMaster || db.IsWritten(d.ctx) {
+		return writer, writer, nil
+	}
+
+	slave_confs := x.Conf.GetMapSlice(slave_name)
+	if len(slave_confs) == 0 {
+		return writer, writer, nil
+	}
+
+	pool, err := x.DB.GetReplicaPool(master_conf, slave_confs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return writer, pool.Pick(), nil
+} // }}}
+
+// 从过滤条件中查找分表键的值, 只处理 map 条件及 AND 组合
+func findShardVals(filters [][]any, key string) []any { // {{{
+	for _, params := range filters {
+		if len(params) == 0 {
+			continue
+		}
+
+		// sql 模板
+		if s, ok := params[0].(string); ok && strings.Contains(s, "?") {
+			continue
+		}
+
+		for _, p := range params {
+			var vals []any
+
+			switch v := p.(type) {
+			case map[string]any:
+				vals = shardValsFromMap(v, key)
+			case *Cond:
+				vals = shardValsFromCond(v, key)
+			}
+
+			if len(vals) > 0 {
+				return vals
+			}
+		}
+	}
+
+	return nil
+} // }}}
+
+func shardValsFromMap(m map[string]any, key string) []any { // {{{
+	for k, v := range m {
+		if dot := strings.Index(k, "."); dot > 0 {
+			k = k[dot+1:]
+		}
+
+		field, op, _ := strings.Cut(k, ":")
+		if field != key {
+			continue
+		}
+
+		switch op {
+		case "", "eq":
+			return []any{v}
+		case "in":
+			return x.AsSlice(v)
+		}
+	}
+
+	return nil
+} // }}}
+
+func shardValsFromCond(c *Cond, key string) []any { // {{{
+	if c.op != "AND" {
+		return nil
+	}
+
+	for _, child := range c.conds {
+		var vals []any
+
+		switch ch := child.(type) {
+		case map[string]any:
+			vals = shardValsFromMap(ch, key)
+		case *Cond:
+			vals = shardValsFromCond(ch, key)
+		}
+
+		if len(vals) > 0 {
+			return vals
+		}
+	}
+
+	return nil
+} // }}}
+
+// 替换 sqlOptions 中的表名, 不影响原切片
+func withShardTable(opts []db.FnSqlOption, table string) []db.FnSqlOption { // {{{
+	return append(slices.Clip(opts), db.WithTable(table))
+} // }}}
+
+// 查询多个分表时的最大并发数, 同时不超过各分表所在连接池 max_open_conns 的一半, 避免占满连接池
+var ShardConcurrency = 16
+
+// 并发在各分表执行, 并发数见 ShardConcurrency
+func fanOut(targets []*shardTarget, fn func(t *shardTarget) (any, error)) ([]any, error) { // {{{
+	results := make([]any, len(targets))
+	errs := make([]error, len(targets))
+
+	sem := make(chan struct{}, fanOutLimit(targets))
+
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i], errs[i] = fn(t)
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+} // }}}
+
+// 并发数: ShardConcurrency 与各连接池 max_open_conns 一半中的最小值, 至少为 1
+func fanOutLimit(targets []*shardTarget) int { // {{{
+	limit := max(ShardConcurrency, 1)
+	for _, t := range targets {
+		for _, client := range []db.DBClient{t.reader, t.writer} {
+			if client == nil {
+				continue
+			}
+
+			if open := client.Stats().MaxOpenConnections; open > 0 {
+				limit = min(limit, max(open/2, 1))
+			}
+		}
+	}
+
+	return limit
+} // }}}
+
+// 查询多行, 查询多个分表时合并后排序分页
+func (d *Dao) queryAll(opts []db.FnSqlOption) ([]map[string]any, error) { // {{{
+	if err := d.takeCondErr(); err != nil {
+		return nil, err
+	}
+
+	if d.sharding == nil {
+		return d.GetDBReader().GetAllContext(d.getContext(), opts...)
+	}
+
+	targets, err := d.shardTargets(false)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(targets) == 1 {
+		return targets[0].reader.GetAllContext(d.getContext(), withShardTable(opts, targets[0].table)...)
+	}
+
+	so := parseSqlOptions(opts)
+	offset, limit := parseLimits(so.GetLimits())
+
+	// 各分表取前 offset+limit 条, 合并后再分页
+	if limit >= 0 {
+		opts = append(slices.Clip(opts), db.WithLimits(strconv.Itoa(offset+limit)))
+	}
+
+	results, err := fanOut(targets, func(t *shardTarget) (any, error) {
+		return t.reader.GetAllContext(d.getContext(), withShardTable(opts, t.table)...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var list []map[string]any
+	for _, res := range results {
+		list = append(list, res.([]map[string]any)...)
+	}
+
+	sortRows(list, so.GetOrder())
+
+	if limit >= 0 {
+		if offset >= len(list) {
+			return []map[string]any{}, nil
+		}
+
+		list = list[offset:min(offset+limit, len(list))]
+	}
+
+	return list, nil
+} // }}}
+
+// 查询单行, 查询多个分表时按 Order 取第一行
+func (d *Dao) queryRow(opts []db.FnSqlOption) (map[string]any, error) { // {{{
+	if err := d.takeCondErr(); err != nil {
+		return nil, err
+	}
+
+	if d.sharding == nil {
+		return d.GetDBReader().GetRowContext(d.getContext(), opts...)
+	}
+
+	targets, err := d.shardTargets(false)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(targets) == 1 {
+		return targets[0].reader.GetRowContext(d.getContext(), withShardTable(opts, targets[0].table)...)
+	}
+
+	results, err := fanOut(targets, func(t *shardTarget) (any, error) {
+		row, err := t.reader.GetRowContext(d.getContext(), withShardTable(opts, t.table)...)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+
+		return row, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var list []map[string]any
+	for _, res := range results {
+		if row, ok := res.(map[string]any); ok && row != nil {
+			list = append(list, row)
+		}
+	}
+
+	if len(list) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	sortRows(list, parseSqlOptions(opts).GetOrder())
+
+	return list[0], nil
+} // }}}
+
+// 查询单个值, 查询多个分表时 sum 为 true 则求和(用于 count), 否则取第一个非空值
+func (d *Dao) queryOne(opts []db.FnSqlOption, sum bool) (any, error) { // {{{
+	if err := d.takeCondErr(); err != nil {
+		return nil, err
+	}
+
+	if d.sharding == nil {
+		return d.GetDBReader().GetOneContext(d.getContext(), opts...)
+	}
+
+	targets, err := d.shardTargets(false)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(targets) == 1 {
+		return targets[0].reader.GetOneContext(d.getContext(), withShardTable(opts, targets[0].table)...)
+	}
+
+	results, err := fanOut(targets, func(t *shardTarget) (any, error) {
+		val, err := t.reader.GetOneContext(d.getContext(), withShardTable(opts, t.table)...)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+
+		return val, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if sum {
+		var total int64
+		for _, res := range results {
+			total += x.AsInt64(res)
+		}
+
+		return total, nil
+	}
+
+	for _, res := range results {
+		if res != nil {
+			return res, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+} // }}}
+
+// 写操作, 依次在各分表执行, 返回影响行数之和; first 为 true 时有影响行数后即停止(用于只删除一条)
+func (d *Dao) write(fn func(client db.DBClient, table string) (int, error), first bool) (int, error) { // {{{
+	if err := d.takeCondErr(); err != nil {
+		return 0, err
+	}
+
+	if d.sharding == nil {
+		return fn(d.GetDBWriter(), d.table)
+	}
+
+	targets, err := d.shardTargets(true)
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, t := range targets {
+		n, err := fn(t.writer, t.table)
+		if err != nil {
+			return total, err
+		}
+
+		total += n
+		if first && n > 0 {
+			break
+		}
+	}
+
+	return total, nil
+} // }}}
+
+// 按分表键分组插入, 记录中须包含分表键
+func (d *Dao) addShardRecords(records []map[string]any) (int, error) { // {{{
+	targets, err := d.batchTargets(records)
+	if err != nil {
+		return 0, err
+	}
+
+	// 批量插入跨多个分表时, 返回最后一个分表的结果
+	var res int
+	for _, t := range targets {
+		res, err = t.writer.InsertContext(db.WithReturning(d.getContext(), d.primary), t.table, t.rows...)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return res, nil
+} // }}}
+
+// 分表的缓存 key 后缀
+func (d *Dao) shardCacheKey() string { // {{{
+	if d.sharding == nil {
+		return ""
+	}
+
+	shards, _ := d.resolveShards()
+	return "#" + strings.Join(shards, ",")
+} // }}}
+
+func parseSqlOptions(opts []db.FnSqlOption) *db.SqlOption { // {{{
+	so := &db.SqlOption{}
+	for _, opt := range opts {
+		opt(so)
+	}
+
+	return so
+} // }}}
+
+// "20,10" => 20, 10; "10" => 0, 10; "" => 0, -1
+func parseLimits(limits string) (int, int) { // {{{
+	if limits == "" {
+		return 0, -1
+	}
+
+	if offset, limit, ok := strings.Cut(limits, ","); ok {
+		return x.AsInt(strings.TrimSpace(offset)), x.AsInt(strings.TrimSpace(limit))
+	}
+
+	return 0, x.AsInt(strings.TrimSpace(limits))
+} // }}}
+
+// 按 order 子句对合并后的结果排序, 如: "ctime desc, id"
+func sortRows(list []map[string]any, order string) { // {{{
+	if order == "" || len(list) < 2 {
+		return
+	}
+
+	type orderField struct {
+		name string
+		desc bool
+	}
+
+	var fields []orderField
+	for _, part := range strings.Split(order, ",") {
+		words := strings.Fields(part)
+		if len(words) == 0 {
+			continue
+		}
+
+		name := words[0]
+		if dot := strings.LastIndex(name, "."); dot >= 0 {
+			name = name[dot+1:]
+		}
+
+		fields = append(fields, orderField{
+			name: strings.Trim(name, "`\""),
+			desc: len(words) > 1 && strings.EqualFold(words[1], "desc"),
+		})
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		for _, f := range fields {
+			c := compareValues(list[i][f.name], list[j][f.name])
+			if c == 0 {
+				continue
+			}
+
+			if f.desc {
+				return c > 0
+			}
+			return c < 0
+		}
+
+		return false
+	})
+} // }}}
+
+// 比较两个字段值: nil 最小, 数值按大小, 时间按先后, 其余按字符串
+func compareValues(a, b any) int { // {{{
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+
+	if ta, ok := a.(time.Time); ok {
+		if tb, ok := b.(time.Time); ok {
+			return ta.Compare(tb)
+		}
+	}
+
+	if na, ok := asNumber(a); ok {
+		if nb, ok := asNumber(b); ok {
+			return na.compare(nb)
+		}
+	}
+
+	return strings.Compare(x.AsString(a), x.AsString(b))
+} // }}}
+
+// 数值字段的值, 整数保留原值比较, 避免大于 2^53 的整数(如雪花 id)转为 float64 后丢失精度
+type number struct {
+	kind byte // 'i': int64, 'u': uint64, 'f': float64
+	i    int64
+	u    uint64
+	f    float64
+}
+
+func (n number) float() float64 { // {{{
+	switch n.kind {
+	case 'i':
+		return float64(n.i)
+	case 'u':
+		return float64(n.u)
+	}
+
+	return n.f
+} // }}}
+
+func (n number) compare(o number) int { // {{{
+	switch {
+	case n.kind == 'i' && o.kind == 'i':
+		return cmp.Compare(n.i, o.i)
+	case n.kind == 'u' && o.kind == 'u':
+		return cmp.Compare(n.u, o.u)
+	case n.kind == 'i' && o.kind == 'u':
+		if n.i < 0 {
+			return -1
+		}
+		return cmp.Compare(uint64(n.i), o.u)
+	case n.kind == 'u' && o.kind == 'i':
+		return -o.compare(n)
+	}
+
+	return cmp.Compare(n.float(), o.float())
+} // }}}
+
+func asNumber(v any) (number, bool) { // {{{
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return number{kind: 'i', i: rv.Int()}, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return number{kind: 'u', u: rv.Uint()}, true
+	case reflect.Float32, reflect.Float64:
+		return number{kind: 'f', f: rv.Float()}, true
+	case reflect.String:
+		return parseNumber(rv.String())
+	case reflect.Slice:
+		if b, ok := v.([]byte); ok {
+			return parseNumber(string(b))
+		}
+	}
+
+	return number{}, false
+} // }}}
+
+func parseNumber(s string) (number, bool) { // {{{
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return number{kind: 'i', i: i}, true
+	}
+
+	if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return number{kind: 'u', u: u}, true
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	return number{kind: 'f', f: f}, err == nil
+} // }}}
+
+// 哈希分表, 分表键为整数: 取模; 字符串形式的整数(如请求参数中的 "123")与整数 123 分到同一分表, 非整数返回错误
+// 与 nyx gen orm -h 生成的分表规则一致(按字段类型区分), 字符串类型的分表键使用 HashStringShard
+func HashShard(num int) ShardStrategy { // {{{
+	return &hashShard{num: max(num, 1)}
+} // }}}
+
+// 哈希分表, 分表键为字符串: crc32 后取模, 其他类型的值先转换为字符串
+func HashStringShard(num int) ShardStrategy { // {{{
+	return &hashShard{num: max(num, 1), str: true}
+} // }}}
+
+type hashShard struct {
+	num int
+	str bool
+}
+
+func (h *hashShard) Shard(val any) (string, error) { // {{{
+	if val == nil {
+		return "", fmt.Errorf("shard key is nil")
+	}
+
+	if h.str {
+		return strconv.Itoa(x.Crc32(x.AsString(val)) % h.num), nil
+	}
+
+	var n int64
+	switch v := val.(type) {
+	case string, []byte:
+		var err error
+		n, err = strconv.ParseInt(strings.TrimSpace(x.AsString(v)), 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("shard key %q is not an integer, use HashStringShard for string keys", x.AsString(v))
+		}
+	default:
+		n = x.AsInt64(v)
+	}
+
+	idx := int(n % int64(h.num))
+	if idx < 0 {
+		idx += h.num
+	}
+
+	return strconv.Itoa(idx), nil
+} // }}}
+
+func (h *hashShard) Shards() []string { // {{{
+	shards := make([]string, h.num)
+	for i := range shards {
+		shards[i] = strconv.Itoa(i)
+	}
+
+	return shards
+} // }}}
+
+func (h *hashShard) Index(shard string) int { // {{{
+	idx, _ := strconv.Atoi(shard)
+	return idx
+} // }}}
+
+// 范围分表: bounds 为各分表的上界(不含), 须递增
+// 如 RangeShard(1000000, 2000000): 小于 1000000 为 0, 小于 2000000 为 1, 其余为 2
+func RangeShard(bounds ...int64) ShardStrategy { // {{{
+	return &rangeShard{bounds}
+} // }}}
+
+type rangeShard struct {
+	bounds []int64
+}
+
+func (r *rangeShard) Shard(val any) (string, error) { // {{{
+	if val == nil {
+		return "", fmt.Errorf("shard key is nil")
+	}
+
+	n := x.AsInt64(val)
+	idx := sort.Search(len(r.bounds), func(i int) bool {
+		return n < r.bounds[i]
+	})
+
+	return strconv.Itoa(idx), nil
+} // }}}
+
+func (r *rangeShard) Shards() []string { // {{{
+	shards := make([]string, len(r.bounds)+1)
+	for i := range shards {
+		shards[i] = strconv.Itoa(i)
+	}
+
+	return shards
+} // }}}
+
+func (r *rangeShard) Index(shard string) int { // {{{
+	idx, _ := strconv.Atoi(shard)
+	return idx
+} // }}}
+
+// 按日期分表, unit: day | month | year, 后缀分别为 20060102 | 200601 | 2006
+// 分表键的值为时间、时间戳或日期字符串; 查询所有分表时, 范围为 start 至当前时间
+func DateShard(unit string, start time.Time) ShardStrategy { // {{{
+	layout := "200601"
+	switch unit {
+	case "day":
+		layout = "20060102"
+	case "year":
+		layout = "2006"
+	}
+
+	return &dateShard{unit: unit, layout: layout, start: start}
+} // }}}
+
+type dateShard struct {
+	unit   string
+	layout string
+	start  time.Time
+}
+
+func (s *dateShard) Shard(val any) (string, error) { // {{{
+	t := x.AsTime(val)
+	if t.IsZero() {
+		return "", fmt.Errorf("invalid shard date: %v", val)
+	}
+
+	return t.Format(s.layout), nil
+} // }}}
+
+func (s *dateShard) Shards() []string { // {{{
+	var shards []string
+
+	end := time.Now().Format(s.layout)
+	for t := s.start; ; {
+		shard := t.Format(s.layout)
+		shards = append(shards, shard)
+
+		if shard >= end {
+			break
+		}
+
+		switch s.unit {
+		case "day":
+			t = t.AddDate(0, 0, 1)
+		case "year":
+			t = t.AddDate(1, 0, 0)
+		default:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		}
+	}
+
+	return shards
+} // }}}
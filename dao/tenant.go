@@ -0,0 +1,155 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"github.com/nyxless/nyx/x"
+	"github.com/nyxless/nyx/x/db"
+)
+
+// 多租户隔离, 租户由中间件解析后存入 ctx(见 x.WithTenant), Dao 通过 WithContext 取得
+// 用法:
+//
+//	func NewDAOOrder() *DAOOrder {
+//		ins := &DAOOrder{}
+//		ins.Init()
+//		ins.SetTable("order")
+//		ins.SetPrimary("id")
+//		ins.SetTenantScope("tenant_id")         // 按 tenant_id 隔离; 只按 DB/schema 隔离的表使用 SetTenantScope("")
+//		return ins
+//	}
+//
+//	NewDAOOrder().WithContext(c.Ctx).GetRecords(...)        // WHERE ... AND tenant_id = 当前租户
+//	NewDAOOrder().WithContext(c.Ctx).AddRecord(order)       // 自动填充 tenant_id
+//	NewDAOOrder().WithContext(ctx).WithoutTenant().GetCount() // 跨租户统计
+//
+// 租户字段: 查询、更新、删除时追加 租户字段=当前租户 条件(包括 Join 的表), 写入时填充; ctx 中没有租户时查询条件恒为假, 写入返回 ErrNoTenant
+// DB: 租户配置了 db 映射时(见 x/tenant.go), 所有 Dao 使用映射后的 DB 配置, 与是否调用 SetTenantScope 无关
+// schema: 租户配置了 schema 时, SetTenantScope 的表名加 schema 前缀
+// 不作用于 Execute/Query 等直接执行 sql 的方法; 子查询的 Dao 须同样调用 WithContext
+// ResetRecord/BatchUpsert 冲突时不更新租户字段, 且只更新当前租户的记录: 与其他租户的记录主键冲突时不作修改
+
+// 设置了租户字段但 ctx 中没有租户
+var ErrNoTenant = errors.New("tenant is required")
+
+// 记录中租户字段的值与当前租户不一致
+var ErrTenantMismatch = errors.New("tenant mismatch")
+
+// 按租户隔离, field 为租户字段, 为空时只按 DB/schema 隔离
+func (d *Dao) SetTenantScope(field string) *Dao { // {{{
+	d.tenantScoped = true
+	d.tenantField = field
+	d.applySchema()
+
+	return d
+} // }}}
+
+// 不按租户字段过滤及填充, 作用于之后该 Dao 的所有读写; 不影响 DB 及 schema 的选择
+func (d *Dao) WithoutTenant(flag ...bool) *Dao { // {{{
+	d.withoutTenant = len(flag) == 0 || flag[0]
+	return d
+} // }}}
+
+// 按 ctx 中的租户切换 DB 及 schema, 事务中(InitTx)不切换
+func (d *Dao) applyTenant() { // {{{
+	tenant := x.GetTenant(d.ctx)
+	if tenant == d.tenant || d.intx {
+		return
+	}
+
+	d.tenant = tenant
+
+	if d.masterConf != "" {
+		master := x.TenantDBName(d.ctx, d.masterConf)
+		slave := x.TenantDBName(d.ctx, d.slaveConf)
+
+		if master+","+slave != d.dbConf {
+			if err := d.useDB(master, slave); err != nil {
+				x.Panic(err)
+			}
+		}
+	}
+
+	d.applySchema()
+} // }}}
+
+func (d *Dao) applySchema() { // {{{
+	if !d.tenantScoped {
+		return
+	}
+
+	if d.baseTable == "" {
+		d.baseTable = d.table
+	}
+
+	d.table = d.baseTable
+	if schema := x.TenantSchema(d.ctx); schema != "" {
+		d.table = schema + "." + d.baseTable
+	}
+} // }}}
+
+// 租户过滤条件, 字段使用指定的表别名; ctx 中没有租户时返回恒假条件
+func (d *Dao) getTenantFilter(alias string) (string, []any) { // {{{
+	if d.tenantField == "" || d.withoutTenant {
+		return "", nil
+	}
+
+	tenant := x.GetTenant(d.ctx)
+	if tenant == "" {
+		return "1=0", nil
+	}
+
+	field := d.dialect().Quote(d.tenantField)
+	if alias != "" {
+		field = alias + "." + field
+	}
+
+	return field + "=?", []any{tenant}
+} // }}}
+
+// 在写操作的条件后追加租户过滤条件
+func (d *Dao) tenantWhere(where string, params []any) (string, []any) { // {{{
+	tw, tv := d.getTenantFilter("")
+	if tw == "" {
+		return where, params
+	}
+
+	return "(" + where + ") AND " + tw, append(append([]any{}, params...), tv...)
+} // }}}
+
+// upsert 使用的 ctx, 按租户字段隔离时冲突更新只作用于同一租户的记录
+func (d *Dao) upsertContext() context.Context { // {{{
+	if d.tenantField == "" || d.withoutTenant {
+		return d.getContext()
+	}
+
+	return db.WithUpsertGuard(d.getContext(), d.tenantField)
+} // }}}
+
+// 检查记录中的租户字段, fill 为 true 时(插入)填充当前租户
+func (d *Dao) fillTenant(record map[string]any, fill bool) error { // {{{
+	if d.tenantField == "" || d.withoutTenant {
+		return nil
+	}
+
+	tenant := x.GetTenant(d.ctx)
+	if val, ok := record[d.tenantField]; ok {
+		if x.AsString(val) != tenant {
+			return ErrTenantMismatch
+		}
+
+		return nil
+	}
+
+	if !fill {
+		return nil
+	}
+
+	if tenant == "" {
+		return ErrNoTenant
+	}
+
+	record[d.tenantField] = tenant
+
+	return nil
+} // }}}
@@ -0,0 +1,216 @@
+package tx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/nyxless/nyx/x"
+	"github.com/nyxless/nyx/x/db"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// tx.Run 闭包中使用的事务
+type Tx struct {
+	db.DBClient
+	ctx   context.Context
+	depth int // 嵌套层数, 最外层为 0
+}
+
+// 绑定了当前事务的 ctx, 传给 Dao.WithContext 或嵌套的 Run
+func (t *Tx) Context() context.Context {
+	return t.ctx
+}
+
+// 嵌套层数, 最外层为 0
+func (t *Tx) Depth() int {
+	return t.depth
+}
+
+type runOption struct {
+	confName   string
+	readonly   bool
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+type FuncOption func(*runOption)
+
+// DB 配置名, 默认 db_master
+func WithConf(conf_name string) FuncOption {
+	return func(o *runOption) {
+		o.confName = conf_name
+	}
+}
+
+// 只读事务
+func WithReadOnly() FuncOption {
+	return func(o *runOption) {
+		o.readonly = true
+	}
+}
+
+// 死锁或序列化冲突时的重试次数, 默认 3, 为 0 时不重试
+func WithRetry(retries int) FuncOption {
+	return func(o *runOption) {
+		o.retries = retries
+	}
+}
+
+// 重试的初始退避时间, 之后每次翻倍(加随机抖动), 默认 20ms, 最长 1s
+func WithBackoff(backoff time.Duration) FuncOption {
+	return func(o *runOption) {
+		o.backoff = backoff
+	}
+}
+
+var savepointSeq atomic.Int64
+
+// 在事务中执行闭包:
+//
+//	err := tx.Run(ctx, func(t *tx.Tx) error {
+//		if _, err := NewDAOUser().WithContext(t.Context()).AddRecord(user); err != nil {
+//			return err
+//		}
+//
+//		return NewDAOLog().WithContext(t.Context()).AddRecord(log)
+//	})
+//
+// fn 返回 nil 时提交, 返回错误或 panic 时回滚(panic 回滚后继续抛出)
+// 使用 t.Context() 的 Dao 自动加入事务, 无需 InitTx
+// 在事务中再次调用 Run(使用 t.Context())时, 使用 SAVEPOINT 实现嵌套事务, 内层出错只回滚到该 SAVEPOINT
+// 最外层事务遇到死锁或序列化冲突时, 按退避时间重试整个闭包, 因此 fn 中不应包含事务之外不可重复执行的操作
+func Run(ctx context.Context, fn func(t *Tx) error, opts ...FuncOption) error { // {{{
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	o := &runOption{
+		confName:   "db_master",
+		retries:    3,
+		backoff:    20 * time.Millisecond,
+		maxBackoff: time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	// 按 ctx 中的租户映射 DB 配置
+	o.confName = x.TenantDBName(ctx, o.confName)
+
+	conf := x.Conf.GetMap(o.confName)
+	if 0 == len(conf) {
+		return fmt.Errorf("db资源不存在: %s", o.confName)
+	}
+
+	parent, err := x.DB.Named(o.confName)
+	if err != nil {
+		return err
+	}
+
+	// 已在该 DB 的事务中, 使用 SAVEPOINT
+	if tx := db.GetTx(ctx, parent); tx != nil {
+		depth, _ := ctx.Value("db_tx_depth").(int)
+		return runSavepoint(ctx, tx, depth+1, fn)
+	}
+
+	backoff := o.backoff
+	for attempt := 0; ; attempt++ {
+		err = runTx(ctx, parent, o.readonly, fn)
+		if err == nil || attempt >= o.retries || !IsRetryable(err) {
+			return err
+		}
+
+		x.Noticef("[tx.Run] retry %d after %v: %v", attempt+1, backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff + time.Duration(rand.Int63n(int64(backoff)+1))):
+		}
+
+		backoff = min(backoff*2, o.maxBackoff)
+	}
+} // }}}
+
+func runTx(ctx context.Context, parent db.DBClient, readonly bool, fn func(t *Tx) error) (err error) { // {{{
+	client, err := parent.BeginContext(ctx, readonly)
+	if err != nil {
+		return err
+	}
+
+	t := &Tx{
+		DBClient: client,
+		ctx:      context.WithValue(db.WithTx(ctx, parent, client), "db_tx_depth", 0),
+	}
+
+	defer func() {
+		if e := recover(); e != nil {
+			client.Rollback()
+			panic(e)
+		}
+	}()
+
+	if err = fn(t); err != nil {
+		client.Rollback()
+		return err
+	}
+
+	return client.Commit()
+} // }}}
+
+func runSavepoint(ctx context.Context, client db.DBClient, depth int, fn func(t *Tx) error) (err error) { // {{{
+	savepoint := fmt.Sprintf("nyx_sp_%d", savepointSeq.Add(1))
+
+	if _, err = client.ExecuteContext(ctx, "SAVEPOINT "+savepoint); err != nil {
+		return err
+	}
+
+	t := &Tx{
+		DBClient: client,
+		ctx:      context.WithValue(ctx, "db_tx_depth", depth),
+		depth:    depth,
+	}
+
+	// 回滚到 SAVEPOINT 时, 丢弃其后注册的提交后函数(如 Dao 的变更事件)
+	pending := db.PendingAfterCommit(client)
+
+	defer func() {
+		if e := recover(); e != nil {
+			client.ExecuteContext(ctx, "ROLLBACK TO SAVEPOINT "+savepoint)
+			db.DiscardAfterCommit(client, pending)
+			panic(e)
+		}
+	}()
+
+	if err = fn(t); err != nil {
+		client.ExecuteContext(ctx, "ROLLBACK TO SAVEPOINT "+savepoint)
+		db.DiscardAfterCommit(client, pending)
+		return err
+	}
+
+	_, err = client.ExecuteContext(ctx, "RELEASE SAVEPOINT "+savepoint)
+
+	return err
+} // }}}
+
+// 是否为可重试的错误: mysql 死锁(1213), postgres 序列化冲突(40001)及死锁(40P01)
+func IsRetryable(err error) bool { // {{{
+	var me *mysql.MySQLError
+	if errors.As(err, &me) {
+		return me.Number == 1213
+	}
+
+	// lib/pq、pgx 等 postgres 驱动的错误
+	var se interface{ SQLState() string }
+	if errors.As(err, &se) {
+		state := se.SQLState()
+		return state == "40001" || state == "40P01"
+	}
+
+	return false
+} // }}}
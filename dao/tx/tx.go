@@ -1,36 +1,49 @@
 package tx
 
 import (
+	"context"
 	"github.com/nyxless/nyx/x"
 	"github.com/nyxless/nyx/x/db"
 )
 
 // 开启事务，参数: DB配置名
 func TransBegin(conf_names ...string) (db.DBClient, error) {
-	return transBegin(false, conf_names...)
+	return transBegin(context.Background(), false, conf_names...)
 }
 
 // 开启只读事务
 func ReadTransBegin(conf_names ...string) (db.DBClient, error) {
-	return transBegin(true, conf_names...)
+	return transBegin(context.Background(), true, conf_names...)
 }
 
-func transBegin(is_readonly bool, conf_names ...string) (db.DBClient, error) { // {{{
+// 使用 ctx 开启事务, ctx 取消时事务自动回滚
+func TransBeginContext(ctx context.Context, conf_names ...string) (db.DBClient, error) {
+	return transBegin(ctx, false, conf_names...)
+}
+
+func ReadTransBeginContext(ctx context.Context, conf_names ...string) (db.DBClient, error) {
+	return transBegin(ctx, true, conf_names...)
+}
+
+func transBegin(ctx context.Context, is_readonly bool, conf_names ...string) (db.DBClient, error) { // {{{
 	conf_name := "db_master"
 
 	if len(conf_names) > 0 {
 		conf_name = conf_names[0]
 	}
 
+	// 按 ctx 中的租户映射 DB 配置
+	conf_name = x.TenantDBName(ctx, conf_name)
+
 	conf := x.Conf.GetMap(conf_name)
 	if 0 == len(conf) {
 		panic("db资源不存在: " + conf_name)
 	}
 
-	tx, err := x.DB.Get(conf)
+	tx, err := x.DB.Named(conf_name)
 	if err != nil {
 		return nil, err
 	}
 
-	return tx.Begin(is_readonly)
+	return tx.BeginContext(ctx, is_readonly)
 } // }}}
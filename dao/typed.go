@@ -0,0 +1,403 @@
+package dao
+
+import (
+	"context"
+	"github.com/nyxless/nyx/x/db"
+	"slices"
+	"time"
+)
+
+// 泛型 Dao, 查询结果按 db tag 映射为结构体 T, 写入方法接受结构体
+// 用法:
+//
+//	type User struct {
+//		Uid   int64  `db:"uid"`
+//		Name  string `db:"name"`
+//	}
+//
+//	users := dao.NewTyped[User](NewDAOUser().Dao)
+//	user, err := users.GetRecord(uid)
+//	list, err := users.Where(map[string]any{"status": 1}).Limit(10).GetRecords()
+//
+// 缓存中仍以 map 形式存储(与 Dao 共用), 读取后再转换为结构体
+type Typed[T any] struct {
+	*Dao
+}
+
+// 缓存更新成功后的回调函数, 单条记录的查询方法回调时为只有一个元素的切片
+type TypedCacheCallbackFn[T any] func([]*T) error
+
+func NewTyped[T any](d *Dao) *Typed[T] { // {{{
+	return &Typed[T]{d}
+} // }}}
+
+// 以下为返回 *Typed[T] 的链式方法
+
+func (t *Typed[T]) WithContext(ctx context.Context) *Typed[T] { // {{{
+	t.Dao.WithContext(ctx)
+	return t
+} // }}}
+
+func (t *Typed[T]) Timeout(timeout time.Duration) *Typed[T] { // {{{
+	t.Dao.Timeout(timeout)
+	return t
+} // }}}
+
+func (t *Typed[T]) Where(params ...any) *Typed[T] { // {{{
+	t.Dao.Where(params...)
+	return t
+} // }}}
+
+func (t *Typed[T]) SetFields(fields ...string) *Typed[T] { // {{{
+	t.Dao.SetFields(fields...)
+	return t
+} // }}}
+
+func (t *Typed[T]) UseIndex(idx string) *Typed[T] { // {{{
+	t.Dao.UseIndex(idx)
+	return t
+} // }}}
+
+func (t *Typed[T]) UseMaster(flag ...bool) *Typed[T] { // {{{
+	t.Dao.UseMaster(flag...)
+	return t
+} // }}}
+
+func (t *Typed[T]) ForUpdate(flag ...bool) *Typed[T] { // {{{
+	t.Dao.ForUpdate(flag...)
+	return t
+} // }}}
+
+func (t *Typed[T]) Order(order ...string) *Typed[T] { // {{{
+	t.Dao.Order(order...)
+	return t
+} // }}}
+
+func (t *Typed[T]) Group(group ...string) *Typed[T] { // {{{
+	t.Dao.Group(group...)
+	return t
+} // }}}
+
+func (t *Typed[T]) Having(params ...any) *Typed[T] { // {{{
+	t.Dao.Having(params...)
+	return t
+} // }}}
+
+func (t *Typed[T]) Union(others ...*Dao) *Typed[T] { // {{{
+	t.Dao.Union(others...)
+	return t
+} // }}}
+
+func (t *Typed[T]) UnionAll(others ...*Dao) *Typed[T] { // {{{
+	t.Dao.UnionAll(others...)
+	return t
+} // }}}
+
+func (t *Typed[T]) WithTrashed(flag ...bool) *Typed[T] { // {{{
+	t.Dao.WithTrashed(flag...)
+	return t
+} // }}}
+
+func (t *Typed[T]) OnlyTrashed(flag ...bool) *Typed[T] { // {{{
+	t.Dao.OnlyTrashed(flag...)
+	return t
+} // }}}
+
+func (t *Typed[T]) ForceDelete(flag ...bool) *Typed[T] { // {{{
+	t.Dao.ForceDelete(flag...)
+	return t
+} // }}}
+
+func (t *Typed[T]) Limit(limit int, limits ...int) *Typed[T] { // {{{
+	t.Dao.Limit(limit, limits...)
+	return t
+} // }}}
+
+func (t *Typed[T]) Shard(vals ...any) *Typed[T] { // {{{
+	t.Dao.Shard(vals...)
+	return t
+} // }}}
+
+func (t *Typed[T]) WithCount(cnt *int) *Typed[T] { // {{{
+	t.Dao.WithCount(cnt)
+	return t
+} // }}}
+
+func (t *Typed[T]) WithCache(ttl int, callbackFns ...TypedCacheCallbackFn[T]) *Typed[T] { // {{{
+	t.Dao.WithCache(ttl, typedCacheCallbacks(callbackFns)...)
+	return t
+} // }}}
+
+func (t *Typed[T]) WithRefreshCache(refreshInterval int, callbackFns ...TypedCacheCallbackFn[T]) *Typed[T] { // {{{
+	t.Dao.WithRefreshCache(refreshInterval, typedCacheCallbacks(callbackFns)...)
+	return t
+} // }}}
+
+// 将泛型回调转换为 Dao 使用的回调
+func typedCacheCallbacks[T any](fns []TypedCacheCallbackFn[T]) []CacheCallbackFn { // {{{
+	callbacks := make([]CacheCallbackFn, 0, len(fns))
+	for _, fn := range fns {
+		if fn == nil {
+			continue
+		}
+
+		callbacks = append(callbacks, func(res any) error {
+			var list []*T
+			var err error
+
+			switch v := res.(type) {
+			case map[string]any:
+				var obj *T
+				if obj, err = ScanRow[T](v); obj != nil {
+					list = []*T{obj}
+				}
+			case []map[string]any:
+				list, err = ScanRows[T](v)
+			}
+
+			if err != nil {
+				return err
+			}
+
+			return fn(list)
+		})
+	}
+
+	return callbacks
+} // }}}
+
+// 按主键查询记录
+func (t *Typed[T]) GetRecord(id any) (*T, error) { // {{{
+	row, err := t.Dao.GetRecord(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return ScanRow[T](row)
+} // }}}
+
+// 按条件查询一条记录
+func (t *Typed[T]) GetRecordBy(params ...any) (*T, error) { // {{{
+	row, err := t.Dao.GetRecordBy(params...)
+	if err != nil {
+		return nil, err
+	}
+
+	return ScanRow[T](row)
+} // }}}
+
+// 按条件查询记录列表
+func (t *Typed[T]) GetRecords(params ...any) ([]*T, error) { // {{{
+	rows, err := t.Dao.GetRecords(params...)
+	if err != nil {
+		return nil, err
+	}
+
+	return ScanRows[T](rows)
+} // }}}
+
+// 按页码分页, 见 Dao.Paginate
+func (t *Typed[T]) Paginate(page, size int, params ...any) (*Page[*T], error) { // {{{
+	res, err := t.Dao.Paginate(page, size, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	return typedPage[T](res)
+} // }}}
+
+// 游标分页, 见 Dao.Cursor
+func (t *Typed[T]) Cursor(cursor string, size int, params ...any) (*Page[*T], error) { // {{{
+	res, err := t.Dao.Cursor(cursor, size, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	return typedPage[T](res)
+} // }}}
+
+func typedPage[T any](res *Page[map[string]any]) (*Page[*T], error) { // {{{
+	list, err := ScanRows[T](res.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Page[*T]{
+		Items:   list,
+		Total:   res.Total,
+		Page:    res.Page,
+		Size:    res.Size,
+		Next:    res.Next,
+		HasMore: res.HasMore,
+	}, nil
+} // }}}
+
+// 在从库执行 sql 查询单行
+func (t *Typed[T]) QueryRow(sql string, params ...any) (*T, error) { // {{{
+	row, err := t.Dao.QueryRow(sql, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	return ScanRow[T](row)
+} // }}}
+
+// 在从库执行 sql 查询
+func (t *Typed[T]) Query(sql string, params ...any) ([]*T, error) { // {{{
+	rows, err := t.Dao.Query(sql, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	return ScanRows[T](rows)
+} // }}}
+
+// 返回迭代器, 不支持 cache
+func (t *Typed[T]) QueryStream(sql string, params ...any) (*TypedIter[T], error) { // {{{
+	iter, err := t.Dao.QueryStream(sql, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TypedIter[T]{iter}, nil
+} // }}}
+
+// 插入新记录, 支持批量; 批量插入时使用所有记录字段的并集, 见 toRecords
+func (t *Typed[T]) AddRecord(records ...*T) (int, error) { // {{{
+	list, err := toRecords(records)
+	if err != nil {
+		return 0, err
+	}
+
+	return t.Dao.AddRecord(list...)
+} // }}}
+
+// 按主键更新记录, 指定 columns 时只更新这些字段(包括零值)
+func (t *Typed[T]) SetRecord(record *T, id any, columns ...string) (int, error) { // {{{
+	m, err := toRecord(record, columns...)
+	if err != nil {
+		return 0, err
+	}
+
+	return t.Dao.SetRecord(m, id)
+} // }}}
+
+// 按条件更新记录
+func (t *Typed[T]) SetRecordBy(record *T, where string, params ...any) (int, error) { // {{{
+	m, err := toRecord(record)
+	if err != nil {
+		return 0, err
+	}
+
+	return t.Dao.SetRecordBy(m, where, params...)
+} // }}}
+
+// upsert 操作
+func (t *Typed[T]) ResetRecord(record *T) (int, error) { // {{{
+	m, err := toRecord(record)
+	if err != nil {
+		return 0, err
+	}
+
+	return t.Dao.ResetRecord(m)
+} // }}}
+
+// 批量插入, 见 Dao.BatchInsert
+func (t *Typed[T]) BatchInsert(records []*T, chunk_size int, opts ...BatchOption) (*BatchResult, error) { // {{{
+	rows, err := toRecords(records)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.Dao.BatchInsert(rows, chunk_size, opts...)
+} // }}}
+
+// 批量 upsert, 见 Dao.BatchUpsert
+func (t *Typed[T]) BatchUpsert(records []*T, update_fields []string, opts ...BatchOption) (*BatchResult, error) { // {{{
+	rows, err := toRecords(records)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.Dao.BatchUpsert(rows, update_fields, opts...)
+} // }}}
+
+// omitempty 字段在部分记录中为零值时各记录的字段不同, 批量写入须使用相同的字段:
+// 取所有记录字段的并集, 字段不全的记录按并集重新转换(零值也写入), 所有记录都忽略的字段仍不写入
+func toRecords[T any](records []*T) ([]map[string]any, error) { // {{{
+	rows := make([]map[string]any, 0, len(records))
+	var columns []string
+	for _, record := range records {
+		m, err := toRecord(record)
+		if err != nil {
+			return nil, err
+		}
+
+		for col := range m {
+			if !slices.Contains(columns, col) {
+				columns = append(columns, col)
+			}
+		}
+
+		rows = append(rows, m)
+	}
+
+	for i, row := range rows {
+		if len(row) == len(columns) {
+			continue
+		}
+
+		m, err := toRecord(records[i], columns...)
+		if err != nil {
+			return nil, err
+		}
+
+		// 途经 nil 指针的字段无法读取, 写入 NULL
+		for _, col := range columns {
+			if _, ok := m[col]; !ok {
+				m[col] = nil
+			}
+		}
+
+		rows[i] = m
+	}
+
+	return rows, nil
+} // }}}
+
+// 泛型迭代器
+type TypedIter[T any] struct {
+	iter *db.RowIter
+}
+
+// 遍历每行数据，直到处理完所有行或回调返回错误
+func (it *TypedIter[T]) Foreach(fn func(*T) error, limits ...int) error { // {{{
+	return it.iter.Foreach(func(row map[string]any) error {
+		obj, err := ScanRow[T](row)
+		if err != nil {
+			return err
+		}
+
+		return fn(obj)
+	}, limits...)
+} // }}}
+
+// 收集所有行数据到切片中
+func (it *TypedIter[T]) Collect(limits ...int) ([]*T, error) { // {{{
+	var list []*T
+	err := it.Foreach(func(obj *T) error {
+		list = append(list, obj)
+		return nil
+	}, limits...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+} // }}}
+
+// 释放数据库资源
+func (it *TypedIter[T]) Close() error { // {{{
+	return it.iter.Close()
+} // }}}
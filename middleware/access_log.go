@@ -0,0 +1,272 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/nyxless/nyx/x"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HttpLog 日志格式
+const (
+	LogFormatDefault  = ""         // 原有格式: guid/uri/ip/ua/post 及 log_params
+	LogFormatCombined = "combined" // Apache combined 格式
+	LogFormatJson     = "json"     // 字段名固定的 json 格式
+	LogFormatTemplate = "template" // 自定义模板, 如 "{ip} {method} {uri} {status} {latency_ms}"
+)
+
+// 访问日志字段, json 格式按此结构输出, 字段名保持稳定
+type AccessLog struct {
+	Guid         any               `json:"guid"`
+	Tenant       string            `json:"tenant,omitempty"`
+	Method       string            `json:"method"`
+	Uri          string            `json:"uri"`
+	Proto        string            `json:"proto"`
+	Status       int               `json:"status"`
+	Errno        int32             `json:"errno"`
+	Ip           string            `json:"ip"`
+	Ua           string            `json:"ua"`
+	Referer      string            `json:"referer"`
+	Bytes        int               `json:"bytes"`
+	LatencyMs    float64           `json:"latency_ms"`
+	MiddlewareMs float64           `json:"middleware_ms"`
+	ActionMs     float64           `json:"action_ms"`
+	DBMs         float64           `json:"db_ms"`
+	DBCount      int64             `json:"db_count"`
+	ReqHeaders   map[string]string `json:"req_headers,omitempty"`
+	ResHeaders   map[string]string `json:"res_headers,omitempty"`
+	Params       x.MAP             `json:"params,omitempty"`
+	Post         any               `json:"post,omitempty"`
+	Body         string            `json:"body,omitempty"`
+	at           time.Time
+}
+
+var logTemplateRegex = regexp.MustCompile(`\{([\w.\-]+)\}`)
+
+func newAccessLog(ctx context.Context, hrr *httpResponseRecorder, r *http.Request, timing *x.Timing, data x.MAP, errno int32) *AccessLog { // {{{
+	_, ip := x.GetHttpCtxIp(ctx, r)
+
+	a := &AccessLog{
+		Guid:    ctx.Value(x.ConfGuidKey),
+		Tenant:  x.GetTenant(ctx),
+		Method:  r.Method,
+		Uri:     r.URL.String(),
+		Proto:   r.Proto,
+		Status:  hrr.Status(),
+		Errno:   errno,
+		Ip:      ip,
+		Ua:      r.UserAgent(),
+		Referer: r.Referer(),
+		Bytes:   hrr.Size(),
+		at:      time.Now(),
+	}
+
+	if timing != nil {
+		a.LatencyMs = durationMs(timing.Total())
+		a.MiddlewareMs = durationMs(timing.Middleware())
+		a.ActionMs = durationMs(timing.Action())
+		a.DBMs = durationMs(timing.DB())
+		a.DBCount = timing.DBCount()
+	}
+
+	a.Params, _ = ctx.Value("log_params").(x.MAP)
+	if post, ok := data["post"]; ok {
+		a.Post = post
+	}
+
+	return a
+} // }}}
+
+func durationMs(d time.Duration) float64 { // {{{
+	return float64(d.Microseconds()) / 1000
+} // }}}
+
+// Apache combined: %h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-agent}i"
+func (a *AccessLog) Combined() string { // {{{
+	var b strings.Builder
+
+	b.WriteString(a.Ip)
+	b.WriteString(" - - [")
+	b.WriteString(a.at.Format("02/Jan/2006:15:04:05 -0700"))
+	b.WriteString("] \"")
+	b.WriteString(a.Method)
+	b.WriteByte(' ')
+	b.WriteString(a.Uri)
+	b.WriteByte(' ')
+	b.WriteString(a.Proto)
+	b.WriteString("\" ")
+	b.WriteString(strconv.Itoa(a.Status))
+	b.WriteByte(' ')
+
+	if a.Bytes > 0 {
+		b.WriteString(strconv.Itoa(a.Bytes))
+	} else {
+		b.WriteByte('-')
+	}
+
+	b.WriteString(" \"")
+	b.WriteString(orDash(a.Referer))
+	b.WriteString("\" \"")
+	b.WriteString(orDash(a.Ua))
+	b.WriteByte('"')
+
+	return b.String()
+} // }}}
+
+func (a *AccessLog) Json() string { // {{{
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err.Error()
+	}
+
+	return string(b)
+} // }}}
+
+// 按模板输出, 占位符为 json 字段名, header 使用 {req_header.Name}/{res_header.Name}, 自定义参数使用 {param.name}
+func (a *AccessLog) Template(tpl string) string { // {{{
+	return logTemplateRegex.ReplaceAllStringFunc(tpl, func(s string) string {
+		name := s[1 : len(s)-1]
+
+		if k, ok := strings.CutPrefix(name, "req_header."); ok {
+			return orDash(a.ReqHeaders[http.CanonicalHeaderKey(k)])
+		}
+
+		if k, ok := strings.CutPrefix(name, "res_header."); ok {
+			return orDash(a.ResHeaders[http.CanonicalHeaderKey(k)])
+		}
+
+		if k, ok := strings.CutPrefix(name, "param."); ok {
+			return orDash(x.AsString(a.Params[k]))
+		}
+
+		switch name {
+		case "guid":
+			return orDash(x.AsString(a.Guid))
+		case "tenant":
+			return orDash(a.Tenant)
+		case "method":
+			return a.Method
+		case "uri":
+			return a.Uri
+		case "proto":
+			return a.Proto
+		case "status":
+			return strconv.Itoa(a.Status)
+		case "errno":
+			return strconv.Itoa(int(a.Errno))
+		case "ip":
+			return a.Ip
+		case "ua":
+			return orDash(a.Ua)
+		case "referer":
+			return orDash(a.Referer)
+		case "bytes":
+			return strconv.Itoa(a.Bytes)
+		case "time":
+			return a.at.Format(time.RFC3339)
+		case "latency_ms":
+			return strconv.FormatFloat(a.LatencyMs, 'f', -1, 64)
+		case "middleware_ms":
+			return strconv.FormatFloat(a.MiddlewareMs, 'f', -1, 64)
+		case "action_ms":
+			return strconv.FormatFloat(a.ActionMs, 'f', -1, 64)
+		case "db_ms":
+			return strconv.FormatFloat(a.DBMs, 'f', -1, 64)
+		case "db_count":
+			return strconv.FormatInt(a.DBCount, 10)
+		case "post":
+			if a.Post == nil {
+				return "-"
+			}
+			b, _ := json.Marshal(a.Post)
+			return string(b)
+		case "body":
+			return orDash(a.Body)
+		}
+
+		return s
+	})
+} // }}}
+
+func orDash(s string) string { // {{{
+	if s == "" {
+		return "-"
+	}
+
+	return s
+} // }}}
+
+// 按白名单提取 header
+func pickHeaders(h http.Header, names []string) map[string]string { // {{{
+	if len(names) == 0 {
+		return nil
+	}
+
+	ret := map[string]string{}
+	for _, name := range names {
+		if v := h.Values(name); len(v) > 0 {
+			ret[http.CanonicalHeaderKey(name)] = strings.Join(v, ", ")
+		}
+	}
+
+	return ret
+} // }}}
+
+// 状态码规则: 完整状态码(如 404) 或 类别(如 5xx)
+func matchStatus(status int, rules []string) bool { // {{{
+	code := strconv.Itoa(status)
+	for _, rule := range rules {
+		rule = strings.ToLower(strings.TrimSpace(rule))
+		if rule == code || (len(rule) == 3 && strings.HasSuffix(rule, "xx") && rule[0] == code[0]) {
+			return true
+		}
+	}
+
+	return false
+} // }}}
+
+// errno 规则: 具体错误码, 或 * 表示任意非 0 错误码
+func matchErrno(errno int32, rules []string) bool { // {{{
+	code := strconv.Itoa(int(errno))
+	for _, rule := range rules {
+		rule = strings.TrimSpace(rule)
+		if rule == code || (rule == "*" && errno != 0) {
+			return true
+		}
+	}
+
+	return false
+} // }}}
+
+// 是否记录本次请求: 未配置状态码及 errno 规则时全部记录, 否则满足任一规则即记录
+func logCheckInclude(config *LogConfig, status int, errno int32) bool { // {{{
+	if len(config.IncludeStatus) == 0 && len(config.IncludeErrno) == 0 {
+		return true
+	}
+
+	return matchStatus(status, config.IncludeStatus) || matchErrno(errno, config.IncludeErrno)
+} // }}}
+
+// 是否记录响应 body: 检查出错限制及 Content-Type 前缀
+func logCheckBody(config *LogConfig, hrr *httpResponseRecorder, errno int32) bool { // {{{
+	if config.BodyOnError && hrr.Status() < http.StatusBadRequest && errno == 0 {
+		return false
+	}
+
+	if len(config.BodyContentTypes) == 0 {
+		return true
+	}
+
+	content_type := strings.ToLower(hrr.Header().Get("Content-Type"))
+	for _, prefix := range config.BodyContentTypes {
+		if strings.HasPrefix(content_type, strings.ToLower(prefix)) {
+			return true
+		}
+	}
+
+	return false
+} // }}}
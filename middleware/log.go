@@ -21,6 +21,18 @@ type LogConfig struct {
 	CheckResMethod []string
 	CheckResExcept []string
 	Logger         *log.Logger
+
+	// 以下仅 HttpLog 使用
+	Format           string   // 日志格式: 空(默认) | combined | json | template
+	Template         string   // Format 为 template 时的模板
+	Latency          bool     // 默认格式时记录耗时分解(总耗时、中间件、action、DB)
+	ReqHeaders       []string // 记录的请求 header 白名单
+	ResHeaders       []string // 记录的响应 header 白名单
+	BodyMaxSize      int      // 记录响应 body 的最大字节数, 超出部分截断, 0 表示不限制
+	BodyContentTypes []string // 记录响应 body 的 Content-Type 前缀, 为空时不限制
+	BodyOnError      bool     // 仅在状态码 >= 400 或 errno 非 0 时记录响应 body
+	IncludeStatus    []string // 仅记录指定状态码的请求, 如 404、5xx
+	IncludeErrno     []string // 仅记录指定 errno 的请求, * 表示任意非 0, 与 IncludeStatus 满足其一即记录
 }
 
 func HttpLog(config *LogConfig) x.HttpMiddleware { // {{{
@@ -32,21 +44,69 @@ func HttpLog(config *LogConfig) x.HttpMiddleware { // {{{
 			ctx := r.Context()
 
 			if logCheckMethod(ctx, checkMethod, checkExcept) {
-				hrr := newhttpResponseRecorder(w)
+				timing := x.NewTiming()
+				r = r.WithContext(x.WithTiming(ctx, timing))
+
+				hrr := newhttpResponseRecorder(w, config.BodyMaxSize)
 				next.ServeHTTP(hrr, r)
 
 				ctx = r.Context()
+				errno, _ := ctx.Value("errno").(int32)
+
+				if !logCheckInclude(config, hrr.Status(), errno) {
+					return
+				}
+
 				checkReq := logCheckReqMethod(ctx, checkReqMethod, checkReqExcept)
 				data := getHttpLogData(ctx, hrr, r, checkReq)
 
 				body := ""
 
-				if logCheckResMethod(ctx, checkResMethod, checkResExcept) {
+				if logCheckResMethod(ctx, checkResMethod, checkResExcept) && logCheckBody(config, hrr, errno) {
 					body = hrr.Body()
 				}
 
-				errno, _ := ctx.Value("errno").(int32)
-				writeLog(ctx, config, errno, data, body)
+				req_headers := pickHeaders(r.Header, config.ReqHeaders)
+				res_headers := pickHeaders(hrr.Header(), config.ResHeaders)
+
+				switch config.Format {
+				case LogFormatDefault:
+					if config.Latency {
+						data["status"] = hrr.Status()
+						data["latency_ms"] = durationMs(timing.Total())
+						data["middleware_ms"] = durationMs(timing.Middleware())
+						data["action_ms"] = durationMs(timing.Action())
+						data["db_ms"] = durationMs(timing.DB())
+						data["db_count"] = timing.DBCount()
+					}
+
+					if req_headers != nil {
+						data["req_headers"] = req_headers
+					}
+
+					if res_headers != nil {
+						data["res_headers"] = res_headers
+					}
+
+					writeLog(ctx, config, errno, data, body)
+				default:
+					a := newAccessLog(ctx, hrr, r, timing, data, errno)
+					a.ReqHeaders = req_headers
+					a.ResHeaders = res_headers
+					a.Body = body
+
+					var line string
+					switch config.Format {
+					case LogFormatCombined:
+						line = a.Combined()
+					case LogFormatTemplate:
+						line = a.Template(config.Template)
+					default:
+						line = a.Json()
+					}
+
+					writeLog(ctx, config, errno, line)
+				}
 
 			} else {
 				next.ServeHTTP(w, r)
@@ -130,6 +190,10 @@ func getHttpLogData(ctx context.Context, w *httpResponseRecorder, r *http.Reques
 		"ua":          r.UserAgent(),
 	}
 
+	if tenant := x.GetTenant(ctx); tenant != "" {
+		ret["tenant"] = tenant
+	}
+
 	for k, v := range logParams {
 		ret[k] = v
 	}
@@ -171,6 +235,10 @@ func getRpcLogData(ctx context.Context, params x.MAP, checkReq bool) x.MAP { //
 		"ip":          ip,
 	}
 
+	if tenant := x.GetTenant(ctx); tenant != "" {
+		ret["tenant"] = tenant
+	}
+
 	for k, v := range logParams {
 		ret[k] = v
 	}
@@ -344,25 +412,69 @@ func getRequestBody(r *http.Request) (rbody []byte, err error) { // {{{
 
 type httpResponseRecorder struct { // {{{
 	http.ResponseWriter
-	body *bytes.Buffer
+	body        *bytes.Buffer
+	bodyMaxSize int // body 最大记录字节数, 0 表示不限制
+	status      int
+	size        int // 实际输出字节数
 }
 
-func newhttpResponseRecorder(w http.ResponseWriter) *httpResponseRecorder {
+func newhttpResponseRecorder(w http.ResponseWriter, body_max_size int) *httpResponseRecorder {
 	return &httpResponseRecorder{
 		ResponseWriter: w,
 		body:           bytes.NewBuffer(nil),
+		bodyMaxSize:    max(body_max_size, 0),
 	}
 }
 
 func (h *httpResponseRecorder) WriteHeader(statusCode int) {
+	if h.status == 0 {
+		h.status = statusCode
+	}
 	h.ResponseWriter.WriteHeader(statusCode)
 }
 
 func (h *httpResponseRecorder) Write(b []byte) (int, error) {
-	h.body.Write(b)
-	return h.ResponseWriter.Write(b)
+	if h.status == 0 {
+		h.status = http.StatusOK
+	}
+
+	if h.bodyMaxSize == 0 {
+		h.body.Write(b)
+	} else if remain := h.bodyMaxSize - h.body.Len(); remain > 0 {
+		h.body.Write(b[:min(remain, len(b))])
+	}
+
+	n, err := h.ResponseWriter.Write(b)
+	h.size += n
+
+	return n, err
 }
 
 func (h *httpResponseRecorder) Body() string {
+	if h.bodyMaxSize > 0 && h.size > h.body.Len() {
+		return h.body.String() + "...(truncated)"
+	}
+
 	return h.body.String()
+}
+
+// 响应状态码, 未显式设置时为 200
+func (h *httpResponseRecorder) Status() int {
+	if h.status == 0 {
+		return http.StatusOK
+	}
+
+	return h.status
+}
+
+// 响应 body 字节数
+func (h *httpResponseRecorder) Size() int {
+	return h.size
+}
+
+// 支持 http.Flusher
+func (h *httpResponseRecorder) Flush() {
+	if f, ok := h.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
 } // }}}
@@ -0,0 +1,229 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/nyxless/nyx/controller"
+	"github.com/nyxless/nyx/x"
+	"google.golang.org/grpc/metadata"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	DefaultTenantErrHandler = func(w http.ResponseWriter, r *http.Request) { // {{{
+		ctx := r.Context()
+		group, _ := ctx.Value("group").(string)
+		controllerName, _ := ctx.Value("controller").(string)
+		actionName, _ := ctx.Value("action").(string)
+
+		c := &controller.HTTP{}
+		c.Prepare(w, r, controllerName, actionName, group)
+		c.RenderError(x.ErrTenant)
+		c.Final()
+	} // }}}
+
+	DefaultRpcTenantErrHandler = func(ctx context.Context, params map[string]any, stream x.Stream) (context.Context, *x.ResponseData, error) { // {{{
+		group, _ := ctx.Value("group").(string)
+		controllerName, _ := ctx.Value("controller").(string)
+		actionName, _ := ctx.Value("action").(string)
+
+		c := &controller.RPC{}
+		c.Prepare(ctx, params, controllerName, actionName, group, stream)
+		c.RenderError(x.ErrTenant)
+		return c.GetResponseData()
+	} // }}}
+)
+
+type TenantConfig struct {
+	Header      string // 租户 header, 如 X-Tenant-ID
+	TrustHeader bool   // header 及 metadata 由可信的网关设置时才作为租户来源, 否则只用于与 jwt 比对
+	Subdomain   string // 基础域名, 如 example.com, 取 acme.example.com 中的 acme
+	JwtClaim    string // Authorization: Bearer <jwt> 中租户的 claim
+	JwtSecret   string // jwt 的 HS256 密钥, 为空时不从 jwt 取租户
+	Metadata    string // rpc metadata 中租户的 key
+	Required    bool   // 无法取得租户时返回 ErrTenant
+
+	// 自定义解析函数, 返回空时继续按以上来源解析
+	HttpResolver func(r *http.Request) string
+	RpcResolver  func(ctx context.Context) string
+}
+
+// 依次从自定义解析函数、jwt claim、可信的 header 或子域名解析租户, 存入 ctx
+// 租户无效(不在配置的 tenants 中)或 header、子域名与 jwt 中的租户不一致时返回 ErrTenant
+func HttpTenant(config *TenantConfig) x.HttpMiddleware { // {{{
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tenant, ok := httpTenant(config, r)
+
+			if !ok || tenant == "" && config.Required || tenant != "" && !x.ValidTenant(tenant) {
+				DefaultTenantErrHandler(w, r)
+				return
+			}
+
+			if tenant != "" {
+				// 与路由处理一致, 直接修改 r, 外层中间件(如日志)可取得租户
+				*r = *r.WithContext(x.WithTenant(r.Context(), tenant))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+} // }}}
+
+// 依次从自定义解析函数、jwt claim 或可信的 metadata 解析租户, 存入 ctx; metadata 与 jwt 中的租户不一致时返回 ErrTenant
+func RpcTenant(config *TenantConfig) x.RpcMiddleware { // {{{
+	return func(next x.RpcHandler) x.RpcHandler {
+		return func(ctx context.Context, params map[string]any, stream x.Stream) (context.Context, *x.ResponseData, error) {
+			tenant, ok := rpcTenant(config, ctx)
+
+			if !ok || tenant == "" && config.Required || tenant != "" && !x.ValidTenant(tenant) {
+				return DefaultRpcTenantErrHandler(ctx, params, stream)
+			}
+
+			if tenant != "" {
+				ctx = x.WithTenant(ctx, tenant)
+			}
+
+			return next(ctx, params, stream)
+		}
+	}
+} // }}}
+
+// 解析 http 请求的租户, ok 为 false 表示来源之间不一致
+// 先检查经过认证的来源(自定义解析函数、jwt), 客户端可任意设置的 header 只在 TrustHeader 时使用
+func httpTenant(config *TenantConfig, r *http.Request) (string, bool) { // {{{
+	if config.HttpResolver != nil {
+		if tenant := config.HttpResolver(r); tenant != "" {
+			return tenant, true
+		}
+	}
+
+	var header, sub string
+	if config.Header != "" {
+		header = r.Header.Get(config.Header)
+	}
+
+	if config.Subdomain != "" {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+
+		if s, ok := strings.CutSuffix(strings.ToLower(host), "."+strings.ToLower(config.Subdomain)); ok && s != "" && !strings.Contains(s, ".") {
+			sub = s
+		}
+	}
+
+	if tenant := jwtTenant(config, r.Header.Get("Authorization")); tenant != "" {
+		if header != "" && header != tenant || sub != "" && sub != tenant {
+			return "", false
+		}
+
+		return tenant, true
+	}
+
+	if header != "" && config.TrustHeader {
+		return header, true
+	}
+
+	return sub, true
+} // }}}
+
+// 解析 rpc 请求的租户, ok 为 false 表示 metadata 与 jwt 中的租户不一致
+func rpcTenant(config *TenantConfig, ctx context.Context) (string, bool) { // {{{
+	if config.RpcResolver != nil {
+		if tenant := config.RpcResolver(ctx); tenant != "" {
+			return tenant, true
+		}
+	}
+
+	headers, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", true
+	}
+
+	var meta string
+	if config.Metadata != "" {
+		if v := headers.Get(config.Metadata); len(v) > 0 {
+			meta = v[0]
+		}
+	}
+
+	if v := headers.Get("authorization"); len(v) > 0 {
+		if tenant := jwtTenant(config, v[0]); tenant != "" {
+			if meta != "" && meta != tenant {
+				return "", false
+			}
+
+			return tenant, true
+		}
+	}
+
+	if config.TrustHeader {
+		return meta, true
+	}
+
+	return "", true
+} // }}}
+
+// 校验 HS256 签名及过期时间后, 返回 jwt 中租户的 claim
+func jwtTenant(config *TenantConfig, authorization string) string { // {{{
+	if config.JwtClaim == "" || config.JwtSecret == "" {
+		return ""
+	}
+
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		return ""
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return ""
+	}
+
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	if json.Unmarshal(header, &h) != nil || h.Alg != "HS256" {
+		return ""
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, []byte(config.JwtSecret))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return ""
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+
+	var claims map[string]any
+	if json.Unmarshal(payload, &claims) != nil {
+		return ""
+	}
+
+	if exp, ok := claims["exp"].(float64); ok && time.Now().Unix() >= int64(exp) {
+		return ""
+	}
+
+	return x.AsString(claims[config.JwtClaim])
+} // }}}
@@ -1,6 +1,7 @@
 package nyx
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
@@ -8,12 +9,16 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/nyxless/nyx/dao"
+	"github.com/nyxless/nyx/dao/migrate"
 	"github.com/nyxless/nyx/middleware"
 	"github.com/nyxless/nyx/tools"
 	"github.com/nyxless/nyx/x"
 	"github.com/nyxless/nyx/x/cache"
+	"github.com/nyxless/nyx/x/db"
 	"github.com/nyxless/nyx/x/log"
 	"google.golang.org/grpc"
 )
@@ -29,9 +34,11 @@ const (
 )
 
 type Nyx struct {
-	Mode      []string
-	cliPath   string
-	cliParams string
+	Mode         []string
+	cliPath      string
+	cliParams    string
+	migrate      string
+	migrateSteps int
 }
 
 func NewNyx() *Nyx {
@@ -73,6 +80,9 @@ func (n *Nyx) envInit() { // {{{
 	flag.StringVar(&uri, "p", "", "path when cli-mode")
 	flag.StringVar(&params, "q", "", "params when cli-mode")
 
+	flag.StringVar(&n.migrate, "migrate", "", "run migrations, up,down,status or auto") // auto: 启动服务前执行未执行的迁移
+	flag.IntVar(&n.migrateSteps, "migrate_steps", 0, "migration steps when -migrate up/down")
+
 	flag.Parse()
 
 	x.Debug = debug //全局 debug 开关
@@ -125,9 +135,15 @@ func (n *Nyx) envInit() { // {{{
 		os.Exit(1)
 	}
 
+	// 慢查询日志及 sql 审计
+	n.useSqlLog()
+
 	// 初始化本地缓存
 	n.useLocalCache()
 
+	// 注册内置健康检查项
+	n.useHealthChecks()
+
 	x.Info("Run Cmd: ", os.Args)
 	if x.Debug {
 		x.Info("Debug model: ", x.Colorize("open", "green+bold+underline"))
@@ -186,6 +202,15 @@ func (n *Nyx) useHttpMiddlewares() { // {{{
 		x.Info("Load http middleware: ", "ApiAuth")
 	} // }}}
 
+	// 加载 http 多租户中间件
+	if x.Conf.GetDefBool(false, "tenant", "enabled") { // {{{
+		allowed_groups := x.Conf.GetDefStringSlice([]string{}, "tenant", "allowed_groups")
+
+		x.UseHttpMiddleware(middleware.HttpTenant(tenantConfig()), allowed_groups...)
+
+		x.Info("Load http middleware: ", "HttpTenant")
+	} // }}}
+
 	// 加载 http Compress 中间件
 	if x.Conf.GetDefBool(false, "compress", "enabled") { // {{{
 		allowed_groups := x.Conf.GetDefStringSlice([]string{}, "compress", "allowed_groups")
@@ -216,6 +241,17 @@ func (n *Nyx) useHttpMiddlewares() { // {{{
 			CheckResMethod: x.Conf.GetStringSlice("http_log", "res_method"),
 			CheckResExcept: x.Conf.GetStringSlice("http_log", "res_except"),
 			Logger:         x.Logger,
+
+			Format:           x.Conf.GetString("http_log", "format"),
+			Template:         x.Conf.GetString("http_log", "template"),
+			Latency:          x.Conf.GetDefBool(false, "http_log", "latency"),
+			ReqHeaders:       x.Conf.GetStringSlice("http_log", "req_headers"),
+			ResHeaders:       x.Conf.GetStringSlice("http_log", "res_headers"),
+			BodyMaxSize:      x.Conf.GetDefInt(0, "http_log", "body_max_size"),
+			BodyContentTypes: x.Conf.GetStringSlice("http_log", "body_content_types"),
+			BodyOnError:      x.Conf.GetDefBool(false, "http_log", "body_on_error"),
+			IncludeStatus:    x.Conf.GetStringSlice("http_log", "include_status"),
+			IncludeErrno:     x.Conf.GetStringSlice("http_log", "include_errno"),
 		}
 
 		x.UseHttpMiddleware(middleware.HttpLog(c), allowed_groups...)
@@ -258,6 +294,15 @@ func (n *Nyx) useRpcMiddlewares() { // {{{
 		x.Info("Load rpc middleware: ", "RpcAuth")
 	} // }}}
 
+	// 加载 rpc 多租户中间件
+	if x.Conf.GetDefBool(false, "tenant", "enabled") { // {{{
+		allowed_groups := x.Conf.GetDefStringSlice([]string{}, "tenant", "allowed_groups")
+
+		x.UseRpcMiddleware(middleware.RpcTenant(tenantConfig()), allowed_groups...)
+
+		x.Info("Load rpc middleware: ", "RpcTenant")
+	} // }}}
+
 	// 加载 rpc log 中间件
 	if x.Conf.GetDefBool(false, "rpc_log", "enabled") && x.Logger != nil { // {{{
 		allowed_groups := x.Conf.GetDefStringSlice([]string{}, "rpc_log", "allowed_groups")
@@ -282,6 +327,19 @@ func (n *Nyx) useRpcMiddlewares() { // {{{
 
 } // }}}
 
+// 多租户中间件配置
+func tenantConfig() *middleware.TenantConfig { // {{{
+	return &middleware.TenantConfig{
+		Header:      x.Conf.GetString("tenant", "header"),
+		TrustHeader: x.Conf.GetDefBool(false, "tenant", "trust_header"),
+		Subdomain:   x.Conf.GetString("tenant", "subdomain"),
+		JwtClaim:    x.Conf.GetString("tenant", "jwt_claim"),
+		JwtSecret:   x.Conf.GetString("tenant", "jwt_secret"),
+		Metadata:    x.Conf.GetString("tenant", "metadata"),
+		Required:    x.Conf.GetDefBool(false, "tenant", "required"),
+	}
+} // }}}
+
 // 缓存配置文件变量
 func (n *Nyx) cacheConf() { // {{{
 	x.ConfEnvMode = x.Conf.GetString("env_mode")
@@ -309,9 +367,15 @@ func (n *Nyx) cacheConf() { // {{{
 	x.ConfRpcLogOmitParams = x.Conf.GetStringSlice("rpc_log", "omit_params")
 	x.ConfDefaultController = strings.ToLower(x.Conf.GetDefString("index", "default_controller"))
 	x.ConfDefaultAction = strings.ToLower(x.Conf.GetDefString("index", "default_action"))
+	x.ConfCursorSecret = x.Conf.GetString("cursor_secret")
 	x.ConfMonitorPort = x.Conf.GetString("monitor_port")
 	x.ConfMonitorPath = x.Conf.GetDefString("/healthy", "monitor_path")
+	x.ConfLivezPath = x.Conf.GetDefString("/livez", "health", "livez_path")
+	x.ConfReadyzPath = x.Conf.GetDefString("/readyz", "health", "readyz_path")
 	x.ConfPprofEnabled = x.Conf.GetDefBool(false, "pprof_enabled")
+	x.ConfDBStatsEnabled = x.Conf.GetDefBool(false, "db_stats", "enabled")
+	x.ConfDBStatsPath = x.Conf.GetDefString("/debug/db", "db_stats", "path")
+	x.ConfErrStatusEnabled = x.Conf.GetDefBool(false, "err_status_enabled")
 
 	n.parseRouter()
 	n.parseErrMsg()
@@ -477,6 +541,27 @@ func (n *Nyx) parseErrMsg() { // {{{
 	for code, msgs := range err_msg {
 		x.ErrMapRo[x.AsInt32(code)] = x.AsStringMap(msgs)
 	}
+
+	// 多语言错误信息文件目录: errors.<lang>.yaml
+	bundle_path := x.Conf.GetDefString("conf/errors", "err_bundle_path")
+	if !filepath.IsAbs(bundle_path) {
+		bundle_path = filepath.Join(x.AppRoot, bundle_path)
+	}
+
+	if err := x.LoadErrBundles(bundle_path); err != nil {
+		x.Println("Error: ", err)
+		os.Exit(1)
+	}
+
+	for lang, alias := range x.Conf.GetStringMap("lang_alias") {
+		x.LangAlias[strings.ToUpper(lang)] = strings.ToUpper(alias)
+	}
+
+	for lang, fallback := range x.Conf.GetMap("lang_fallback") {
+		x.LangFallback[x.NormalizeLang(lang)] = x.AsStringSlice(fallback)
+	}
+
+	x.RefreshErrLangs()
 } // }}}
 
 // 初始化日志
@@ -503,6 +588,10 @@ func (n *Nyx) useLogger() error { // {{{
 		CompressBefore: x.AsInt(log_rule["compress_before"]),
 		Remove:         x.AsBool(log_rule["remove"]),
 		RemoveBefore:   x.AsInt(log_rule["remove_before"]),
+		CompressType:   x.AsString(log_rule["compress_type"]),
+		MaxTotalSize:   x.AsInt64(log_rule["max_total_size"]),
+		MaxFiles:       x.AsInt(log_rule["max_files"]),
+		Manifest:       x.AsBool(log_rule["manifest"]),
 	}
 
 	file_level_rule := map[string]*log.LogFileRule{}
@@ -521,6 +610,10 @@ func (n *Nyx) useLogger() error { // {{{
 		compressBeforeNode, _ := x.GetNode(rule, "compress_before")
 		removeNode, _ := x.GetNode(rule, "remove")
 		removeBeforeNode, _ := x.GetNode(rule, "remove_before")
+		compressTypeNode, _ := x.GetNode(rule, "compress_type")
+		maxTotalSizeNode, _ := x.GetNode(rule, "max_total_size")
+		maxFilesNode, _ := x.GetNode(rule, "max_files")
+		manifestNode, _ := x.GetNode(rule, "manifest")
 
 		file_level_rule[level_name] = &log.LogFileRule{
 			Path:           logpath,
@@ -531,7 +624,59 @@ func (n *Nyx) useLogger() error { // {{{
 			CompressBefore: x.AsInt(compressBeforeNode, file_rule.CompressBefore),
 			Remove:         x.AsBool(removeNode, file_rule.Remove),
 			RemoveBefore:   x.AsInt(removeBeforeNode, file_rule.RemoveBefore),
+			CompressType:   x.AsString(compressTypeNode, file_rule.CompressType),
+			MaxTotalSize:   x.AsInt64(maxTotalSizeNode, file_rule.MaxTotalSize),
+			MaxFiles:       x.AsInt(maxFilesNode, file_rule.MaxFiles),
+			Manifest:       x.AsBool(manifestNode, file_rule.Manifest),
+		}
+	}
+
+	var sinks []*log.SinkRule
+	for _, v := range x.Conf.GetMapSlice("log", "sinks") {
+		spool_path := x.AsString(v["spool_path"])
+		if spool_path != "" && !filepath.IsAbs(spool_path) {
+			spool_path = filepath.Join(x.AppRoot, spool_path)
 		}
+
+		sinks = append(sinks, &log.SinkRule{
+			Name:      x.AsString(v["name"]),
+			Type:      x.AsString(v["type"]),
+			Network:   x.AsString(v["network"]),
+			Addr:      x.AsString(v["addr"]),
+			Tag:       x.AsString(v["tag"]),
+			Facility:  x.AsInt(v["facility"]),
+			Format:    x.AsString(v["format"]),
+			Levels:    x.AsStringSlice(v["levels"]),
+			QueueSize: x.AsInt(v["queue_size"]),
+			SpoolPath: spool_path,
+			SpoolSize: x.AsInt64(v["spool_size"]),
+		})
+	}
+
+	// 日志脱敏
+	var redactor *log.Redactor
+	if x.Conf.GetDefBool(false, "log", "redact", "enabled") {
+		var rules []*log.RedactRule
+		if x.Conf.GetDefBool(true, "log", "redact", "default_rules") {
+			rules = append(rules, log.DefaultRedactRules...)
+		}
+
+		for _, v := range x.Conf.GetMapSlice("log", "redact", "rules") {
+			rules = append(rules, &log.RedactRule{
+				Keys:    x.AsStringSlice(v["keys"]),
+				Pattern: x.AsString(v["pattern"]),
+				Mask:    x.AsString(v["mask"]),
+				Luhn:    x.AsBool(v["luhn"]),
+			})
+		}
+
+		var err error
+		redactor, err = log.NewRedactor(rules...)
+		if err != nil {
+			return err
+		}
+
+		db.SqlRedactor = redactor
 	}
 
 	log_options := &log.LogOptions{
@@ -543,6 +688,8 @@ func (n *Nyx) useLogger() error { // {{{
 		FileEnabled:   x.Conf.GetDefBool(true, "log", "file_enabled"),
 		FileRule:      file_rule,
 		FileLevelRule: file_level_rule,
+		Sinks:         sinks,
+		Redactor:      redactor,
 		ShowLevel:     x.Conf.GetDefBool(true, "log", "show_level"),
 		Prefix:        x.Conf.GetString("log", "prefix"),
 		TimeFormat:    x.Conf.GetString("log", "time_format"),
@@ -554,14 +701,73 @@ func (n *Nyx) useLogger() error { // {{{
 		return err
 	}
 
+	db.Logger = x.Logger
+
 	if x.Debug {
 		x.Logger.SetDebug(true)
 		x.Logger.SetLevel(log.LevelAll)
 	}
 
+	// 收到 log.rotate_signal 指定的信号(默认 SIGWINCH)时重新打开日志文件, 配合外部 logrotate 使用
+	// SIGHUP、SIGUSR1、SIGUSR2 已由 endless 用于平滑重启, 不可使用
+	if x.Conf.GetDefBool(false, "log", "rotate_on_signal") {
+		sig, err := rotateSignal(x.Conf.GetDefString("SIGWINCH", "log", "rotate_signal"))
+		if err != nil {
+			return err
+		}
+
+		x.Logger.RotateOnSignal(sig)
+	}
+
 	return nil
 } // }}}
 
+// 日志重新打开使用的信号, 只允许 endless 及 runtime(SIGURG, SIGPROF)未使用的信号
+func rotateSignal(name string) (os.Signal, error) { // {{{
+	switch strings.ToUpper(name) {
+	case "SIGWINCH":
+		return syscall.SIGWINCH, nil
+	case "SIGIO":
+		return syscall.SIGIO, nil
+	}
+
+	return nil, fmt.Errorf("不支持的日志重新打开信号: %s, 可选 SIGWINCH, SIGIO", name)
+} // }}}
+
+// 慢查询日志及 sql 审计, 见 db.SqlLogConfig
+func (n *Nyx) useSqlLog() { // {{{
+	if x.Logger == nil || !x.Conf.GetDefBool(false, "sql_log", "enabled") {
+		return
+	}
+
+	db.SetSqlLog(&db.SqlLogConfig{
+		Logger:         x.Logger,
+		SlowQuery:      time.Duration(x.Conf.GetDefInt(500, "sql_log", "slow_query")) * time.Millisecond,
+		SlowLevelName:  x.Conf.GetString("sql_log", "slow_level_name"),
+		Audit:          x.Conf.GetDefBool(false, "sql_log", "audit"),
+		AuditLevelName: x.Conf.GetString("sql_log", "audit_level_name"),
+		ExplainRate:    x.Conf.GetDefFloat64(0, "sql_log", "explain_rate"),
+		GuidKey:        x.ConfGuidKey,
+	})
+} // }}}
+
+// 健康检查: 超时、结果缓存时间及框架内置检查项(db、redis 检查的配置名见 health.db、health.redis)
+func (n *Nyx) useHealthChecks() { // {{{
+	if timeout := x.Conf.GetDefInt(0, "health", "timeout"); timeout > 0 {
+		x.DefaultHealthTimeout = time.Duration(timeout) * time.Millisecond
+	}
+
+	if cache_ttl := x.Conf.GetDefInt(0, "health", "cache_ttl"); cache_ttl > 0 {
+		x.DefaultHealthCacheTTL = time.Duration(cache_ttl) * time.Millisecond
+	}
+
+	if x.Conf.GetDefBool(true, "health", "default_checks") {
+		// 日志队列使用率阈值(百分比), 超过时就绪检查失败
+		threshold := x.Conf.GetDefInt(90, "health", "log_queue_threshold")
+		x.RegisterDefaultHealthChecks(float64(threshold) / 100)
+	}
+} // }}}
+
 // 初始化本地缓存
 func (n *Nyx) useLocalCache() { // {{{
 	localcache_enabled := x.Conf.GetDefBool(false, "localcache", "enabled")
@@ -573,6 +779,12 @@ func (n *Nyx) useLocalCache() { // {{{
 		}
 
 		x.Info("LocalCache Init, size:", localcache_size)
+
+		// Dao 查询缓存的二级缓存及写入失效, 见 dao.InitCache
+		if err := dao.InitCache(); err != nil {
+			x.Println("Error: ", err)
+			os.Exit(1)
+		}
 	}
 } // }}}
 
@@ -623,6 +835,7 @@ func (n *Nyx) Run() {
 
 func (n *Nyx) run(modes ...string) { // {{{
 	defer func() {
+		dao.CloseCache()
 		x.Redis.Close()
 		x.DB.Close()
 		if x.LocalCache != nil {
@@ -637,6 +850,18 @@ func (n *Nyx) run(modes ...string) { // {{{
 		x.Warn("======= Server Exit: " + x.DateTime() + " " + x.TIME_ZONE + " ======")
 	}()
 
+	// 数据库迁移, 除 auto 外执行完成后退出
+	if n.migrate != "" {
+		if err := n.runMigrate(); err != nil {
+			x.Warn("Migrate Error: ", err)
+			os.Exit(1)
+		}
+
+		if n.migrate != "auto" {
+			return
+		}
+	}
+
 	if len(modes) == 0 {
 		x.Warn("Error: ", "未指定运行模式")
 		os.Exit(1)
@@ -714,6 +939,57 @@ func (n *Nyx) run(modes ...string) { // {{{
 
 } // }}}
 
+// 执行数据库迁移, 使用 migrate.db 指定的数据库配置(默认 db_master)
+func (n *Nyx) runMigrate() error { // {{{
+	m, err := migrate.NewDefaultMigrator()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	switch n.migrate {
+	case "up", "auto":
+		done, err := m.Up(ctx, n.migrateSteps)
+		for _, mig := range done {
+			x.Successf("Migrate Up: %d_%s", mig.Version, mig.Name)
+		}
+
+		if err == nil && len(done) == 0 {
+			x.Info("Migrate: ", "no pending migrations")
+		}
+
+		return err
+
+	case "down":
+		done, err := m.Down(ctx, n.migrateSteps)
+		for _, mig := range done {
+			x.Successf("Migrate Down: %d_%s", mig.Version, mig.Name)
+		}
+
+		return err
+
+	case "status":
+		list, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, st := range list {
+			applied_at := "pending"
+			if st.Applied {
+				applied_at = st.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+
+			x.Printf("%-16d %-40s %-20s %s", st.Version, st.Name, applied_at, st.Source)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("不支持的迁移命令: %s", n.migrate)
+} // }}}
+
 // 生成pid文件
 func (n *Nyx) genPidFile() { // {{{
 	pid := os.Getpid()
@@ -67,7 +67,7 @@ func DebugRpc(rw http.ResponseWriter, r *http.Request) { // {{{
 	}
 	rpc_res, err := rpcRequest(r.Context(), controller_name, action_name, params, rpc_hds)
 	if err != nil {
-		c.RenderError(x.NewErr(x.ErrOther.GetCode(), err.Error()))
+		c.RenderError(x.ErrOther.With(err.Error()))
 	} else {
 		c.Render(rpc_res)
 	}
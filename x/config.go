@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/nyxless/nyx/x/yaml"
 	"path/filepath"
+	"sort"
 )
 
 type Config struct {
@@ -81,6 +82,19 @@ func (cv *ConfVal) Int64(def ...int64) int64 { // {{{
 	return d
 } // }}}
 
+func (cv *ConfVal) Float64(def ...float64) float64 { // {{{
+	if cv.found {
+		return AsFloat64(cv.val)
+	}
+
+	var d float64
+	if len(def) > 0 {
+		d = def[0]
+	}
+
+	return d
+} // }}}
+
 func (cv *ConfVal) Bool(def ...bool) bool { // {{{
 	if cv.found {
 		return AsBool(cv.val)
@@ -229,6 +243,14 @@ func (c *Config) GetConifg(keys ...string) *Config { // {{{
 	}
 } // }}}
 
+// 顶层配置名, 按字母排序
+func (c *Config) Keys() []string { // {{{
+	keys := MapKeys(c.data)
+	sort.Strings(keys)
+
+	return keys
+} // }}}
+
 func (c *Config) GetString(keys ...string) string { // {{{
 	return c.Get(keys...).String()
 } // }}}
@@ -241,6 +263,10 @@ func (c *Config) GetInt64(keys ...string) int64 { // {{{
 	return c.Get(keys...).Int64()
 } // }}}
 
+func (c *Config) GetFloat64(keys ...string) float64 { // {{{
+	return c.Get(keys...).Float64()
+} // }}}
+
 func (c *Config) GetBool(keys ...string) bool { // {{{
 	return c.Get(keys...).Bool()
 } // }}}
@@ -289,6 +315,10 @@ func (c *Config) GetDefInt64(def int64, keys ...string) int64 { // {{{
 	return c.Get(keys...).Int64(def)
 } // }}}
 
+func (c *Config) GetDefFloat64(def float64, keys ...string) float64 { // {{{
+	return c.Get(keys...).Float64(def)
+} // }}}
+
 func (c *Config) GetDefBool(def bool, keys ...string) bool { // {{{
 	return c.Get(keys...).Bool(def)
 } // }}}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"log"
+	"runtime/debug"
+	"sync"
+)
+
+// 事务提交后执行的函数, 回滚时丢弃
+type commitHooks struct {
+	mutex sync.Mutex
+	fns   []func()
+}
+
+func (h *commitHooks) add(fn func()) { // {{{
+	h.mutex.Lock()
+	h.fns = append(h.fns, fn)
+	h.mutex.Unlock()
+} // }}}
+
+func (h *commitHooks) len() int { // {{{
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return len(h.fns)
+} // }}}
+
+// 丢弃第 n 个之后的函数, 用于回滚(n 为 0)或回滚到 SAVEPOINT
+func (h *commitHooks) discard(n int) { // {{{
+	h.mutex.Lock()
+	if n < len(h.fns) {
+		h.fns = h.fns[:n]
+	}
+	h.mutex.Unlock()
+} // }}}
+
+func (h *commitHooks) run() { // {{{
+	h.mutex.Lock()
+	fns := h.fns
+	h.fns = nil
+	h.mutex.Unlock()
+
+	for _, fn := range fns {
+		runCommitHook(fn)
+	}
+} // }}}
+
+func runCommitHook(fn func()) { // {{{
+	defer func() {
+		if e := recover(); e != nil {
+			log.Printf("commit hook panic: %v\n%s", e, debug.Stack())
+		}
+	}()
+
+	fn()
+} // }}}
+
+// 在 client 的事务提交后执行 fn, 事务回滚时不执行; client 不在事务中时立即执行
+func AfterCommit(client DBClient, fn func()) { // {{{
+	if s, ok := client.(*SqlClient); ok && s.intx && s.hooks != nil {
+		s.hooks.add(fn)
+		return
+	}
+
+	runCommitHook(fn)
+} // }}}
+
+// 事务中已注册的提交后函数数量, 与 DiscardAfterCommit 配合用于 SAVEPOINT
+func PendingAfterCommit(client DBClient) int { // {{{
+	if s, ok := client.(*SqlClient); ok && s.hooks != nil {
+		return s.hooks.len()
+	}
+
+	return 0
+} // }}}
+
+// 丢弃第 n 个之后注册的提交后函数, 用于回滚到 SAVEPOINT
+func DiscardAfterCommit(client DBClient, n int) { // {{{
+	if s, ok := client.(*SqlClient); ok && s.hooks != nil {
+		s.hooks.discard(n)
+	}
+} // }}}
@@ -0,0 +1,176 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// sql 执行因 context 取消或超时而中断时返回的错误
+// 可使用 errors.As 取得, 或使用 IsCanceled 判断
+type CanceledError struct {
+	Query string // 被中断的 sql
+	Cause error  // context.Canceled 或 context.DeadlineExceeded
+	Err   error  // driver 返回的原始错误
+}
+
+func (e *CanceledError) Error() string { // {{{
+	if e.Timeout() {
+		return fmt.Sprintf("sql timeout: %v, query: %s", e.Err, e.Query)
+	}
+
+	return fmt.Sprintf("sql canceled: %v, query: %s", e.Err, e.Query)
+} // }}}
+
+func (e *CanceledError) Unwrap() []error { // {{{
+	return []error{e.Cause, e.Err}
+} // }}}
+
+// 是否因超时中断
+func (e *CanceledError) Timeout() bool { // {{{
+	return errors.Is(e.Cause, context.DeadlineExceeded)
+} // }}}
+
+func IsCanceled(err error) bool { // {{{
+	var ce *CanceledError
+	return errors.As(err, &ce)
+} // }}}
+
+// 为 ctx 指定单条 sql 的超时时间, 覆盖 DB 配置中的 query_timeout
+func WithQueryTimeout(ctx context.Context, timeout time.Duration) context.Context { // {{{
+	return context.WithValue(ctx, "db_query_timeout", timeout)
+} // }}}
+
+// 为 ctx 指定 insert 时返回的主键字段, 用于不支持 LastInsertId 的数据库(如 postgres)
+func WithReturning(ctx context.Context, primary string) context.Context { // {{{
+	return context.WithValue(ctx, "db_returning", primary)
+} // }}}
+
+func getReturning(ctx context.Context) string { // {{{
+	primary, _ := ctx.Value("db_returning").(string)
+	return primary
+} // }}}
+
+// 为 ctx 指定 upsert 的保护字段(如租户字段): 冲突时只更新已有记录中该字段与待插入的值相同的记录, 且不更新该字段
+func WithUpsertGuard(ctx context.Context, field string) context.Context { // {{{
+	return context.WithValue(ctx, "db_upsert_guard", field)
+} // }}}
+
+func getUpsertGuard(ctx context.Context) string { // {{{
+	if ctx == nil {
+		return ""
+	}
+
+	field, _ := ctx.Value("db_upsert_guard").(string)
+	return field
+} // }}}
+
+// 绑定到 ctx 的事务, 多个 DB 的事务以链表形式保存
+type boundTx struct {
+	parent DBClient // 开启事务的 DB
+	tx     DBClient
+	next   *boundTx
+}
+
+// 将事务绑定到 ctx, 使用该 ctx 且 DB 为 parent 的 Dao 自动加入事务
+func WithTx(ctx context.Context, parent, tx DBClient) context.Context { // {{{
+	next, _ := ctx.Value("db_tx").(*boundTx)
+	return context.WithValue(ctx, "db_tx", &boundTx{parent: parent, tx: tx, next: next})
+} // }}}
+
+// 取得 ctx 中绑定的 parent 上的事务, 不存在时返回 nil
+func GetTx(ctx context.Context, parent DBClient) DBClient { // {{{
+	if ctx == nil || parent == nil {
+		return nil
+	}
+
+	bt, _ := ctx.Value("db_tx").(*boundTx)
+	for ; bt != nil; bt = bt.next {
+		if bt.parent == parent {
+			return bt.tx
+		}
+	}
+
+	return nil
+} // }}}
+
+// ctx 中是否绑定了事务
+func InTx(ctx context.Context) bool { // {{{
+	if ctx == nil {
+		return false
+	}
+
+	_, ok := ctx.Value("db_tx").(*boundTx)
+	return ok
+} // }}}
+
+// 读写一致: 在 ctx 中放入写入标记(由控制器在请求开始时放入), 写入后使用该 ctx 的读操作使用主库
+func WithSticky(ctx context.Context) context.Context { // {{{
+	if _, ok := ctx.Value("db_sticky").(*atomic.Bool); ok {
+		return ctx
+	}
+
+	return context.WithValue(ctx, "db_sticky", new(atomic.Bool))
+} // }}}
+
+// 标记 ctx 中已有写入
+func MarkWritten(ctx context.Context) { // {{{
+	if ctx == nil {
+		return
+	}
+
+	if written, ok := ctx.Value("db_sticky").(*atomic.Bool); ok {
+		written.Store(true)
+	}
+} // }}}
+
+func IsWritten(ctx context.Context) bool { // {{{
+	if ctx == nil {
+		return false
+	}
+
+	written, ok := ctx.Value("db_sticky").(*atomic.Bool)
+	return ok && written.Load()
+} // }}}
+
+// 按 ctx 中指定的超时时间或默认超时时间, 返回执行单条 sql 使用的 ctx
+func withQueryTimeout(ctx context.Context, def time.Duration) (context.Context, context.CancelFunc) { // {{{
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	timeout := def
+	if d, ok := ctx.Value("db_query_timeout").(time.Duration); ok {
+		timeout = d
+	}
+
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, timeout)
+} // }}}
+
+// driver 因 ctx 取消或超时返回的错误, 转换为 *CanceledError
+func ctxError(ctx context.Context, query string, err error) error { // {{{
+	if err == nil || err == sql.ErrNoRows {
+		return err
+	}
+
+	cause := ctx.Err()
+	if cause == nil {
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			cause = context.DeadlineExceeded
+		case errors.Is(err, context.Canceled):
+			cause = context.Canceled
+		default:
+			return err
+		}
+	}
+
+	return &CanceledError{Query: query, Cause: cause, Err: err}
+} // }}}
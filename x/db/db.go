@@ -3,17 +3,163 @@ package db
 import (
 	"context"
 	"database/sql"
+	"github.com/nyxless/nyx/x/log"
+	stdlog "log"
+	"regexp"
 	"strings"
+	"time"
 )
 
 // sql 错误时直接 panic, 由框架错误处理逻辑回收
 // sql.ErrNoRows 认为是正确返回 0 行，不抛错
 var PANIC_DB_ERROR = false
 
+// debug 模式打印 sql 时使用的脱敏规则, 为空时不脱敏
+var SqlRedactor *log.Redactor
+
+// 框架内部的警告日志, 启动时设置为 x.Logger, 为 nil 时输出到标准库 log
+var Logger *log.Logger
+
+func warnf(format string, args ...any) { // {{{
+	if Logger != nil {
+		Logger.Warnf(format, args...)
+		return
+	}
+
+	stdlog.Printf(format, args...)
+} // }}}
+
+// 占位符前的比较表达式, 用于取得参数对应的字段名, 字段名可使用 `col` 或 "col" 引用
+var placeholderColumnRegex = regexp.MustCompile("[`\"]?(\\w+)[`\"]?\\s*(?:=|!=|<>|>=|<=|>|<|(?i:like|in\\s*\\((?:\\s*\\?\\s*,)*))\\s*$")
+
+// 赋值号前的字段名
+var assignColumnRegex = regexp.MustCompile("[`\"]?(\\w+)[`\"]?\\s*$")
+
+// insert 语句的字段列表, VALUES 中的占位符按位置对应字段
+var insertColumnsRegex = regexp.MustCompile(`(?is)^\s*(?:insert|replace)\s+(?:ignore\s+)?into\s+[^\s(]+\s*\(([^)]*)\)\s*values\s*`)
+
+// sql 中各占位符对应的字段名, 无法确定时为空
+// insert 的 VALUES 按字段列表的位置对应; 其他占位符取之前的比较表达式, 或所在的 "字段 = 表达式" 赋值(如 upsert 的 IF(...))
+func placeholderColumns(query string) []string { // {{{
+	var columns []string
+	values_start := -1
+	if m := insertColumnsRegex.FindStringSubmatchIndex(query); m != nil {
+		for _, col := range strings.Split(query[m[2]:m[3]], ",") {
+			columns = append(columns, strings.Trim(strings.TrimSpace(col), "`\""))
+		}
+
+		values_start = m[1]
+	}
+
+	var res []string
+	var quote byte
+	var assign string
+	depth, slot := 0, 0
+	in_values := values_start >= 0
+
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+
+		if quote != 0 {
+			if c == '\\' && quote == '\'' {
+				i++
+			} else if c == quote {
+				if i+1 < len(query) && query[i+1] == quote {
+					i++
+				} else {
+					quote = 0
+				}
+			}
+
+			continue
+		}
+
+		values := in_values && i >= values_start
+
+		switch c {
+		case '\'', '"', '`':
+			quote = c
+		case '(':
+			depth++
+			if values && depth == 1 {
+				slot = 0
+			}
+		case ')':
+			depth--
+		case ',':
+			if values && depth == 1 {
+				slot++
+			} else if depth == 0 {
+				assign = ""
+			}
+		case '=':
+			if depth == 0 && i > 0 && !strings.ContainsRune("<>!", rune(query[i-1])) {
+				if m := assignColumnRegex.FindStringSubmatch(tail(query[:i], 64)); m != nil {
+					assign = m[1]
+				}
+			}
+		case '?':
+			var col string
+			if values && depth > 0 {
+				if slot < len(columns) {
+					col = columns[slot]
+				}
+			} else if m := placeholderColumnRegex.FindStringSubmatch(tail(query[:i], 64)); m != nil {
+				col = m[1]
+			} else {
+				col = assign
+			}
+
+			res = append(res, col)
+		default:
+			if depth == 0 && (c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z') && i > 0 && (query[i-1] == ' ' || query[i-1] == ')') {
+				// VALUES 之后的 ON DUPLICATE KEY UPDATE / ON CONFLICT
+				if values {
+					in_values = false
+				}
+
+				if len(query) >= i+5 && strings.EqualFold(query[i:i+5], "where") {
+					assign = ""
+				}
+			}
+		}
+	}
+
+	return res
+} // }}}
+
+func tail(s string, n int) string { // {{{
+	if len(s) > n {
+		return s[len(s)-n:]
+	}
+
+	return s
+} // }}}
+
+// 按占位符对应的字段名及值规则对 sql 参数脱敏, column 为空时只按值规则脱敏
+func redactSqlArg(r *log.Redactor, column string, arg any) any { //{{{
+	if r.Empty() {
+		return arg
+	}
+
+	if column != "" {
+		if v, ok := r.RedactField(column, arg); ok {
+			return v
+		}
+
+		return "******"
+	}
+
+	return r.Redact(arg)
+} // }}}
+
 type Executor interface {
 	QueryRow(query string, args ...interface{}) *sql.Row
 	Exec(query string, args ...interface{}) (sql.Result, error)
 	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
 }
 
 type DbExecutor struct {
@@ -35,15 +181,21 @@ func errorHandle(err error) error { //{{{
 type DBClient interface {
 	SetDB(dbt string, dbo *sql.DB) error
 	Ping(ctx context.Context) error
+	Stats() sql.DBStats
 	Close()
 	Type() string
+	Dialect() Dialect
 	ID() string
 	SetDebug(open bool)
+	SetTimeout(timeout time.Duration)
+	SetSlowQuery(threshold time.Duration)
+	SetStmtCacheSize(size int)
 	Begin(is_readonly bool) (DBClient, error)
 	Rollback() error
 	Commit() error
 	Insert(table string, vals ...map[string]any) (int, error)
 	Upsert(table string, vals map[string]any, ignore_fields ...string) (int, error)
+	UpsertBatch(table string, vals []map[string]any, conflict []string, update_fields []string) (int, error)
 	Update(table string, vals map[string]any, where string, val ...interface{}) (int, error)
 	Delete(sqlOptions ...FnSqlOption) (int, error)
 	Execute(query string, val ...any) (int, error)
@@ -54,33 +206,72 @@ type DBClient interface {
 	QueryRow(sqlOptions ...FnSqlOption) (map[string]any, error)
 	Query(sqlOptions ...FnSqlOption) ([]map[string]any, error)
 	QueryStream(sqlOptions ...FnSqlOption) (*RowIter, error)
+
+	// 带 context 的版本, ctx 取消或超时时中断 sql 执行, 返回 *CanceledError
+	BeginContext(ctx context.Context, is_readonly bool) (DBClient, error)
+	InsertContext(ctx context.Context, table string, vals ...map[string]any) (int, error)
+	UpsertContext(ctx context.Context, table string, vals map[string]any, ignore_fields ...string) (int, error)
+	UpsertBatchContext(ctx context.Context, table string, vals []map[string]any, conflict []string, update_fields []string) (int, error)
+	UpdateContext(ctx context.Context, table string, vals map[string]any, where string, val ...interface{}) (int, error)
+	DeleteContext(ctx context.Context, sqlOptions ...FnSqlOption) (int, error)
+	ExecuteContext(ctx context.Context, query string, val ...any) (int, error)
+	GetOneContext(ctx context.Context, sqlOptions ...FnSqlOption) (any, error)
+	GetRowContext(ctx context.Context, sqlOptions ...FnSqlOption) (map[string]any, error)
+	GetAllContext(ctx context.Context, sqlOptions ...FnSqlOption) ([]map[string]any, error)
+	QueryOneContext(ctx context.Context, sqlOptions ...FnSqlOption) (any, error)
+	QueryRowContext(ctx context.Context, sqlOptions ...FnSqlOption) (map[string]any, error)
+	QueryContext(ctx context.Context, sqlOptions ...FnSqlOption) ([]map[string]any, error)
+	QueryStreamContext(ctx context.Context, sqlOptions ...FnSqlOption) (*RowIter, error)
 }
 
 type FnSqlOption func(*SqlOption)
 
 type SqlOption struct {
-	table     string
-	fields    string
-	alias     string
-	leftJoin  []string
-	innerJoin []string
-	idx       string
-	group     string
-	order     string
-	limits    string
-	lock      bool
-	where     string
-	sql       string //将忽略以上的配置
-	vals      []any
-	useBytes  bool //独立配置，是否保留[]byte,sql.RawBytes 字段类型，默认转换为 string
+	table      string
+	fields     string
+	alias      string
+	leftJoin   []string
+	innerJoin  []string
+	idx        string
+	group      string
+	having     string
+	havingVals []any
+	union      string //UNION 子句, 如: " UNION ALL SELECT ...", ORDER BY 及 LIMIT 作用于合并后的结果
+	unionVals  []any
+	order      string
+	limits     string
+	lock       bool
+	where      string
+	sql        string //将忽略以上的配置
+	vals       []any
+	useBytes   bool //独立配置，是否保留[]byte,sql.RawBytes 字段类型，默认转换为 string
+	dialect    Dialect
+	countWrap  bool //统计查询结果的总行数: SELECT COUNT(1) FROM (查询) t, 忽略 ORDER BY、LIMIT 及锁
 }
 
+func (so *SqlOption) getDialect() Dialect { //{{{
+	if so.dialect == nil {
+		return MySQL
+	}
+
+	return so.dialect
+} // }}}
+
 func (so *SqlOption) ToSql() (string, []any) { //{{{
 	if so.sql != "" {
 		return so.sql, so.vals
 	}
 
+	if so.countWrap {
+		inner := *so
+		inner.countWrap, inner.order, inner.limits, inner.lock = false, "", "", false
+		sqlstr, vals := inner.ToSql()
+
+		return "SELECT COUNT(1) AS total FROM (" + sqlstr + ") t", vals
+	}
+
 	var sb strings.Builder
+	dialect := so.getDialect()
 
 	sb.WriteString("SELECT ")
 
@@ -103,9 +294,7 @@ func (so *SqlOption) ToSql() (string, []any) { //{{{
 	}
 
 	if so.idx != "" {
-		sb.WriteString(" FORCE INDEX (")
-		sb.WriteString(so.idx)
-		sb.WriteString(")")
+		sb.WriteString(dialect.IndexHint(so.idx))
 	}
 
 	if len(so.leftJoin) > 0 {
@@ -136,8 +325,17 @@ func (so *SqlOption) ToSql() (string, []any) { //{{{
 		sb.WriteString(so.group)
 	}
 
+	if so.having != "" {
+		sb.WriteString(" HAVING ")
+		sb.WriteString(so.having)
+	}
+
+	if so.union != "" {
+		sb.WriteString(so.union)
+	}
+
 	if so.order != "" {
-		if so.alias != "" {
+		if so.alias != "" && so.union == "" {
 			so.order = FillAlias(so.alias, so.order)
 		}
 
@@ -146,15 +344,14 @@ func (so *SqlOption) ToSql() (string, []any) { //{{{
 	}
 
 	if so.limits != "" {
-		sb.WriteString(" LIMIT ")
-		sb.WriteString(so.limits)
+		sb.WriteString(dialect.Limit(so.limits))
 	}
 
 	if so.lock {
-		sb.WriteString(" FOR UPDATE")
+		sb.WriteString(dialect.Lock())
 	}
 
-	return sb.String(), so.vals
+	return sb.String(), so.GetAllVals()
 } // }}}
 
 func (so *SqlOption) GetTable() string { // {{{
@@ -185,6 +382,14 @@ func (so *SqlOption) GetGroup() string { // {{{
 	return so.group
 } // }}}
 
+func (so *SqlOption) GetHaving() string { // {{{
+	return so.having
+} // }}}
+
+func (so *SqlOption) GetUnion() string { // {{{
+	return so.union
+} // }}}
+
 func (so *SqlOption) GetOrder() string { // {{{
 	return so.order
 } // }}}
@@ -209,10 +414,35 @@ func (so *SqlOption) GetVals() []any { // {{{
 	return so.vals
 } // }}}
 
+func (so *SqlOption) GetHavingVals() []any { // {{{
+	return so.havingVals
+} // }}}
+
+func (so *SqlOption) GetUnionVals() []any { // {{{
+	return so.unionVals
+} // }}}
+
+// 按占位符在 sql 中的顺序排列的全部参数: where, having, union
+func (so *SqlOption) GetAllVals() []any { // {{{
+	if len(so.havingVals) == 0 && len(so.unionVals) == 0 {
+		return so.vals
+	}
+
+	vals := make([]any, 0, len(so.vals)+len(so.havingVals)+len(so.unionVals))
+	vals = append(vals, so.vals...)
+	vals = append(vals, so.havingVals...)
+
+	return append(vals, so.unionVals...)
+} // }}}
+
 func (so *SqlOption) GetUseBytes() bool { // {{{
 	return so.useBytes
 } // }}}
 
+func (so *SqlOption) GetDialect() Dialect { // {{{
+	return so.getDialect()
+} // }}}
+
 func WithTable(table string) FnSqlOption { // {{{
 	return func(s *SqlOption) {
 		s.table = table
@@ -255,6 +485,27 @@ func WithGroup(g string) FnSqlOption { // {{{
 	}
 } // }}}
 
+func WithHaving(having string, vals []any) FnSqlOption { // {{{
+	return func(s *SqlOption) {
+		s.having = having
+		s.havingVals = vals
+	}
+} // }}}
+
+func WithUnion(union string, vals []any) FnSqlOption { // {{{
+	return func(s *SqlOption) {
+		s.union = union
+		s.unionVals = vals
+	}
+} // }}}
+
+// 统计查询结果的总行数, 用于含 GROUP BY、HAVING 或 UNION 的查询
+func WithCountWrap(b bool) FnSqlOption { // {{{
+	return func(s *SqlOption) {
+		s.countWrap = b
+	}
+} // }}}
+
 func WithOrder(o string) FnSqlOption { // {{{
 	return func(s *SqlOption) {
 		s.order = o
@@ -287,6 +538,12 @@ func WithSql(str string, vals []any) FnSqlOption { // {{{
 	}
 } // }}}
 
+func WithDialect(d Dialect) FnSqlOption { // {{{
+	return func(s *SqlOption) {
+		s.dialect = d
+	}
+} // }}}
+
 func WithBytes(b bool) FnSqlOption { // {{{
 	return func(s *SqlOption) {
 		s.useBytes = b
@@ -0,0 +1,428 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// sql 方言, 负责生成 sql 时与数据库类型相关的部分
+// 框架内部及 Dao 中统一使用 ? 占位符, 执行前由 Rebind 转换为方言的占位符
+type Dialect interface {
+	Name() string
+
+	// 标识符引用, 如 mysql: `name`, postgres: "name"
+	Quote(ident string) string
+
+	// 将 ? 占位符转换为方言占位符, 如 postgres: $1, $2 ...
+	Rebind(query string) string
+
+	// 分页子句, limits 格式同 Dao.Limit: "10" 或 "20,10"(offset,limit)
+	Limit(limits string) string
+
+	// 悲观锁子句, 不支持时返回空
+	Lock() string
+
+	// 指定索引子句, 不支持时返回空
+	IndexHint(idx string) string
+
+	// upsert 冲突更新子句前缀, conflict 为冲突检测字段, 之后拼接 "字段 = 值" 列表
+	Upsert(conflict []string) (string, error)
+
+	// 批量 upsert 的更新子句中引用待插入的值, 如 mysql: VALUES(`col`), postgres: EXCLUDED."col"
+	UpsertValue(col string) string
+
+	// upsert 冲突更新的 "字段 = 值" 列表, cols 与 values 一一对应
+	// guard 不为空时只更新已有记录中 guard 字段与待插入的值相同的记录, 如 mysql: IF(...), postgres: WHERE ...; 不支持时返回错误
+	UpsertUpdate(table, guard string, cols, values []string) (string, error)
+
+	// insert 语句返回自增主键的子句, 返回空时使用 LastInsertId
+	Returning(primary string) string
+
+	// 带 ORDER BY/LIMIT 的 delete 语句
+	Delete(table, where, order, limits string) string
+
+	// 查询计划语句
+	Explain(query string) string
+
+	// 查询计划结果中需要展示的字段, 按顺序
+	ExplainFields(result []map[string]any) []string
+}
+
+var (
+	dialects = map[string]Dialect{
+		"mysql":      MySQL,
+		"postgres":   PostgreSQL,
+		"postgresql": PostgreSQL,
+		"pgx":        PostgreSQL,
+		"sqlite":     SQLite,
+		"sqlite3":    SQLite,
+	}
+	dialectMu sync.RWMutex
+)
+
+// 注册方言, name 与 DB 配置中的 type 对应
+func RegisterDialect(name string, d Dialect) { // {{{
+	dialectMu.Lock()
+	defer dialectMu.Unlock()
+
+	dialects[strings.ToLower(name)] = d
+} // }}}
+
+// 按 DB 配置中的 type 获取方言, 未注册时使用 MySQL
+func GetDialect(name string) Dialect { // {{{
+	dialectMu.RLock()
+	defer dialectMu.RUnlock()
+
+	if d, ok := dialects[strings.ToLower(name)]; ok {
+		return d
+	}
+
+	return MySQL
+} // }}}
+
+// "20,10" => offset 20, limit 10
+func splitLimits(limits string) (string, string) { // {{{
+	if offset, limit, ok := strings.Cut(limits, ","); ok {
+		return strings.TrimSpace(offset), strings.TrimSpace(limit)
+	}
+
+	return "", strings.TrimSpace(limits)
+} // }}}
+
+// 跳过字符串常量及引用标识符, 将 ? 依次替换为 fn(n) 的返回值
+func rebindQuery(query string, fn func(n int) string) string { // {{{
+	if !strings.Contains(query, "?") {
+		return query
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(query) + 16)
+
+	n := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+
+		if quote != 0 {
+			sb.WriteByte(c)
+			if c == quote {
+				quote = 0
+			}
+			continue
+		}
+
+		switch c {
+		case '\'', '"', '`':
+			quote = c
+			sb.WriteByte(c)
+		case '?':
+			n++
+			sb.WriteString(fn(n))
+		default:
+			sb.WriteByte(c)
+		}
+	}
+
+	return sb.String()
+} // }}}
+
+// MySQL 方言
+var MySQL Dialect = &mysqlDialect{}
+
+type mysqlDialect struct{}
+
+func (m *mysqlDialect) Name() string { // {{{
+	return "mysql"
+} // }}}
+
+func (m *mysqlDialect) Quote(ident string) string { // {{{
+	return "`" + strings.ReplaceAll(ident, "`", "``") + "`"
+} // }}}
+
+func (m *mysqlDialect) Rebind(query string) string { // {{{
+	return query
+} // }}}
+
+func (m *mysqlDialect) Limit(limits string) string { // {{{
+	return " LIMIT " + limits
+} // }}}
+
+func (m *mysqlDialect) Lock() string { // {{{
+	return " FOR UPDATE"
+} // }}}
+
+func (m *mysqlDialect) IndexHint(idx string) string { // {{{
+	return " FORCE INDEX (" + idx + ")"
+} // }}}
+
+func (m *mysqlDialect) Upsert(conflict []string) (string, error) { // {{{
+	return " ON DUPLICATE KEY UPDATE ", nil
+} // }}}
+
+func (m *mysqlDialect) UpsertValue(col string) string { // {{{
+	return "VALUES(" + m.Quote(col) + ")"
+} // }}}
+
+func (m *mysqlDialect) UpsertUpdate(table, guard string, cols, values []string) (string, error) { // {{{
+	return ifGuard(m, table, guard, cols, values), nil
+} // }}}
+
+func (m *mysqlDialect) Returning(primary string) string { // {{{
+	return ""
+} // }}}
+
+func (m *mysqlDialect) Delete(table, where, order, limits string) string { // {{{
+	var sb strings.Builder
+
+	sb.WriteString("DELETE FROM ")
+	sb.WriteString(table)
+
+	if where != "" {
+		sb.WriteString(" WHERE ")
+		sb.WriteString(where)
+	}
+
+	if order != "" {
+		sb.WriteString(" ORDER BY ")
+		sb.WriteString(order)
+	}
+
+	if limits != "" {
+		sb.WriteString(" LIMIT ")
+		sb.WriteString(limits)
+	}
+
+	return sb.String()
+} // }}}
+
+func (m *mysqlDialect) Explain(query string) string { // {{{
+	return "EXPLAIN " + query
+} // }}}
+
+func (m *mysqlDialect) ExplainFields(result []map[string]any) []string { // {{{
+	return def_fields
+} // }}}
+
+// PostgreSQL 方言
+var PostgreSQL Dialect = &postgresDialect{}
+
+type postgresDialect struct{}
+
+func (p *postgresDialect) Name() string { // {{{
+	return "postgres"
+} // }}}
+
+func (p *postgresDialect) Quote(ident string) string { // {{{
+	return `"` + strings.ReplaceAll(ident, `"`, `""`) + `"`
+} // }}}
+
+func (p *postgresDialect) Rebind(query string) string { // {{{
+	return rebindQuery(query, func(n int) string {
+		return fmt.Sprintf("$%d", n)
+	})
+} // }}}
+
+func (p *postgresDialect) Limit(limits string) string { // {{{
+	return limitOffset(limits)
+} // }}}
+
+func (p *postgresDialect) Lock() string { // {{{
+	return " FOR UPDATE"
+} // }}}
+
+func (p *postgresDialect) IndexHint(idx string) string { // {{{
+	return ""
+} // }}}
+
+func (p *postgresDialect) Upsert(conflict []string) (string, error) { // {{{
+	return onConflict(p, conflict)
+} // }}}
+
+func (p *postgresDialect) UpsertValue(col string) string { // {{{
+	return "EXCLUDED." + p.Quote(col)
+} // }}}
+
+func (p *postgresDialect) UpsertUpdate(table, guard string, cols, values []string) (string, error) { // {{{
+	return whereGuard(p, table, guard, cols, values), nil
+} // }}}
+
+func (p *postgresDialect) Returning(primary string) string { // {{{
+	if primary == "" {
+		return ""
+	}
+
+	return " RETURNING " + p.Quote(primary)
+} // }}}
+
+// postgres 不支持 DELETE ... LIMIT, 使用 ctid 子查询
+func (p *postgresDialect) Delete(table, where, order, limits string) string { // {{{
+	return deleteByRowID("ctid", table, where, order, limits, limitOffset)
+} // }}}
+
+func (p *postgresDialect) Explain(query string) string { // {{{
+	return "EXPLAIN " + query
+} // }}}
+
+func (p *postgresDialect) ExplainFields(result []map[string]any) []string { // {{{
+	return []string{"QUERY PLAN"}
+} // }}}
+
+// SQLite 方言
+var SQLite Dialect = &sqliteDialect{}
+
+type sqliteDialect struct{}
+
+func (s *sqliteDialect) Name() string { // {{{
+	return "sqlite"
+} // }}}
+
+func (s *sqliteDialect) Quote(ident string) string { // {{{
+	return `"` + strings.ReplaceAll(ident, `"`, `""`) + `"`
+} // }}}
+
+func (s *sqliteDialect) Rebind(query string) string { // {{{
+	return query
+} // }}}
+
+func (s *sqliteDialect) Limit(limits string) string { // {{{
+	return limitOffset(limits)
+} // }}}
+
+// sqlite 为库级锁, 不支持 FOR UPDATE
+func (s *sqliteDialect) Lock() string { // {{{
+	return ""
+} // }}}
+
+func (s *sqliteDialect) IndexHint(idx string) string { // {{{
+	if strings.Contains(idx, ",") {
+		return ""
+	}
+
+	return " INDEXED BY " + strings.TrimSpace(idx)
+} // }}}
+
+func (s *sqliteDialect) Upsert(conflict []string) (string, error) { // {{{
+	return onConflict(s, conflict)
+} // }}}
+
+func (s *sqliteDialect) UpsertValue(col string) string { // {{{
+	return "EXCLUDED." + s.Quote(col)
+} // }}}
+
+func (s *sqliteDialect) UpsertUpdate(table, guard string, cols, values []string) (string, error) { // {{{
+	return whereGuard(s, table, guard, cols, values), nil
+} // }}}
+
+func (s *sqliteDialect) Returning(primary string) string { // {{{
+	return ""
+} // }}}
+
+// sqlite 默认未开启 DELETE ... LIMIT, 使用 rowid 子查询
+func (s *sqliteDialect) Delete(table, where, order, limits string) string { // {{{
+	return deleteByRowID("rowid", table, where, order, limits, limitOffset)
+} // }}}
+
+func (s *sqliteDialect) Explain(query string) string { // {{{
+	return "EXPLAIN QUERY PLAN " + query
+} // }}}
+
+func (s *sqliteDialect) ExplainFields(result []map[string]any) []string { // {{{
+	return []string{"id", "parent", "detail"}
+} // }}}
+
+// LIMIT n OFFSET m
+func limitOffset(limits string) string { // {{{
+	offset, limit := splitLimits(limits)
+	if offset == "" {
+		return " LIMIT " + limit
+	}
+
+	return " LIMIT " + limit + " OFFSET " + offset
+} // }}}
+
+// ON CONFLICT (...) DO UPDATE SET
+// mysql 的 ON DUPLICATE KEY UPDATE 不支持条件, 逐个字段使用 IF 保留原值
+func ifGuard(d Dialect, table, guard string, cols, values []string) string { // {{{
+	parts := make([]string, len(cols))
+	for i, col := range cols {
+		if guard == "" {
+			parts[i] = d.Quote(col) + " = " + values[i]
+		} else {
+			parts[i] = d.Quote(col) + " = IF(" + d.Quote(guard) + " = " + d.UpsertValue(guard) + ", " + values[i] + ", " + d.Quote(col) + ")"
+		}
+	}
+
+	return strings.Join(parts, ", ")
+} // }}}
+
+// ON CONFLICT ... DO UPDATE 追加 WHERE 条件, 已有记录以表名(不含 schema)引用
+func whereGuard(d Dialect, table, guard string, cols, values []string) string { // {{{
+	parts := make([]string, len(cols))
+	for i, col := range cols {
+		parts[i] = d.Quote(col) + " = " + values[i]
+	}
+
+	set := strings.Join(parts, ", ")
+	if guard == "" {
+		return set
+	}
+
+	table = table[strings.LastIndex(table, ".")+1:]
+
+	return set + " WHERE " + table + "." + d.Quote(guard) + " = " + d.UpsertValue(guard)
+} // }}}
+
+func onConflict(d Dialect, conflict []string) (string, error) { // {{{
+	if len(conflict) == 0 {
+		return "", fmt.Errorf("%s upsert requires conflict columns", d.Name())
+	}
+
+	cols := make([]string, len(conflict))
+	for i, col := range conflict {
+		cols[i] = d.Quote(col)
+	}
+
+	return " ON CONFLICT (" + strings.Join(cols, ", ") + ") DO UPDATE SET ", nil
+} // }}}
+
+func deleteByRowID(rowid, table, where, order, limits string, limit_fn func(string) string) string { // {{{
+	var sb strings.Builder
+
+	sb.WriteString("DELETE FROM ")
+	sb.WriteString(table)
+
+	if order == "" && limits == "" {
+		if where != "" {
+			sb.WriteString(" WHERE ")
+			sb.WriteString(where)
+		}
+
+		return sb.String()
+	}
+
+	sb.WriteString(" WHERE ")
+	sb.WriteString(rowid)
+	sb.WriteString(" IN (SELECT ")
+	sb.WriteString(rowid)
+	sb.WriteString(" FROM ")
+	sb.WriteString(table)
+
+	if where != "" {
+		sb.WriteString(" WHERE ")
+		sb.WriteString(where)
+	}
+
+	if order != "" {
+		sb.WriteString(" ORDER BY ")
+		sb.WriteString(order)
+	}
+
+	if limits != "" {
+		sb.WriteString(limit_fn(limits))
+	}
+
+	sb.WriteString(")")
+
+	return sb.String()
+} // }}}
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"testing"
+)
+
+func TestDialectQuote(t *testing.T) {
+	cases := []struct {
+		d    Dialect
+		in   string
+		want string
+	}{
+		{MySQL, "order", "`order`"},
+		{MySQL, "a`b", "`a``b`"},
+		{PostgreSQL, "order", `"order"`},
+		{PostgreSQL, `a"b`, `"a""b"`},
+		{SQLite, "group", `"group"`},
+	}
+
+	for _, c := range cases {
+		if got := c.d.Quote(c.in); got != c.want {
+			t.Errorf("%s Quote(%q) = %q, want %q", c.d.Name(), c.in, got, c.want)
+		}
+	}
+}
+
+func TestDialectRebind(t *testing.T) {
+	query := "SELECT * FROM t WHERE a = ? AND b = '?' AND \"c?\" = ? AND d IN (?, ?)"
+
+	want := "SELECT * FROM t WHERE a = $1 AND b = '?' AND \"c?\" = $2 AND d IN ($3, $4)"
+	if got := PostgreSQL.Rebind(query); got != want {
+		t.Errorf("postgres Rebind = %q, want %q", got, want)
+	}
+
+	if got := MySQL.Rebind(query); got != query {
+		t.Errorf("mysql Rebind = %q, want unchanged", got)
+	}
+
+	if got := SQLite.Rebind(query); got != query {
+		t.Errorf("sqlite Rebind = %q, want unchanged", got)
+	}
+}
+
+func TestDialectLimit(t *testing.T) {
+	cases := []struct {
+		d      Dialect
+		limits string
+		want   string
+	}{
+		{MySQL, "10", " LIMIT 10"},
+		{MySQL, "20,10", " LIMIT 20,10"},
+		{PostgreSQL, "10", " LIMIT 10"},
+		{PostgreSQL, "20, 10", " LIMIT 10 OFFSET 20"},
+		{SQLite, "20,10", " LIMIT 10 OFFSET 20"},
+	}
+
+	for _, c := range cases {
+		if got := c.d.Limit(c.limits); got != c.want {
+			t.Errorf("%s Limit(%q) = %q, want %q", c.d.Name(), c.limits, got, c.want)
+		}
+	}
+}
+
+func TestDialectUpsert(t *testing.T) {
+	if got, _ := MySQL.Upsert(nil); got != " ON DUPLICATE KEY UPDATE " {
+		t.Errorf("mysql Upsert = %q", got)
+	}
+
+	if got := MySQL.UpsertValue("name"); got != "VALUES(`name`)" {
+		t.Errorf("mysql UpsertValue = %q", got)
+	}
+
+	for _, d := range []Dialect{PostgreSQL, SQLite} {
+		got, err := d.Upsert([]string{"id"})
+		if err != nil || got != ` ON CONFLICT ("id") DO UPDATE SET ` {
+			t.Errorf("%s Upsert = %q, %v", d.Name(), got, err)
+		}
+
+		if _, err := d.Upsert(nil); err == nil {
+			t.Errorf("%s Upsert without conflict columns should fail", d.Name())
+		}
+
+		if got := d.UpsertValue("name"); got != `EXCLUDED."name"` {
+			t.Errorf("%s UpsertValue = %q", d.Name(), got)
+		}
+	}
+}
+
+func TestDialectReturning(t *testing.T) {
+	if got := PostgreSQL.Returning("id"); got != ` RETURNING "id"` {
+		t.Errorf("postgres Returning = %q", got)
+	}
+
+	if got := PostgreSQL.Returning(""); got != "" {
+		t.Errorf("postgres Returning without primary = %q", got)
+	}
+
+	// 使用 LastInsertId
+	if got := MySQL.Returning("id"); got != "" {
+		t.Errorf("mysql Returning = %q", got)
+	}
+
+	if got := SQLite.Returning("id"); got != "" {
+		t.Errorf("sqlite Returning = %q", got)
+	}
+}
+
+func TestExprValue(t *testing.T) {
+	if ph, args, err := ExprValue(Raw("cnt + ?", 1)); err != nil || ph != "cnt + ?" || len(args) != 1 {
+		t.Errorf("ExprValue(Raw) = %q, %v, %v", ph, args, err)
+	}
+
+	if ph, args, err := ExprValue("NOW()"); err != nil || ph != "NOW()" || args != nil {
+		t.Errorf("ExprValue(string) = %q, %v, %v", ph, args, err)
+	}
+
+	if ph, _, err := ExprValue(1); err != nil || ph != "1" {
+		t.Errorf("ExprValue(int) = %q, %v", ph, err)
+	}
+
+	if _, _, err := ExprValue([]byte("1")); err == nil {
+		t.Errorf("ExprValue([]byte) should fail")
+	}
+}
+
+func TestDialectUpsertGuard(t *testing.T) {
+	cols, vals := []string{"name"}, []string{"?"}
+
+	got, err := MySQL.UpsertUpdate("s.t", "tenant_id", cols, vals)
+	if want := "`name` = IF(`tenant_id` = VALUES(`tenant_id`), ?, `name`)"; err != nil || got != want {
+		t.Errorf("mysql UpsertUpdate = %q, %v", got, err)
+	}
+
+	for _, d := range []Dialect{PostgreSQL, SQLite} {
+		got, err := d.UpsertUpdate("s.t", "tenant_id", cols, vals)
+		if want := `"name" = ? WHERE t."tenant_id" = EXCLUDED."tenant_id"`; err != nil || got != want {
+			t.Errorf("%s UpsertUpdate = %q, %v", d.Name(), got, err)
+		}
+
+		if got, _ := d.UpsertUpdate("t", "", cols, vals); got != `"name" = ?` {
+			t.Errorf("%s UpsertUpdate without guard = %q", d.Name(), got)
+		}
+	}
+}
@@ -0,0 +1,450 @@
+package db
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 流式导出查询结果, 逐行写入 io.Writer, 不在内存中保存全部记录
+// 用法:
+//
+//	iter, err := NewDAOUser().SetFields("uid, name, created_at").QueryStream(...)
+//	n, err := iter.ToCSV(w, db.WithExportBOM(), db.WithExportHeader(map[string]string{"uid": "用户ID"}))
+//	n, err := iter.ToXLSX(w, db.WithExportFormatter("created_at", func(v any) any { return ... }))
+//
+// 列的顺序默认与查询的字段顺序一致, 可通过 WithExportColumns 指定(同时只导出这些列)
+// csv 及 xlsx 中以 = + - @ 开头的文本(数字除外)前加 ' , 避免被 Excel 作为公式执行, 可通过 WithoutExportFormulaEscape 关闭
+// 导出完成或出错后自动关闭迭代器
+const (
+	ExportCSV   = "csv"
+	ExportJSONL = "jsonl"
+	ExportXLSX  = "xlsx"
+)
+
+// 各导出格式的 Content-Type
+var ExportContentTypes = map[string]string{
+	ExportCSV:   "text/csv; charset=utf-8",
+	ExportJSONL: "application/x-ndjson; charset=utf-8",
+	ExportXLSX:  "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// 列值的格式化函数, 返回值按原样导出(如 xlsx 中数字为数值单元格)
+type ExportFormatter func(val any) any
+
+type exportOption struct {
+	columns    []string
+	headers    map[string]string
+	formatters map[string]ExportFormatter
+	bom        bool
+	noHeader   bool
+	noEscape   bool
+	sheet      string
+	timeLayout string
+}
+
+type ExportOption func(*exportOption)
+
+// 导出的列及顺序, 默认为查询的全部字段
+func WithExportColumns(columns ...string) ExportOption { // {{{
+	return func(o *exportOption) {
+		o.columns = columns
+	}
+} // }}}
+
+// 表头显示名称, 未指定的列使用字段名; jsonl 中作为 key
+func WithExportHeader(headers map[string]string) ExportOption { // {{{
+	return func(o *exportOption) {
+		o.headers = headers
+	}
+} // }}}
+
+// 指定列的格式化函数
+func WithExportFormatter(column string, fn ExportFormatter) ExportOption { // {{{
+	return func(o *exportOption) {
+		o.formatters[column] = fn
+	}
+} // }}}
+
+// csv 写入 UTF-8 BOM, 使 Excel 正确识别中文
+func WithExportBOM() ExportOption { // {{{
+	return func(o *exportOption) {
+		o.bom = true
+	}
+} // }}}
+
+// csv/xlsx 不输出表头
+func WithoutExportHeader() ExportOption { // {{{
+	return func(o *exportOption) {
+		o.noHeader = true
+	}
+} // }}}
+
+// csv/xlsx 不转义以 = + - @ 开头的文本, 用于导出的内容确定可信或需要保留公式时
+func WithoutExportFormulaEscape() ExportOption { // {{{
+	return func(o *exportOption) {
+		o.noEscape = true
+	}
+} // }}}
+
+// xlsx 的工作表名称, 默认 Sheet1
+func WithExportSheet(name string) ExportOption { // {{{
+	return func(o *exportOption) {
+		o.sheet = name
+	}
+} // }}}
+
+// time.Time 类型的格式, 默认 "2006-01-02 15:04:05"
+func WithExportTimeLayout(layout string) ExportOption { // {{{
+	return func(o *exportOption) {
+		o.timeLayout = layout
+	}
+} // }}}
+
+// 查询的字段名, 按查询顺序
+func (it *RowIter) Columns() []string { // {{{
+	return it.cols
+} // }}}
+
+// 按格式导出, format: ExportCSV | ExportJSONL | ExportXLSX; 返回导出的行数
+func (it *RowIter) Export(w io.Writer, format string, opts ...ExportOption) (int, error) { // {{{
+	switch format {
+	case ExportCSV:
+		return it.ToCSV(w, opts...)
+	case ExportJSONL:
+		return it.ToJSONL(w, opts...)
+	case ExportXLSX:
+		return it.ToXLSX(w, opts...)
+	}
+
+	it.Close()
+	return 0, fmt.Errorf("unsupported export format: %s", format)
+} // }}}
+
+// 导出为 csv
+func (it *RowIter) ToCSV(w io.Writer, opts ...ExportOption) (int, error) { // {{{
+	o := it.exportOption(opts)
+	bw := bufio.NewWriter(w)
+
+	if o.bom {
+		bw.WriteString("\xEF\xBB\xBF")
+	}
+
+	cw := csv.NewWriter(bw)
+	if !o.noHeader {
+		cw.Write(o.headerNames())
+	}
+
+	num := 0
+	record := make([]string, len(o.columns))
+	err := it.Foreach(func(row map[string]any) error {
+		for i, col := range o.columns {
+			record[i] = o.cellText(o.value(row, col))
+		}
+
+		num++
+		return cw.Write(record)
+	})
+
+	if err != nil {
+		return num, err
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return num, err
+	}
+
+	return num, bw.Flush()
+} // }}}
+
+// 导出为 json lines, 每行一个 json 对象, key 的顺序与列的顺序一致
+func (it *RowIter) ToJSONL(w io.Writer, opts ...ExportOption) (int, error) { // {{{
+	o := it.exportOption(opts)
+	bw := bufio.NewWriter(w)
+
+	// 不转义 html 字符; Encode 在每个值之后追加的换行需要去掉
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+
+	keys := make([][]byte, len(o.columns))
+	for i, name := range o.headerNames() {
+		buf.Reset()
+		enc.Encode(name)
+		keys[i] = bytes.Clone(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+	}
+
+	num := 0
+	err := it.Foreach(func(row map[string]any) error {
+		bw.WriteByte('{')
+		for i, col := range o.columns {
+			if i > 0 {
+				bw.WriteByte(',')
+			}
+
+			val := o.value(row, col)
+			if t, ok := val.(time.Time); ok {
+				val = t.Format(o.timeLayout)
+			}
+
+			buf.Reset()
+			if err := enc.Encode(val); err != nil {
+				return err
+			}
+
+			bw.Write(keys[i])
+			bw.WriteByte(':')
+			bw.Write(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+		}
+
+		num++
+		_, err := bw.WriteString("}\n")
+		return err
+	})
+
+	if err != nil {
+		return num, err
+	}
+
+	return num, bw.Flush()
+} // }}}
+
+// 导出为 xlsx(Office Open XML), 单元格使用内联字符串, 逐行写入 zip 流
+// 数字及布尔值写为对应类型的单元格, 其他值及超出 ±2^53 的整数写为文本
+func (it *RowIter) ToXLSX(w io.Writer, opts ...ExportOption) (int, error) { // {{{
+	o := it.exportOption(opts)
+	zw := zip.NewWriter(w)
+
+	for _, f := range [][2]string{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/workbook.xml", fmt.Sprintf(xlsxWorkbook, xmlEscape(o.sheet))},
+	} {
+		fw, err := zw.Create(f[0])
+		if err != nil {
+			it.Close()
+			return 0, err
+		}
+
+		if _, err := io.WriteString(fw, f[1]); err != nil {
+			it.Close()
+			return 0, err
+		}
+	}
+
+	fw, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		it.Close()
+		return 0, err
+	}
+
+	bw := bufio.NewWriter(fw)
+	bw.WriteString(xml.Header)
+	bw.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	if !o.noHeader {
+		names := o.headerNames()
+		cells := make([]any, len(names))
+		for i, name := range names {
+			cells[i] = name
+		}
+
+		o.xlsxRow(bw, cells)
+	}
+
+	num := 0
+	cells := make([]any, len(o.columns))
+	err = it.Foreach(func(row map[string]any) error {
+		for i, col := range o.columns {
+			cells[i] = o.value(row, col)
+		}
+
+		num++
+		return o.xlsxRow(bw, cells)
+	})
+
+	if err != nil {
+		return num, err
+	}
+
+	bw.WriteString(`</sheetData></worksheet>`)
+	if err := bw.Flush(); err != nil {
+		return num, err
+	}
+
+	return num, zw.Close()
+} // }}}
+
+func (it *RowIter) exportOption(opts []ExportOption) *exportOption { // {{{
+	o := &exportOption{
+		formatters: map[string]ExportFormatter{},
+		sheet:      "Sheet1",
+		timeLayout: "2006-01-02 15:04:05",
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if len(o.columns) == 0 {
+		o.columns = it.cols
+	}
+
+	return o
+} // }}}
+
+func (o *exportOption) headerNames() []string { // {{{
+	names := make([]string, len(o.columns))
+	for i, col := range o.columns {
+		if name, ok := o.headers[col]; ok {
+			names[i] = name
+		} else {
+			names[i] = col
+		}
+	}
+
+	return names
+} // }}}
+
+// 列值, 有格式化函数时使用格式化后的值
+func (o *exportOption) value(row map[string]any, col string) any { // {{{
+	val := row[col]
+	if fn, ok := o.formatters[col]; ok {
+		return fn(val)
+	}
+
+	return val
+} // }}}
+
+// 列值的文本形式
+func (o *exportOption) text(val any) string { // {{{
+	switch v := val.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(o.timeLayout)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	return fmt.Sprint(val)
+} // }}}
+
+// csv/xlsx 中文本单元格的内容, 防止公式注入
+func (o *exportOption) cellText(val any) string { // {{{
+	text := o.text(val)
+
+	switch val.(type) {
+	case string, []byte:
+		if !o.noEscape && isFormula(text) {
+			return "'" + text
+		}
+	}
+
+	return text
+} // }}}
+
+// 以 = + - @ 开头且不是数字的文本会被 Excel 作为公式
+func isFormula(text string) bool { // {{{
+	if text == "" || !strings.ContainsRune("=+-@", rune(text[0])) {
+		return false
+	}
+
+	_, err := strconv.ParseFloat(text, 64)
+	return err != nil
+} // }}}
+
+// Excel 的数值为 float64, 超出 ±2^53 的整数会丢失精度
+func exactNumber(val any) bool { // {{{
+	const limit = 1 << 53
+
+	switch v := val.(type) {
+	case int:
+		return v >= -limit && v <= limit
+	case int64:
+		return v >= -limit && v <= limit
+	case uint:
+		return v <= limit
+	case uint64:
+		return v <= limit
+	}
+
+	return true
+} // }}}
+
+func (o *exportOption) xlsxRow(bw *bufio.Writer, cells []any) error { // {{{
+	bw.WriteString("<row>")
+	for _, val := range cells {
+		switch v := val.(type) {
+		case nil:
+			bw.WriteString("<c/>")
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+			if !exactNumber(v) {
+				bw.WriteString(`<c t="inlineStr"><is><t>`)
+				bw.WriteString(o.text(v))
+				bw.WriteString("</t></is></c>")
+				break
+			}
+
+			bw.WriteString("<c><v>")
+			bw.WriteString(o.text(v))
+			bw.WriteString("</v></c>")
+		case bool:
+			if v {
+				bw.WriteString(`<c t="b"><v>1</v></c>`)
+			} else {
+				bw.WriteString(`<c t="b"><v>0</v></c>`)
+			}
+		default:
+			bw.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+			bw.WriteString(xmlEscape(o.cellText(v)))
+			bw.WriteString("</t></is></c>")
+		}
+	}
+
+	_, err := bw.WriteString("</row>")
+	return err
+} // }}}
+
+func xmlEscape(s string) string { // {{{
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(s))
+	return sb.String()
+} // }}}
+
+const (
+	xlsxContentTypes = xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`
+
+	xlsxRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`
+
+	xlsxWorkbookRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`
+
+	xlsxWorkbook = xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`
+)
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestExportFormulaEscape(t *testing.T) {
+	o := &exportOption{}
+	cases := []struct {
+		val  any
+		want string
+	}{
+		{"=1+2", "'=1+2"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"-2+3", "'-2+3"},
+		{"-5", "-5"},
+		{"+1.5", "+1.5"},
+		{"a=b", "a=b"},
+		{[]byte("=cmd"), "'=cmd"},
+		{-5, "-5"},
+	}
+
+	for _, c := range cases {
+		if got := o.cellText(c.val); got != c.want {
+			t.Errorf("cellText(%v) = %q, want %q", c.val, got, c.want)
+		}
+	}
+
+	o.noEscape = true
+	if got := o.cellText("=1+2"); got != "=1+2" {
+		t.Errorf("cellText without escape = %q", got)
+	}
+}
+
+func TestExportXLSXLargeInt(t *testing.T) {
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+
+	o := &exportOption{}
+	o.xlsxRow(bw, []any{int64(1)<<60 + 1, 42, uint64(1) << 63})
+	bw.Flush()
+
+	row := buf.String()
+	for _, want := range []string{`<c t="inlineStr"><is><t>1152921504606846977</t></is></c>`, "<c><v>42</v></c>", `<t>9223372036854775808</t>`} {
+		if !strings.Contains(row, want) {
+			t.Errorf("xlsxRow = %s, want %s", row, want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"fmt"
+	"sync"
+)
+
+// 原生 sql 片段, 可带 ? 参数, 参数按片段在 sql 中出现的位置依次绑定
+// 用于查询条件及写入的字段值, 代替 :expr 的字符串拼接:
+//
+//	dao.Where(map[string]any{"score:gt": db.Raw("avg_score * ?", 1.5)})
+//	dao.SetRecord(map[string]any{"views": db.Raw("views + ?", 1)}, id)
+type RawExpr struct {
+	sql  string
+	args []any
+}
+
+func Raw(sql string, args ...any) *RawExpr { // {{{
+	return &RawExpr{sql: sql, args: args}
+} // }}}
+
+func (r *RawExpr) Sql() string { // {{{
+	return r.sql
+} // }}}
+
+func (r *RawExpr) Args() []any { // {{{
+	return r.args
+} // }}}
+
+func (r *RawExpr) String() string { // {{{
+	return r.sql
+} // }}}
+
+// 字段值的占位符及参数: RawExpr 使用其 sql 片段, 其他值使用 ?
+func BindValue(val any) (string, []any) { // {{{
+	if r, ok := val.(*RawExpr); ok {
+		return r.sql, r.args
+	}
+
+	return "?", []any{val}
+} // }}}
+
+// :expr 字段的值: RawExpr 使用其 sql 片段; 数值及 bool 直接格式化
+// 字符串原样拼接(不转义, 仅用于可信的值), 已弃用, 每个调用位置首次使用时输出警告; 其他类型返回错误
+func ExprValue(val any) (string, []any, error) { // {{{
+	switch v := val.(type) {
+	case *RawExpr:
+		return v.sql, v.args, nil
+	case string:
+		if caller := sqlCaller(); !exprWarnedBefore(caller) {
+			warnf("[db] deprecated: string value of :expr is interpolated into sql, use db.Raw instead, caller: %s", caller)
+		}
+
+		return v, nil, nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, bool:
+		return fmt.Sprintf("%v", v), nil, nil
+	}
+
+	return "", nil, fmt.Errorf(":expr value must be db.Raw, string, number or bool, got %T", val)
+} // }}}
+
+// 已输出弃用警告的调用位置(业务代码的 文件:行号), 数量受代码中调用位置的限制
+var exprWarned sync.Map
+
+func exprWarnedBefore(caller string) bool { // {{{
+	_, loaded := exprWarned.LoadOrStore(caller, struct{}{})
+	return loaded
+} // }}}
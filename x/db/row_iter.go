@@ -2,25 +2,32 @@ package db
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"fmt"
 )
 
 type RowIter struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
 	rows     *sql.Rows
 	cols     []string
 	scanArgs []any
 	values   []any
 	closed   bool
 	useBytes bool //  是否保留 []byte, sql.RawBytes  字段值类型, 默认转为 string
+	num      int64
+	err      error
+	onClose  func(num int64, err error) // 关闭时回调, 参数为迭代的行数及错误, 用于慢查询日志
 }
 
 // 私有方法,  由QueryStream 调用
-func newRowIter(rows *sql.Rows, use_bytes bool) (*RowIter, error) { // {{{
+func newRowIter(ctx context.Context, cancel context.CancelFunc, rows *sql.Rows, use_bytes bool) (*RowIter, error) { // {{{
 	cols, err := rows.Columns()
 	if err != nil {
 		rows.Close()
-		return nil, errorHandle(err)
+		cancel()
+		return nil, errorHandle(ctxError(ctx, "", err))
 	}
 
 	values := make([]any, len(cols))
@@ -30,6 +37,8 @@ func newRowIter(rows *sql.Rows, use_bytes bool) (*RowIter, error) { // {{{
 	}
 
 	return &RowIter{
+		ctx:      ctx,
+		cancel:   cancel,
 		rows:     rows,
 		cols:     cols,
 		scanArgs: scanArgs,
@@ -63,7 +72,8 @@ func (it *RowIter) Foreach(fn func(map[string]any) error, limits ...int) error {
 		}
 
 		if err := it.rows.Scan(it.scanArgs...); err != nil {
-			return errorHandle(err)
+			it.err = err
+			return errorHandle(ctxError(it.ctx, "", err))
 		}
 
 		row := make(map[string]any, len(it.cols))
@@ -71,6 +81,8 @@ func (it *RowIter) Foreach(fn func(map[string]any) error, limits ...int) error {
 			row[it.cols[i]] = parseValue(it.values[i], it.useBytes)
 		}
 
+		it.num++
+
 		if err := fn(row); err != nil {
 			return errorHandle(err)
 		}
@@ -78,7 +90,9 @@ func (it *RowIter) Foreach(fn func(map[string]any) error, limits ...int) error {
 		num++
 	}
 
-	return errorHandle(it.rows.Err())
+	it.err = it.rows.Err()
+
+	return errorHandle(ctxError(it.ctx, "", it.err))
 } // }}}
 
 // 收集所有行数据到切片中
@@ -109,7 +123,15 @@ func (it *RowIter) Close() error { // {{{
 	}
 
 	it.closed = true
-	return it.rows.Close()
+	defer it.cancel()
+
+	err := it.rows.Close()
+
+	if it.onClose != nil {
+		it.onClose(it.num, it.err)
+	}
+
+	return err
 } // }}}
 
 func parseValue(val any, use_bytes bool) any { // {{{
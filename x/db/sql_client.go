@@ -26,12 +26,19 @@ type SqlClient struct {
 	tx       *sql.Tx
 	executor Executor
 	dbType   string
+	dialect  Dialect
 	p        *SqlClient //实际上没什么用，只在事务中打印调式信息时使用 (由于事务中执行explain语句会出现'busy buffer'的错误)
 	id       string
+	timeout  time.Duration //单条 sql 默认超时时间, 可通过 WithQueryTimeout 为 ctx 单独指定
+	hooks    *commitHooks  //事务提交后执行的函数
+
+	slowQuery time.Duration //慢查询阈值, 为 0 时使用 SqlLogConfig.SlowQuery
+	stmts     *stmtCache    //预处理语句缓存, 事务中与所属连接池共用
 }
 
 func (s *SqlClient) SetDB(dbt string, _db *sql.DB) error { // {{{
 	s.dbType = dbt
+	s.dialect = GetDialect(dbt)
 	s.db = _db
 	s.executor = &DbExecutor{s.db}
 
@@ -39,6 +46,10 @@ func (s *SqlClient) SetDB(dbt string, _db *sql.DB) error { // {{{
 } // }}}
 
 func (s *SqlClient) Close() { //{{{
+	if s.stmts != nil && !s.intx {
+		s.stmts.close()
+	}
+
 	if s.db != nil {
 		s.db.Close()
 	}
@@ -48,14 +59,31 @@ func (s *SqlClient) Ping(ctx context.Context) error { //{{{
 	return s.db.PingContext(ctx)
 } //}}}
 
+// 连接池状态
+func (s *SqlClient) Stats() sql.DBStats { //{{{
+	return s.db.Stats()
+} //}}}
+
 func (s *SqlClient) SetDebug(open bool) { //{{{
 	s.Debug = open
 } //}}}
 
+func (s *SqlClient) SetTimeout(timeout time.Duration) { //{{{
+	s.timeout = timeout
+} //}}}
+
 func (s *SqlClient) Type() string { //{{{
 	return s.dbType
 } //}}}
 
+func (s *SqlClient) Dialect() Dialect { //{{{
+	if s.dialect == nil {
+		return MySQL
+	}
+
+	return s.dialect
+} //}}}
+
 func (s *SqlClient) ID() string { //{{{
 	if s.id == "" {
 		id := fmt.Sprintf("%p", &s.db)
@@ -66,13 +94,18 @@ func (s *SqlClient) ID() string { //{{{
 } //}}}
 
 func (s *SqlClient) Begin(is_readonly bool) (DBClient, error) { // {{{
+	return s.BeginContext(context.Background(), is_readonly)
+} // }}}
+
+// ctx 取消时事务自动回滚, 单条 sql 超时不作用于事务本身
+func (s *SqlClient) BeginContext(ctx context.Context, is_readonly bool) (DBClient, error) { // {{{
 	//tx, err := s.db.Begin()
-	tx, err := s.db.BeginTx(context.Background(), &sql.TxOptions{
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{
 		ReadOnly: is_readonly,
 	})
 
 	if err != nil {
-		return nil, errorHandle(fmt.Errorf("trans error:%v", err))
+		return nil, errorHandle(fmt.Errorf("trans error:%w", err))
 	}
 
 	if s.Debug {
@@ -84,21 +117,28 @@ func (s *SqlClient) Begin(is_readonly bool) (DBClient, error) { // {{{
 	}
 
 	return &SqlClient{
-		db:       s.db,
-		executor: &TxExecutor{tx},
-		tx:       tx,
-		intx:     true,
-		Debug:    s.Debug,
-		p:        s,
+		db:        s.db,
+		executor:  &TxExecutor{tx},
+		tx:        tx,
+		intx:      true,
+		Debug:     s.Debug,
+		p:         s,
+		dbType:    s.dbType,
+		dialect:   s.dialect,
+		timeout:   s.timeout,
+		hooks:     &commitHooks{},
+		slowQuery: s.slowQuery,
+		stmts:     s.stmts,
 	}, nil
 } // }}}
 
 func (s *SqlClient) Rollback() error { // {{{
 	if s.intx && nil != s.tx {
 		s.intx = false
+		s.hooks.discard(0)
 		err := s.tx.Rollback()
 		if err != nil {
-			return errorHandle(fmt.Errorf("trans rollback error:%v", err))
+			return errorHandle(fmt.Errorf("trans rollback error:%w", err))
 		}
 
 		if s.Debug {
@@ -114,31 +154,38 @@ func (s *SqlClient) Commit() error { // {{{
 		s.intx = false
 		err := s.tx.Commit()
 		if err != nil {
-			return errorHandle(fmt.Errorf("trans commit error:%v", err))
+			s.hooks.discard(0)
+			return errorHandle(fmt.Errorf("trans commit error:%w", err))
 		}
 
 		if s.Debug {
 			log.Println("Commit transaction on #ID:", s.ID())
 		}
+
+		s.hooks.run()
 	}
 
 	return nil
 } // }}}
 
 func (s *SqlClient) Insert(table string, vals ...map[string]any) (int, error) { // {{{
+	return s.InsertContext(context.Background(), table, vals...)
+} // }}}
+
+func (s *SqlClient) InsertContext(ctx context.Context, table string, vals ...map[string]any) (int, error) { // {{{
 	if len(vals) == 0 || len(vals[0]) == 0 {
 		return 0, fmt.Errorf("no record found")
 	}
 
 	// 获取所有列名（假设所有map的键相同，以第一个为准）
-	columns := make([]string, 0, len(vals[0]))
+	columns, err := rowColumns(vals[0])
+	if err != nil {
+		return 0, err
+	}
 
-	for col := range vals[0] {
-		col = strings.TrimSuffix(col, ":expr")
-		if slices.Contains(columns, col) {
-			return 0, fmt.Errorf("row 0 has repeated columns:%s", col)
-		}
-		columns = append(columns, col)
+	values, args, err := rowValues(vals, columns)
+	if err != nil {
+		return 0, err
 	}
 
 	buf := bytes.NewBufferString("")
@@ -146,41 +193,37 @@ func (s *SqlClient) Insert(table string, vals ...map[string]any) (int, error) {
 	buf.WriteString("insert into ")
 	buf.WriteString(table)
 	buf.WriteString(" (")
-	buf.WriteString(strings.Join(columns, ", "))
+	buf.WriteString(s.quoteColumns(columns))
 	buf.WriteString(") ")
 	buf.WriteString(" values ")
+	buf.WriteString(values)
 
-	// 构建占位符和值
-	var placeholders []string
-	var args []any
-	for i, row := range vals {
-		// 检查每行的列是否一致
-		if len(row) != len(columns) {
-			return 0, fmt.Errorf("row %d has different columns count", i)
+	// 不支持 LastInsertId 的数据库, 使用 RETURNING 返回主键
+	if returning := s.Dialect().Returning(getReturning(ctx)); returning != "" && len(vals) == 1 {
+		buf.WriteString(returning)
+		sqlstr := buf.String()
+
+		if s.Debug {
+			startTime := time.Now()
+			defer s.debugSql(sqlstr, args, startTime)
 		}
 
-		// 构建占位符
-		ph := make([]string, len(columns))
-		for j, col := range columns {
-			val, ok := row[col]
-			if !ok {
-				if val, ok = row[col+":expr"]; ok {
-					ph[j] = fmt.Sprintf("%v", val)
-					continue
-				}
-				return 0, fmt.Errorf("row %d missing column %s", i, col)
-			}
+		ctx, cancel := withQueryTimeout(ctx, s.timeout)
+		defer cancel()
 
-			ph[j] = "?"
-			args = append(args, val)
+		var lastid int64
+		start := time.Now()
+		err := s.queryRowContext(ctx, sqlstr, args, &lastid)
+		s.logSql(ctx, sqlstr, args, time.Since(start), 1, err, true)
+		if err != nil {
+			return 0, errorHandle(ctxError(ctx, sqlstr, err))
 		}
-		placeholders = append(placeholders, "("+strings.Join(ph, ", ")+")")
-	}
 
-	buf.WriteString(strings.Join(placeholders, ", "))
+		return int(lastid), nil
+	}
 
 	sqlstr := buf.String()
-	result, err := s.Exec(sqlstr, args...)
+	result, err := s.ExecContext(ctx, sqlstr, args...)
 	if err != nil {
 		return 0, err
 	}
@@ -198,6 +241,10 @@ func (s *SqlClient) Insert(table string, vals ...map[string]any) (int, error) {
 } // }}}
 
 func (s *SqlClient) Update(table string, vals map[string]any, where string, val ...any) (int, error) { // {{{
+	return s.UpdateContext(context.Background(), table, vals, where, val...)
+} // }}}
+
+func (s *SqlClient) UpdateContext(ctx context.Context, table string, vals map[string]any, where string, val ...any) (int, error) { // {{{
 	if len(vals) == 0 {
 		return 0, fmt.Errorf("no record found")
 	}
@@ -217,20 +264,27 @@ func (s *SqlClient) Update(table string, vals map[string]any, where string, val
 
 		col, isExpr = strings.CutSuffix(col, ":expr")
 
-		buf.WriteString(col)
+		buf.WriteString(s.Dialect().Quote(col))
 		buf.WriteString("=")
 
+		var ph string
+		var args []any
 		if isExpr {
 			if _, ok := vals[col]; ok {
 				return 0, fmt.Errorf("exists repeated columns: %s", col)
 			}
 
-			buf.WriteString(fmt.Sprintf("%v", val))
+			var err error
+			if ph, args, err = ExprValue(val); err != nil {
+				return 0, err
+			}
 		} else {
-			buf.WriteString("?")
-			value = append(value, val)
+			ph, args = BindValue(val)
 		}
 
+		buf.WriteString(ph)
+		value = append(value, args...)
+
 		i++
 	}
 
@@ -239,7 +293,7 @@ func (s *SqlClient) Update(table string, vals map[string]any, where string, val
 	sqlstr := buf.String()
 
 	value = append(value, val...)
-	result, err := s.Exec(sqlstr, value...)
+	result, err := s.ExecContext(ctx, sqlstr, value...)
 	if err != nil {
 		return 0, err
 	}
@@ -253,59 +307,74 @@ func (s *SqlClient) Update(table string, vals map[string]any, where string, val
 } // }}}
 
 func (s *SqlClient) Upsert(table string, vals map[string]any, ignore_fields ...string) (int, error) { // {{{
+	return s.UpsertContext(context.Background(), table, vals, ignore_fields...)
+} // }}}
+
+func (s *SqlClient) UpsertContext(ctx context.Context, table string, vals map[string]any, ignore_fields ...string) (int, error) { // {{{
 	if len(vals) == 0 {
 		return 0, fmt.Errorf("no record found")
 	}
 
-	// 获取列名和值
-	var columns []string
-	var placeholders []string
-	var args []any
-	var updateParts []string
-	var isExpr bool
+	columns, err := rowColumns(vals)
+	if err != nil {
+		return 0, err
+	}
 
-	// 插入
-	for col, val := range vals {
-		col, isExpr = strings.CutSuffix(col, ":expr")
-		columns = append(columns, col)
-		if isExpr {
-			if _, ok := vals[col]; ok {
-				return 0, fmt.Errorf("exists repeated columns: %s", col)
-			}
-			placeholders = append(placeholders, fmt.Sprintf("%v", val))
-		} else {
-			placeholders = append(placeholders, "?")
-			args = append(args, val)
-		}
+	values, args, err := rowValues([]map[string]any{vals}, columns)
+	if err != nil {
+		return 0, err
 	}
 
-	// 更新（排除忽略字段）
-	for col, val := range vals {
-		col, isExpr = strings.CutSuffix(col, ":expr")
-		if slices.Contains(ignore_fields, col) {
+	guard := getUpsertGuard(ctx)
+	if guard != "" && !slices.Contains(columns, guard) {
+		return 0, fmt.Errorf("upsert guard field %s is required", guard)
+	}
+
+	// 更新（排除忽略字段及保护字段）
+	var updateCols, updateVals []string
+	for _, col := range columns {
+		if slices.Contains(ignore_fields, col) || col == guard {
 			continue
 		}
 
-		if isExpr {
-			updateParts = append(updateParts, fmt.Sprintf("`%s` = %v", col, val))
+		if val, ok := vals[col]; ok {
+			ph, vs := BindValue(val)
+			updateCols, updateVals = append(updateCols, col), append(updateVals, ph)
+			args = append(args, vs...)
 		} else {
-			updateParts = append(updateParts, col+" = ?")
-			args = append(args, val)
+			ph, vs, err := ExprValue(vals[col+":expr"])
+			if err != nil {
+				return 0, err
+			}
+
+			updateCols, updateVals = append(updateCols, col), append(updateVals, ph)
+			args = append(args, vs...)
 		}
 	}
 
+	update, err := s.Dialect().UpsertUpdate(table, guard, updateCols, updateVals)
+	if err != nil {
+		return 0, errorHandle(err)
+	}
+
 	buf := bytes.NewBufferString("")
 	buf.WriteString("INSERT INTO ")
 	buf.WriteString(table)
 	buf.WriteString(" (")
-	buf.WriteString(strings.Join(columns, ", "))
-	buf.WriteString(") VALUES (")
-	buf.WriteString(strings.Join(placeholders, ", "))
-	buf.WriteString(") ON DUPLICATE KEY UPDATE ")
-	buf.WriteString(strings.Join(updateParts, ", "))
+	buf.WriteString(s.quoteColumns(columns))
+	buf.WriteString(") VALUES ")
+	// 忽略字段同时作为冲突检测字段(ON CONFLICT)
+	conflict, err := s.Dialect().Upsert(ignore_fields)
+	if err != nil {
+		return 0, errorHandle(err)
+	}
+
+	buf.WriteString(values)
+	buf.WriteString(conflict)
+	buf.WriteString(update)
 
 	sqlstr := buf.String()
-	result, err := s.Exec(sqlstr, args...)
+	result, err := s.ExecContext(ctx, sqlstr, args...)
 	if err != nil {
 		return 0, err
 	}
@@ -321,38 +390,88 @@ func (s *SqlClient) Upsert(table string, vals map[string]any, ignore_fields ...s
 	return int(lastid), nil
 } // }}}
 
-func (s *SqlClient) Delete(options ...FnSqlOption) (int, error) { // {{{
-	var sb strings.Builder
+func (s *SqlClient) UpsertBatch(table string, vals []map[string]any, conflict []string, update_fields []string) (int, error) { // {{{
+	return s.UpsertBatchContext(context.Background(), table, vals, conflict, update_fields)
+} // }}}
 
-	so := s.parseOptions(options)
+// 批量 upsert, 返回影响的行数; conflict 为冲突检测字段(ON CONFLICT), update_fields 为冲突时更新的字段, 为空时更新 conflict 以外的所有字段
+// 所有记录的字段须相同, 更新时使用待插入的值(mysql: VALUES(col), postgres/sqlite: EXCLUDED.col)
+// ctx 中指定了保护字段(WithUpsertGuard)时只更新该字段相同的记录
+func (s *SqlClient) UpsertBatchContext(ctx context.Context, table string, vals []map[string]any, conflict []string, update_fields []string) (int, error) { // {{{
+	if len(vals) == 0 || len(vals[0]) == 0 {
+		return 0, fmt.Errorf("no record found")
+	}
 
-	sb.WriteString("DELETE")
+	columns, err := rowColumns(vals[0])
+	if err != nil {
+		return 0, err
+	}
 
-	if so.table != "" {
-		sb.WriteString(" FROM ")
-		sb.WriteString(so.table)
+	values, args, err := rowValues(vals, columns)
+	if err != nil {
+		return 0, err
+	}
+
+	guard := getUpsertGuard(ctx)
+	if guard != "" && !slices.Contains(columns, guard) {
+		return 0, fmt.Errorf("upsert guard field %s is required", guard)
+	}
+
+	if len(update_fields) == 0 {
+		update_fields = columns
 	}
 
-	if so.where != "" {
-		sb.WriteString(" WHERE ")
-		sb.WriteString(so.where)
+	var updateCols, updateVals []string
+	for _, col := range update_fields {
+		if !slices.Contains(conflict, col) && col != guard {
+			updateCols, updateVals = append(updateCols, col), append(updateVals, s.Dialect().UpsertValue(col))
+		}
+	}
+
+	if len(updateCols) == 0 {
+		return 0, fmt.Errorf("no update fields")
 	}
 
-	if so.order != "" {
-		sb.WriteString(" ORDER BY ")
-		sb.WriteString(so.order)
+	clause, err := s.Dialect().Upsert(conflict)
+	if err != nil {
+		return 0, errorHandle(err)
 	}
 
-	if so.limits != "" {
-		sb.WriteString(" LIMIT ")
-		sb.WriteString(so.limits)
+	update, err := s.Dialect().UpsertUpdate(table, guard, updateCols, updateVals)
+	if err != nil {
+		return 0, errorHandle(err)
 	}
 
-	return s.Execute(sb.String(), so.vals...)
+	buf := bytes.NewBufferString("")
+	buf.WriteString("INSERT INTO ")
+	buf.WriteString(table)
+	buf.WriteString(" (")
+	buf.WriteString(s.quoteColumns(columns))
+	buf.WriteString(") VALUES ")
+	buf.WriteString(values)
+	buf.WriteString(clause)
+	buf.WriteString(update)
+
+	return s.ExecuteContext(ctx, buf.String(), args...)
+} // }}}
+
+func (s *SqlClient) Delete(options ...FnSqlOption) (int, error) { // {{{
+	return s.DeleteContext(context.Background(), options...)
+} // }}}
+
+func (s *SqlClient) DeleteContext(ctx context.Context, options ...FnSqlOption) (int, error) { // {{{
+	so := s.parseOptions(options)
+	sqlstr := s.Dialect().Delete(so.table, so.where, so.order, so.limits)
+
+	return s.ExecuteContext(ctx, sqlstr, so.vals...)
 } // }}}
 
 func (s *SqlClient) Execute(sqlstr string, val ...any) (int, error) { // {{{
-	result, err := s.Exec(sqlstr, val...)
+	return s.ExecuteContext(context.Background(), sqlstr, val...)
+} // }}}
+
+func (s *SqlClient) ExecuteContext(ctx context.Context, sqlstr string, val ...any) (int, error) { // {{{
+	result, err := s.ExecContext(ctx, sqlstr, val...)
 	if err != nil {
 		return 0, err
 	}
@@ -366,34 +485,71 @@ func (s *SqlClient) Execute(sqlstr string, val ...any) (int, error) { // {{{
 } // }}}
 
 func (s *SqlClient) Exec(sqlstr string, val ...any) (result sql.Result, err error) { // {{{
+	return s.ExecContext(context.Background(), sqlstr, val...)
+} // }}}
+
+func (s *SqlClient) ExecContext(ctx context.Context, sqlstr string, val ...any) (result sql.Result, err error) { // {{{
 	if s.Debug {
 		startTime := time.Now()
 		defer s.debugSql(sqlstr, val, startTime)
 	}
 
-	result, err = s.executor.Exec(sqlstr, val...)
+	ctx, cancel := withQueryTimeout(ctx, s.timeout)
+	defer cancel()
 
-	if s.Debug {
+	start := time.Now()
+	query := s.Dialect().Rebind(sqlstr)
+	result, err = withStmt(s, ctx, query, val, func(stmt *sql.Stmt) (sql.Result, error) {
+		if stmt != nil {
+			return stmt.ExecContext(ctx, val...)
+		}
+
+		return s.executor.ExecContext(ctx, query, val...)
+	})
+
+	if sqlLogConf.Load().enabled() {
+		var rows int64
+		if err == nil {
+			rows, _ = result.RowsAffected()
+		}
+
+		s.logSql(ctx, sqlstr, val, time.Since(start), rows, err, true)
 	}
 
-	return result, errorHandle(err)
+	return result, errorHandle(ctxError(ctx, sqlstr, err))
 } // }}}
 
 func (s *SqlClient) GetOne(options ...FnSqlOption) (any, error) { // {{{
+	return s.GetOneContext(context.Background(), options...)
+} // }}}
+
+func (s *SqlClient) GetOneContext(ctx context.Context, options ...FnSqlOption) (any, error) { // {{{
 	options = append(options, WithLimits("1"))
-	return s.QueryOne(options...)
+	return s.QueryOneContext(ctx, options...)
 } // }}}
 
 func (s *SqlClient) GetRow(options ...FnSqlOption) (map[string]any, error) { // {{{
+	return s.GetRowContext(context.Background(), options...)
+} // }}}
+
+func (s *SqlClient) GetRowContext(ctx context.Context, options ...FnSqlOption) (map[string]any, error) { // {{{
 	options = append(options, WithLimits("1"))
-	return s.QueryRow(options...)
+	return s.QueryRowContext(ctx, options...)
 } // }}}
 
 func (s *SqlClient) GetAll(options ...FnSqlOption) ([]map[string]any, error) { //{{{
-	return s.Query(options...)
+	return s.QueryContext(context.Background(), options...)
+} // }}}
+
+func (s *SqlClient) GetAllContext(ctx context.Context, options ...FnSqlOption) ([]map[string]any, error) { //{{{
+	return s.QueryContext(ctx, options...)
 } // }}}
 
 func (s *SqlClient) QueryOne(options ...FnSqlOption) (any, error) { // {{{
+	return s.QueryOneContext(context.Background(), options...)
+} // }}}
+
+func (s *SqlClient) QueryOneContext(ctx context.Context, options ...FnSqlOption) (any, error) { // {{{
 	sqlOption := s.parseOptions(options)
 	sqlstr, vals := sqlOption.ToSql()
 
@@ -405,16 +561,31 @@ func (s *SqlClient) QueryOne(options ...FnSqlOption) (any, error) { // {{{
 		defer s.debugSql(sqlstr, vals, startTime)
 	}
 
-	err = s.executor.QueryRow(sqlstr, vals...).Scan(&value)
+	ctx, cancel := withQueryTimeout(ctx, s.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err = s.queryRowContext(ctx, sqlstr, vals, &value)
+
+	var rows int64
+	if err == nil {
+		rows = 1
+	}
+	s.logSql(ctx, sqlstr, vals, time.Since(start), rows, err, false)
+
 	if err != nil {
-		return nil, errorHandle(err)
+		return nil, errorHandle(ctxError(ctx, sqlstr, err))
 	}
 
 	return parseValue(value, sqlOption.useBytes), nil
 } // }}}
 
 func (s *SqlClient) QueryRow(options ...FnSqlOption) (map[string]any, error) { // {{{
-	iter, err := s.QueryStream(options...)
+	return s.QueryRowContext(context.Background(), options...)
+} // }}}
+
+func (s *SqlClient) QueryRowContext(ctx context.Context, options ...FnSqlOption) (map[string]any, error) { // {{{
+	iter, err := s.queryStream(ctx, options, false)
 	if err != nil {
 		return nil, errorHandle(err)
 	}
@@ -432,7 +603,11 @@ func (s *SqlClient) QueryRow(options ...FnSqlOption) (map[string]any, error) { /
 } // }}}
 
 func (s *SqlClient) Query(options ...FnSqlOption) ([]map[string]any, error) { //{{{
-	iter, err := s.QueryStream(options...)
+	return s.QueryContext(context.Background(), options...)
+} // }}}
+
+func (s *SqlClient) QueryContext(ctx context.Context, options ...FnSqlOption) ([]map[string]any, error) { //{{{
+	iter, err := s.queryStream(ctx, options, false)
 	if err != nil {
 		return nil, errorHandle(err)
 	}
@@ -447,6 +622,16 @@ func (s *SqlClient) Query(options ...FnSqlOption) ([]map[string]any, error) { //
 
 // 返回迭代器
 func (s *SqlClient) QueryStream(options ...FnSqlOption) (*RowIter, error) { //{{{
+	return s.QueryStreamContext(context.Background(), options...)
+} // }}}
+
+// 返回迭代器, 超时时间作用于整个迭代过程, 迭代器关闭时释放
+func (s *SqlClient) QueryStreamContext(ctx context.Context, options ...FnSqlOption) (*RowIter, error) { //{{{
+	return s.queryStream(ctx, options, true)
+} // }}}
+
+// stream 为 true 时, 慢查询日志中的耗时为返回第一批结果的时间, 否则为迭代完成的时间
+func (s *SqlClient) queryStream(ctx context.Context, options []FnSqlOption, stream bool) (*RowIter, error) { //{{{
 	sqlOption := s.parseOptions(options)
 	sqlstr, vals := sqlOption.ToSql()
 
@@ -455,17 +640,124 @@ func (s *SqlClient) QueryStream(options ...FnSqlOption) (*RowIter, error) { //{{
 		defer s.debugSql(sqlstr, vals, startTime)
 	}
 
-	rows, err := s.executor.Query(sqlstr, vals...)
+	ctx, cancel := withQueryTimeout(ctx, s.timeout)
+
+	start := time.Now()
+	query := s.Dialect().Rebind(sqlstr)
+	rows, err := withStmt(s, ctx, query, vals, func(stmt *sql.Stmt) (*sql.Rows, error) {
+		if stmt != nil {
+			return stmt.QueryContext(ctx, vals...)
+		}
+
+		return s.executor.QueryContext(ctx, query, vals...)
+	})
 
 	if err != nil {
-		return nil, errorHandle(err)
+		s.logSql(ctx, sqlstr, vals, time.Since(start), 0, err, false)
+		cancel()
+		return nil, errorHandle(ctxError(ctx, sqlstr, err))
+	}
+
+	iter, err := newRowIter(ctx, cancel, rows, sqlOption.useBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if sqlLogConf.Load().enabled() {
+		elapsed := time.Since(start)
+		iter.onClose = func(num int64, err error) {
+			if !stream {
+				elapsed = time.Since(start)
+			}
+
+			s.logSql(ctx, sqlstr, vals, elapsed, num, err, false)
+		}
+	}
+
+	return iter, nil
+} // }}}
+
+// 记录的列名, 去掉 ":expr" 后缀并按字母排序, 保证生成的 sql 稳定
+func rowColumns(row map[string]any) ([]string, error) { // {{{
+	columns := make([]string, 0, len(row))
+	for col := range row {
+		col = strings.TrimSuffix(col, ":expr")
+		if slices.Contains(columns, col) {
+			return nil, fmt.Errorf("row 0 has repeated columns:%s", col)
+		}
+		columns = append(columns, col)
+	}
+
+	slices.Sort(columns)
+
+	return columns, nil
+} // }}}
+
+// 按方言引用的列名列表, 如: `id`, `name`
+func (s *SqlClient) quoteColumns(columns []string) string { // {{{
+	quoted := make([]string, len(columns))
+	for i, col := range columns {
+		quoted[i] = s.Dialect().Quote(col)
+	}
+
+	return strings.Join(quoted, ", ")
+} // }}}
+
+// 按列名顺序生成 values 子句的占位符及参数, 如: (?, ?), (?, ?)
+func rowValues(rows []map[string]any, columns []string) (string, []any, error) { // {{{
+	var placeholders []string
+	var args []any
+	for i, row := range rows {
+		// 检查每行的列是否一致
+		if len(row) != len(columns) {
+			return "", nil, fmt.Errorf("row %d has different columns count", i)
+		}
+
+		// 构建占位符
+		ph := make([]string, len(columns))
+		for j, col := range columns {
+			val, ok := row[col]
+			if !ok {
+				if val, ok = row[col+":expr"]; ok {
+					var vals []any
+					var err error
+					if ph[j], vals, err = ExprValue(val); err != nil {
+						return "", nil, err
+					}
+
+					args = append(args, vals...)
+					continue
+				}
+				return "", nil, fmt.Errorf("row %d missing column %s", i, col)
+			}
+
+			var vals []any
+			ph[j], vals = BindValue(val)
+			args = append(args, vals...)
+		}
+		placeholders = append(placeholders, "("+strings.Join(ph, ", ")+")")
 	}
 
-	return newRowIter(rows, sqlOption.useBytes)
+	return strings.Join(placeholders, ", "), args, nil
+} // }}}
+
+// 单行查询并读取到 dest, 供 QueryOne 及 RETURNING 使用
+// sql.Row 的错误在 Scan 时才返回, 因此在预处理语句失效重试的范围内 Scan
+func (s *SqlClient) queryRowContext(ctx context.Context, sqlstr string, vals []any, dest ...any) error { //{{{
+	query := s.Dialect().Rebind(sqlstr)
+	_, err := withStmt(s, ctx, query, vals, func(stmt *sql.Stmt) (struct{}, error) {
+		if stmt != nil {
+			return struct{}{}, stmt.QueryRowContext(ctx, vals...).Scan(dest...)
+		}
+
+		return struct{}{}, s.executor.QueryRowContext(ctx, query, vals...).Scan(dest...)
+	})
+
+	return err
 } // }}}
 
 func (s *SqlClient) parseOptions(options []FnSqlOption) *SqlOption { //{{{
-	so := &SqlOption{}
+	so := &SqlOption{dialect: s.Dialect()}
 	for _, opt := range options {
 		opt(so)
 	}
@@ -476,14 +768,14 @@ func (s *SqlClient) parseOptions(options []FnSqlOption) *SqlOption { //{{{
 func (s *SqlClient) explain(sqlstr string, val []any) { //{{{
 	expl_results := []map[string]any{}
 	sqlOptions := []FnSqlOption{
-		WithSql("EXPLAIN "+sqlstr, val),
+		WithSql(s.Dialect().Explain(sqlstr), val),
 	}
 	if s.intx {
 		expl_results, _ = s.p.Query(sqlOptions...)
 	} else {
 		expl_results, _ = s.Query(sqlOptions...)
 	}
-	expl := &SqlExplain{s.dbType, expl_results}
+	expl := &SqlExplain{s.Dialect(), expl_results}
 	expl.DrawConsole()
 } // }}}
 
@@ -499,8 +791,18 @@ func (s *SqlClient) debugSql(query string, args []any, startTime time.Time) { //
 
 	sql := query
 	if len(args) > 0 {
+		args = redactArgs(SqlRedactor, query, args)
+		offset := 0
 		for _, arg := range args {
-			sql = strings.Replace(sql, "?", formatArg(arg), 1)
+			pos := strings.Index(sql[offset:], "?")
+			if pos < 0 {
+				break
+			}
+
+			pos += offset
+			value := formatArg(arg)
+			sql = sql[:pos] + value + sql[pos+1:]
+			offset = pos + len(value)
 		}
 	}
 
@@ -8,8 +8,8 @@ import (
 )
 
 type SqlExplain struct {
-	dbType string
-	result sqlResult
+	dialect Dialect
+	result  sqlResult
 }
 
 type sqlResult = []map[string]any
@@ -19,18 +19,7 @@ var def_fields = []string{"id", "select_type", "table", "partitions", "type", "p
 func (s *SqlExplain) DrawConsole() { /*{{{*/
 	arr_max_length := []int{}
 	records := map[int][]string{}
-	fields := def_fields
-
-	if s.dbType != "mysql" {
-		for _, record := range s.result {
-			fields = []string{}
-			for field, _ := range record {
-				fields = append(fields, field)
-			}
-
-			break
-		}
-	}
+	fields := s.dialect.ExplainFields(s.result)
 
 	for _, v := range fields {
 		arr_max_length = append(arr_max_length, len(v)+2)
@@ -0,0 +1,254 @@
+package db
+
+import (
+	"context"
+	"github.com/nyxless/nyx/x/log"
+	"hash/fnv"
+	"math/rand"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 慢查询日志及写操作审计
+// 配置:
+//
+//	sql_log:
+//	  enabled: true
+//	  slow_query: 500               # 慢查询阈值(毫秒), 为 0 时不记录; DB 配置中的 slow_query 优先
+//	  slow_level_name: slow_sql     # 慢查询日志的级别名称, 为空时使用 Warn
+//	  audit: false                  # 是否记录所有写操作
+//	  audit_level_name: sql_audit   # 审计日志的级别名称, 为空时使用 Info
+//	  explain_rate: 0.01            # 慢查询中 SELECT 语句异步执行 EXPLAIN 的采样比例, 为 0 时不执行
+//
+// 日志字段: db, tx, fingerprint(参数化后 sql 的哈希, 用于聚合同类 sql), sql, args, duration(毫秒), rows, caller, route, guid, tenant, error
+// args 按占位符对应的字段名(包括 insert 的字段列表)及值脱敏, 未配置 log.redact 时使用 log.DefaultRedactRules
+// rows 为写操作影响的行数或查询返回的行数; QueryStream 的耗时为返回第一批结果的时间, 行数为迭代的行数
+type SqlLogConfig struct {
+	Logger         *log.Logger
+	SlowQuery      time.Duration
+	SlowLevelName  string
+	Audit          bool
+	AuditLevelName string
+	ExplainRate    float64
+	GuidKey        string // ctx 中 guid 的 key
+}
+
+var sqlLogConf atomic.Pointer[SqlLogConfig]
+
+// 慢查询及审计日志中参数的默认脱敏规则, 未配置 SqlRedactor 时使用, 避免写入的密码等以明文落入审计日志
+var defaultLogRedactor, _ = log.NewRedactor(log.DefaultRedactRules...)
+
+// 同时执行的 EXPLAIN 数量上限, 超出时跳过
+var explainSem = make(chan struct{}, 4)
+
+// 设置慢查询日志及审计, 为 nil 时关闭
+func SetSqlLog(conf *SqlLogConfig) { // {{{
+	if conf != nil && conf.GuidKey == "" {
+		conf.GuidKey = "guid"
+	}
+
+	sqlLogConf.Store(conf)
+} // }}}
+
+func (conf *SqlLogConfig) enabled() bool { // {{{
+	return conf != nil && conf.Logger != nil
+} // }}}
+
+// 设置慢查询阈值, 覆盖 SqlLogConfig.SlowQuery
+func (s *SqlClient) SetSlowQuery(threshold time.Duration) { //{{{
+	s.slowQuery = threshold
+} // }}}
+
+// sql 执行后记录日志, write 为 true 时为写操作
+func (s *SqlClient) logSql(ctx context.Context, query string, args []any, elapsed time.Duration, rows int64, err error, write bool) { // {{{
+	conf := sqlLogConf.Load()
+	if !conf.enabled() || strings.HasPrefix(query, "EXPLAIN") {
+		return
+	}
+
+	threshold := s.slowQuery
+	if threshold <= 0 {
+		threshold = conf.SlowQuery
+	}
+
+	slow := threshold > 0 && elapsed >= threshold
+	audit := conf.Audit && write
+	if !slow && !audit {
+		return
+	}
+
+	fields := []any{
+		log.LogField("db", s.ID()),
+		log.LogField("tx", s.intx),
+		log.LogField("fingerprint", Fingerprint(query)),
+		log.LogField("sql", query),
+		log.LogField("args", redactArgs(logRedactor(), query, args)),
+		log.LogField("duration", elapsed.Milliseconds()),
+		log.LogField("rows", rows),
+		log.LogField("caller", sqlCaller()),
+	}
+
+	if ctx != nil {
+		fields = append(fields, log.LogField("route", ctxRoute(ctx)), log.LogField("guid", ctx.Value(conf.GuidKey)))
+
+		// 租户由 x.WithTenant 设置, x/db 不能引用 x, 直接读取 ctx 的 key
+		if tenant, _ := ctx.Value("tenant").(string); tenant != "" {
+			fields = append(fields, log.LogField("tenant", tenant))
+		}
+	}
+
+	if err != nil {
+		fields = append(fields, log.LogField("error", err.Error()))
+	}
+
+	// 日志可能异步写入, 各条日志不能共用底层数组
+	fields = slices.Clip(fields)
+
+	if audit {
+		writeSqlLog(conf.Logger, conf.AuditLevelName, conf.Logger.Info, append(fields, log.LogField("type", "audit"))...)
+	}
+
+	if slow {
+		writeSqlLog(conf.Logger, conf.SlowLevelName, conf.Logger.Warn, append(fields, log.LogField("type", "slow"))...)
+
+		if !write && err == nil && conf.ExplainRate > 0 && rand.Float64() < conf.ExplainRate && isSelect(query) {
+			s.explainAsync(conf, query, args, fields)
+		}
+	}
+} // }}}
+
+func logRedactor() *log.Redactor { // {{{
+	if SqlRedactor.Empty() {
+		return defaultLogRedactor
+	}
+
+	return SqlRedactor
+} // }}}
+
+// 异步执行 EXPLAIN 并记录结果, 在事务中时使用事务外的连接
+func (s *SqlClient) explainAsync(conf *SqlLogConfig, query string, args []any, fields []any) { // {{{
+	select {
+	case explainSem <- struct{}{}:
+	default:
+		return
+	}
+
+	client := s
+	if s.intx {
+		client = s.p
+	}
+
+	go func() {
+		defer func() {
+			<-explainSem
+			recover()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		result, err := client.QueryContext(ctx, WithSql(client.Dialect().Explain(query), args))
+		if err != nil {
+			return
+		}
+
+		writeSqlLog(conf.Logger, conf.SlowLevelName, conf.Logger.Warn, append(fields, log.LogField("type", "explain"), log.LogField("explain", result))...)
+	}()
+} // }}}
+
+func writeSqlLog(logger *log.Logger, level_name string, def func(...any), fields ...any) { // {{{
+	if level_name != "" {
+		logger.Log(level_name, fields...)
+	} else {
+		def(fields...)
+	}
+} // }}}
+
+var (
+	fingerprintLiteral = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'|\b\d+(?:\.\d+)?\b|\$\d+`)
+	fingerprintList    = regexp.MustCompile(`\(\s*\?(?:\s*,\s*\?)*\s*\)`)
+	fingerprintSpace   = regexp.MustCompile(`\s+`)
+)
+
+// sql 指纹: 将字符串及数字常量、占位符列表归一化后的哈希, 参数不同的同类 sql 指纹相同
+func Fingerprint(query string) string { // {{{
+	fp := fingerprintLiteral.ReplaceAllString(query, "?")
+	fp = fingerprintList.ReplaceAllString(fp, "(?+)")
+	fp = strings.ToLower(strings.TrimSpace(fingerprintSpace.ReplaceAllString(fp, " ")))
+
+	h := fnv.New64a()
+	h.Write([]byte(fp))
+
+	return strconv.FormatUint(h.Sum64(), 16)
+} // }}}
+
+// 按占位符对应的字段名对参数脱敏
+func redactArgs(r *log.Redactor, query string, args []any) []any { // {{{
+	if len(args) == 0 || r.Empty() {
+		return args
+	}
+
+	columns := placeholderColumns(query)
+
+	res := make([]any, len(args))
+	for i, arg := range args {
+		var col string
+		if i < len(columns) {
+			col = columns[i]
+		}
+
+		res[i] = redactSqlArg(r, col, arg)
+	}
+
+	return res
+} // }}}
+
+// 调用 sql 的业务代码位置, 跳过框架内部的调用
+func sqlCaller() string { // {{{
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	for {
+		frame, more := frames.Next()
+		if !isFrameworkFrame(frame.Function) {
+			return frame.File + ":" + strconv.Itoa(frame.Line)
+		}
+
+		if !more {
+			return ""
+		}
+	}
+} // }}}
+
+func isFrameworkFrame(fn string) bool { // {{{
+	for _, prefix := range []string{"github.com/nyxless/nyx/x/db.", "github.com/nyxless/nyx/dao.", "github.com/nyxless/nyx/dao/tx.", "database/sql."} {
+		if strings.HasPrefix(fn, prefix) {
+			return true
+		}
+	}
+
+	return false
+} // }}}
+
+// ctx 中的路由: group/controller/action
+func ctxRoute(ctx context.Context) string { // {{{
+	var parts []string
+	for _, key := range []string{"group", "controller", "action"} {
+		if v, _ := ctx.Value(key).(string); v != "" {
+			parts = append(parts, v)
+		}
+	}
+
+	return strings.Join(parts, "/")
+} // }}}
+
+func isSelect(query string) bool { // {{{
+	query = strings.TrimSpace(query)
+	return len(query) > 6 && strings.EqualFold(query[:6], "SELECT")
+} // }}}
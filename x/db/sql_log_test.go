@@ -0,0 +1,73 @@
+package db
+
+import (
+	"bytes"
+	"github.com/nyxless/nyx/x/log"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPlaceholderColumns(t *testing.T) {
+	cases := []struct {
+		query string
+		want  []string
+	}{
+		{"INSERT INTO users (`name`, `password`) VALUES (?, ?), (?, ?)", []string{"name", "password", "name", "password"}},
+		{`INSERT INTO users ("name", "password") VALUES (?, lower(?)) ON CONFLICT ("id") DO UPDATE SET "password" = ?`, []string{"name", "password", "password"}},
+		{"INSERT INTO users (`name`, `password`) VALUES (?, ?) ON DUPLICATE KEY UPDATE `password` = IF(`tenant_id` = VALUES(`tenant_id`), ?, `password`)", []string{"name", "password", "password"}},
+		{"UPDATE users SET `password`=?, cnt = cnt + ? WHERE id IN (?, ?) AND name LIKE ?", []string{"password", "cnt", "id", "id", "name"}},
+		{`UPDATE users SET "password"=? WHERE "id"=? AND note = 'a?b'`, []string{"password", "id"}},
+	}
+
+	for _, c := range cases {
+		if got := placeholderColumns(c.query); !slices.Equal(got, c.want) {
+			t.Errorf("placeholderColumns(%q) = %v, want %v", c.query, got, c.want)
+		}
+	}
+}
+
+func TestRedactArgs(t *testing.T) {
+	redactor, err := log.NewRedactor(log.DefaultRedactRules...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queries := []string{
+		"INSERT INTO users (`name`, `password`) VALUES (?, ?)",
+		`INSERT INTO users ("name", "password") VALUES (?, ?)`,
+		"UPDATE users SET `name`=?, `password`=? WHERE id=1",
+		`UPDATE users SET "name"=?, "password"=? WHERE id=1`,
+	}
+
+	for _, query := range queries {
+		got := redactArgs(redactor, query, []any{"tom", "hunter2"})
+		if got[0] != "tom" || got[1] != "******" {
+			t.Errorf("redactArgs(%q) = %v", query, got)
+		}
+	}
+}
+
+func TestAuditRedact(t *testing.T) {
+	logger, err := log.NewLogger(&log.LogOptions{Level: 0xFF})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	logger.SetWriter(buf)
+
+	SetSqlLog(&SqlLogConfig{Logger: logger, Audit: true})
+	defer SetSqlLog(nil)
+
+	// 未配置 SqlRedactor 时使用默认规则
+	client := NewSqlClient()
+	client.logSql(nil, `INSERT INTO users ("name", "password") VALUES (?, ?)`, []any{"tom", "hunter2"}, 0, 1, nil, true)
+	client.logSql(nil, "UPDATE users SET `password`=? WHERE id=?", []any{"hunter2", 1}, 0, 1, nil, true)
+	logger.Close()
+
+	out := buf.String()
+	if strings.Count(out, "audit") != 2 || strings.Contains(out, "hunter2") || !strings.Contains(out, "tom") {
+		t.Fatalf("audit log: %s", out)
+	}
+}
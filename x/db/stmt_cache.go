@@ -0,0 +1,228 @@
+package db
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/go-sql-driver/mysql"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 预处理语句缓存
+// 配置(DB 配置中):
+//
+//	stmt_cache_size: 256   # 每个连接池缓存的预处理语句数量, 为 0 时不启用
+//
+// 以 Rebind 后的 sql 为 key 做 LRU 缓存, 只缓存带参数的 sql(无参数的 sql 多为拼接常量, 缓存命中率低)
+// 事务中通过 tx.StmtContext 将缓存的语句绑定到事务连接, 同一连接上已预处理的语句由 database/sql 复用
+// 连接断开(driver.ErrBadConn)时 database/sql 在新连接上自动重新预处理; 服务端语句失效(如 mysql 重启后句柄丢失、表结构变更)时移出缓存并重新预处理一次
+// 预处理失败时退回直接执行
+type StmtCacheStats struct {
+	Size       int   // 当前缓存的语句数
+	Capacity   int   // 缓存容量
+	Hits       int64 // 命中次数
+	Misses     int64 // 未命中次数
+	Evictions  int64 // LRU 淘汰次数
+	Reprepares int64 // 语句失效后重新预处理的次数
+	Errors     int64 // 预处理失败次数
+}
+
+type stmtCache struct {
+	mutex    sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+
+	hits       atomic.Int64
+	misses     atomic.Int64
+	evictions  atomic.Int64
+	reprepares atomic.Int64
+	errors     atomic.Int64
+}
+
+type stmtItem struct {
+	query string
+	stmt  *sql.Stmt
+}
+
+func newStmtCache(capacity int) *stmtCache { // {{{
+	return &stmtCache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    map[string]*list.Element{},
+	}
+} // }}}
+
+// 取得缓存的语句, 不存在时预处理并加入缓存; 预处理在锁外执行, 并发预处理同一 sql 时保留先加入的
+func (c *stmtCache) get(ctx context.Context, db *sql.DB, query string) (*sql.Stmt, error) { // {{{
+	c.mutex.Lock()
+	if e, ok := c.items[query]; ok {
+		c.ll.MoveToFront(e)
+		c.mutex.Unlock()
+		c.hits.Add(1)
+
+		return e.Value.(*stmtItem).stmt, nil
+	}
+	c.mutex.Unlock()
+	c.misses.Add(1)
+
+	stmt, err := db.PrepareContext(ctx, query)
+	if err != nil {
+		c.errors.Add(1)
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	if e, ok := c.items[query]; ok {
+		c.ll.MoveToFront(e)
+		c.mutex.Unlock()
+		stmt.Close()
+
+		return e.Value.(*stmtItem).stmt, nil
+	}
+
+	c.items[query] = c.ll.PushFront(&stmtItem{query: query, stmt: stmt})
+
+	var evicted []*sql.Stmt
+	for c.ll.Len() > c.capacity {
+		e := c.ll.Back()
+		item := c.ll.Remove(e).(*stmtItem)
+		delete(c.items, item.query)
+		evicted = append(evicted, item.stmt)
+	}
+	c.mutex.Unlock()
+
+	// 正在使用的语句关闭后, database/sql 在结果集释放时才真正关闭
+	for _, s := range evicted {
+		c.evictions.Add(1)
+		s.Close()
+	}
+
+	return stmt, nil
+} // }}}
+
+// 移出失效的语句, 已被替换为新语句时不处理
+func (c *stmtCache) remove(query string, stmt *sql.Stmt) { // {{{
+	c.mutex.Lock()
+	e, ok := c.items[query]
+	if !ok || e.Value.(*stmtItem).stmt != stmt {
+		c.mutex.Unlock()
+		return
+	}
+
+	c.ll.Remove(e)
+	delete(c.items, query)
+	c.mutex.Unlock()
+
+	stmt.Close()
+} // }}}
+
+func (c *stmtCache) close() { // {{{
+	c.mutex.Lock()
+	items := c.items
+	c.items = map[string]*list.Element{}
+	c.ll.Init()
+	c.mutex.Unlock()
+
+	for _, e := range items {
+		e.Value.(*stmtItem).stmt.Close()
+	}
+} // }}}
+
+func (c *stmtCache) stats() StmtCacheStats { // {{{
+	c.mutex.Lock()
+	size := c.ll.Len()
+	c.mutex.Unlock()
+
+	return StmtCacheStats{
+		Size:       size,
+		Capacity:   c.capacity,
+		Hits:       c.hits.Load(),
+		Misses:     c.misses.Load(),
+		Evictions:  c.evictions.Load(),
+		Reprepares: c.reprepares.Load(),
+		Errors:     c.errors.Load(),
+	}
+} // }}}
+
+// 设置预处理语句缓存的容量, <= 0 时关闭缓存; 须在 SetDB 之后、执行 sql 之前调用
+func (s *SqlClient) SetStmtCacheSize(size int) { //{{{
+	if s.stmts != nil {
+		s.stmts.close()
+		s.stmts = nil
+	}
+
+	if size > 0 {
+		s.stmts = newStmtCache(size)
+	}
+} // }}}
+
+// 预处理语句缓存的统计, 未启用时返回 nil
+func (s *SqlClient) StmtCacheStats() *StmtCacheStats { //{{{
+	if s.stmts == nil {
+		return nil
+	}
+
+	stats := s.stmts.stats()
+	return &stats
+} // }}}
+
+// 取得 query 对应的缓存语句, 未启用缓存、无参数或预处理失败时返回 nil
+// 返回值为连接池上的语句及实际执行的语句(事务中为绑定到事务连接的语句)
+func (s *SqlClient) preparedStmt(ctx context.Context, query string, args []any) (*sql.Stmt, *sql.Stmt) { //{{{
+	if s.stmts == nil || len(args) == 0 {
+		return nil, nil
+	}
+
+	stmt, err := s.stmts.get(ctx, s.db, query)
+	if err != nil {
+		return nil, nil
+	}
+
+	if s.intx {
+		return stmt, s.tx.StmtContext(ctx, stmt)
+	}
+
+	return stmt, stmt
+} // }}}
+
+// 使用缓存的语句执行 fn, 语句失效时移出缓存并重新预处理一次; 不使用缓存时以 nil 调用 fn
+func withStmt[T any](s *SqlClient, ctx context.Context, query string, args []any, fn func(stmt *sql.Stmt) (T, error)) (T, error) { //{{{
+	base, stmt := s.preparedStmt(ctx, query, args)
+	if stmt == nil {
+		return fn(nil)
+	}
+
+	res, err := fn(stmt)
+	if !isStmtInvalid(err) {
+		return res, err
+	}
+
+	s.stmts.remove(query, base)
+	s.stmts.reprepares.Add(1)
+
+	_, stmt = s.preparedStmt(ctx, query, args)
+	return fn(stmt)
+} // }}}
+
+// 服务端语句失效的错误, 重新预处理后可以执行
+func isStmtInvalid(err error) bool { // {{{
+	if err == nil {
+		return false
+	}
+
+	var me *mysql.MySQLError
+	if errors.As(err, &me) {
+		// 1243: Unknown prepared statement handler; 1615: Prepared statement needs to be re-prepared
+		return me.Number == 1243 || me.Number == 1615
+	}
+
+	msg := err.Error()
+
+	return strings.Contains(msg, "sql: statement is closed") ||
+		strings.Contains(msg, "cached plan must not change result type") ||
+		(strings.Contains(msg, "prepared statement") && strings.Contains(msg, "does not exist"))
+} // }}}
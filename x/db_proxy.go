@@ -2,11 +2,17 @@ package x
 
 import (
 	"context"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/nyxless/nyx/x/db"
 	"golang.org/x/sync/singleflight"
+	"net/url"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -17,17 +23,57 @@ import (
 
 func NewDBProxy() *DBProxy {
 	return &DBProxy{
-		c:  make(map[string]db.DBClient),
-		sf: &singleflight.Group{},
+		c:        make(map[string]db.DBClient),
+		pools:    make(map[string]*dbPool),
+		names:    make(map[string]string),
+		replicas: make(map[string]*ReplicaPool),
+		sf:       &singleflight.Group{},
 	}
 }
 
+// 连接池以 DSN 及连接池选项的 HMAC(进程内随机密钥)区分, 指向同一 host 的不同库或用户使用不同的连接池, 配置完全相同时共用
 type DBProxy struct {
-	mutex sync.RWMutex
-	c     map[string]db.DBClient
-	sf    *singleflight.Group
+	mutex    sync.RWMutex
+	c        map[string]db.DBClient
+	pools    map[string]*dbPool
+	names    map[string]string // 配置名 -> 连接池 key
+	replicas map[string]*ReplicaPool
+	sf       *singleflight.Group
 }
 
+// 连接池信息, 用于状态展示
+type dbPool struct {
+	dbType   string
+	host     string
+	database string
+}
+
+// 连接池状态
+type DBPoolStats struct {
+	Key               string             `json:"key"`
+	Names             []string           `json:"names,omitempty"`
+	ID                string             `json:"id"`
+	Type              string             `json:"type"`
+	Host              string             `json:"host"`
+	Database          string             `json:"database"`
+	MaxOpen           int                `json:"max_open"`
+	Open              int                `json:"open"`
+	InUse             int                `json:"in_use"`
+	Idle              int                `json:"idle"`
+	WaitCount         int64              `json:"wait_count"`
+	WaitDuration      float64            `json:"wait_duration_ms"`
+	MaxIdleClosed     int64              `json:"max_idle_closed"`
+	MaxIdleTimeClosed int64              `json:"max_idle_time_closed"`
+	MaxLifetimeClosed int64              `json:"max_lifetime_closed"`
+	StmtCache         *db.StmtCacheStats `json:"stmt_cache,omitempty"`
+}
+
+// 重建或关闭连接池时, 旧连接池延迟关闭的时间, 使已取得旧连接的请求执行完成
+var DBCloseDelay = 30 * time.Second
+
+// 参与连接池 key 计算的选项
+var dbPoolOptions = []string{"max_open_conns", "max_idle_conns", "conn_max_idle_time", "conn_max_lifetime", "query_timeout", "slow_query", "stmt_cache_size", "debug"}
+
 type SqlDsn func(MAP) string
 
 type NewDBFunc func() db.DBClient
@@ -52,17 +98,74 @@ var dsnFuncs = map[string]SqlDsn{
 
 		return fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=%s&parseTime=true&loc=%s", user, password, host, database, charset, TIME_ZONE)
 	},
+	// 需在项目中引入 driver, 如: _ "github.com/lib/pq"
+	"postgres": postgresDsn,
+	"pgx":      postgresDsn,
+	// 需在项目中引入 driver, 如: _ "github.com/mattn/go-sqlite3", database 为数据库文件路径
+	"sqlite3": sqliteDsn,
+	"sqlite":  sqliteDsn,
 }
 
+func postgresDsn(conf MAP) string { // {{{
+	u := &url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(AsString(conf["user"]), AsString(conf["password"])),
+		Host:   AsString(conf["host"]),
+		Path:   "/" + AsString(conf["database"]),
+	}
+
+	q := url.Values{}
+	q.Set("sslmode", AsString(conf["sslmode"], "disable"))
+	if TIME_ZONE != "Local" {
+		q.Set("TimeZone", TIME_ZONE)
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String()
+} // }}}
+
+func sqliteDsn(conf MAP) string { // {{{
+	return AsString(conf["database"])
+} // }}}
+
 func (d *DBProxy) Get(conf MAP) (db.DBClient, error) { // {{{
-	host, ok := conf["host"]
-	if !ok {
-		return nil, fmt.Errorf("DB 配置有误")
+	client, _, err := d.get(conf)
+	return client, err
+} // }}}
+
+// 按配置名取得连接池, 如: x.DB.Named("db_master")
+func (d *DBProxy) Named(name string) (db.DBClient, error) { // {{{
+	conf := Conf.GetMap(name)
+	if 0 == len(conf) {
+		return nil, fmt.Errorf("db资源不存在: %s", name)
+	}
+
+	client, key, err := d.get(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mutex.RLock()
+	cur := d.names[name]
+	d.mutex.RUnlock()
+
+	if cur != key {
+		d.mutex.Lock()
+		d.names[name] = key
+		d.mutex.Unlock()
+	}
+
+	return client, nil
+} // }}}
+
+func (d *DBProxy) get(conf MAP) (db.DBClient, string, error) { // {{{
+	dbt, dsn, key, err := d.resolve(conf)
+	if err != nil {
+		return nil, "", err
 	}
 
-	key := AsString(host)
 	if client := d.getClient(key); client != nil {
-		return client, nil
+		return client, key, nil
 	}
 
 	result, err, _ := d.sf.Do(key, func() (interface{}, error) {
@@ -72,15 +175,56 @@ func (d *DBProxy) Get(conf MAP) (db.DBClient, error) { // {{{
 		}
 
 		// 创建新连接
-		return d.add(conf, key)
+		return d.add(conf, key, dbt, dsn)
 	})
 
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+
+	return result.(db.DBClient), key, nil
+} // }}}
+
+// 解析 db 类型、DSN 及连接池 key
+func (d *DBProxy) resolve(conf MAP) (string, string, string, error) { // {{{
+	_, has_host := conf["host"]
+	_, has_database := conf["database"] //sqlite 无 host, 使用数据库文件路径
+	if !has_host && !has_database {
+		return "", "", "", fmt.Errorf("DB 配置有误")
+	}
+
+	dbt := strings.ToLower(AsString(conf["type"]))
+
+	dsnfunc, ok := dsnFuncs[dbt]
+	if !ok {
+		return "", "", "", fmt.Errorf("不支持的db类型: %s", dbt)
+	}
+
+	dsn := dsnfunc(conf)
+
+	parts := []string{dbt, dsn}
+	for _, opt := range dbPoolOptions {
+		parts = append(parts, opt+"="+AsString(conf[opt]))
+	}
+
+	return dbt, dsn, poolKey(parts), nil
+} // }}}
+
+// 连接池 key 的 HMAC 密钥, 每个进程随机生成; DSN 中包含密码, key 会出现在日志及 /debug/db 中, 不能使用可离线穷举的无密钥哈希
+var poolKeySecret = func() []byte { // {{{
+	secret := make([]byte, 32)
+	if _, err := crand.Read(secret); err != nil {
+		panic(err)
 	}
 
-	return result.(db.DBClient), nil
+	return secret
+}() // }}}
+
+func poolKey(parts []string) string { // {{{
+	mac := hmac.New(sha256.New, poolKeySecret)
+	mac.Write([]byte(strings.Join(parts, "\x00")))
 
+	return hex.EncodeToString(mac.Sum(nil)[:8])
 } // }}}
 
 func (d *DBProxy) getClient(key string) db.DBClient { // {{{
@@ -95,10 +239,9 @@ func (d *DBProxy) getClient(key string) db.DBClient { // {{{
 	return client
 } // }}}
 
-func (d *DBProxy) add(conf MAP, key string) (db.DBClient, error) { // {{{
+func (d *DBProxy) add(conf MAP, key, dbt, dsn string) (db.DBClient, error) { // {{{
 	var err error
 	var debug bool
-	var dsn string
 
 	if Debug { //全局 debug 开关, 启动时 -d
 		debug = true
@@ -110,14 +253,9 @@ func (d *DBProxy) add(conf MAP, key string) (db.DBClient, error) { // {{{
 	max_idle_conns := AsInt(conf["max_idle_conns"])
 	conn_max_idle_time := AsInt(conf["conn_max_idle_time"])
 	conn_max_lifetime := AsInt(conf["conn_max_lifetime"])
-
-	dbt := strings.ToLower(AsString(conf["type"]))
-
-	if dsnfunc, ok := dsnFuncs[dbt]; ok {
-		dsn = dsnfunc(conf)
-	} else {
-		return nil, fmt.Errorf("不支持的db类型: %s", dbt)
-	}
+	query_timeout := AsInt(conf["query_timeout"])     //单条 sql 超时时间, 单位毫秒
+	slow_query := AsInt(conf["slow_query"])           //慢查询阈值, 单位毫秒, 覆盖 sql_log 中的配置
+	stmt_cache_size := AsInt(conf["stmt_cache_size"]) //预处理语句缓存数量, 为 0 时不缓存
 
 	var _db *sql.DB
 	_db, err = sql.Open(dbt, dsn)
@@ -145,6 +283,18 @@ func (d *DBProxy) add(conf MAP, key string) (db.DBClient, error) { // {{{
 	client.SetDB(dbt, _db)
 	client.SetDebug(debug)
 
+	if query_timeout > 0 {
+		client.SetTimeout(time.Duration(query_timeout) * time.Millisecond)
+	}
+
+	if slow_query > 0 {
+		client.SetSlowQuery(time.Duration(slow_query) * time.Millisecond)
+	}
+
+	if stmt_cache_size > 0 {
+		client.SetStmtCacheSize(stmt_cache_size)
+	}
+
 	// 测试连接
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	err = client.Ping(ctx)
@@ -157,19 +307,297 @@ func (d *DBProxy) add(conf MAP, key string) (db.DBClient, error) { // {{{
 
 	d.mutex.Lock()
 	d.c[key] = client
+	d.pools[key] = &dbPool{
+		dbType:   dbt,
+		host:     AsString(conf["host"]),
+		database: AsString(conf["database"]),
+	}
 	d.mutex.Unlock()
 
-	Info("Add DBProxy:", fmt.Sprintf(" host [ %s ] type [ %s ] db [ %s ] debug [ %v ] #ID [ %s ]", conf["host"], dbt, conf["database"], debug, client.ID()))
+	Info("Add DBProxy:", fmt.Sprintf(" host [ %s ] type [ %s ] db [ %s ] debug [ %v ] #ID [ %s ] key [ %s ]", conf["host"], dbt, conf["database"], debug, client.ID(), key))
 
 	return client, nil
 } // }}}
 
+// 获取主库对应的从库池, 相同主从配置共用一个, 创建时检查一次从库状态并启动定期检查
+func (d *DBProxy) GetReplicaPool(master_conf MAP, slave_confs []MAP) (*ReplicaPool, error) { // {{{
+	master, master_key, err := d.get(master_conf)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(slave_confs)+1)
+	keys = append(keys, master_key)
+	for _, conf := range slave_confs {
+		_, _, slave_key, err := d.resolve(conf)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, slave_key)
+	}
+	key := strings.Join(keys, ",")
+
+	d.mutex.RLock()
+	pool, ok := d.replicas[key]
+	d.mutex.RUnlock()
+
+	if ok {
+		return pool, nil
+	}
+
+	result, _, _ := d.sf.Do("replica:"+key, func() (interface{}, error) {
+		d.mutex.RLock()
+		pool, ok := d.replicas[key]
+		d.mutex.RUnlock()
+
+		if ok {
+			return pool, nil
+		}
+
+		pool = newReplicaPool(master, slave_confs)
+		pool.host = AsString(master_conf["host"], AsString(master_conf["database"]))
+		pool.start(d)
+
+		d.mutex.Lock()
+		d.replicas[key] = pool
+		d.mutex.Unlock()
+
+		return pool, nil
+	})
+
+	return result.(*ReplicaPool), nil
+} // }}}
+
+// 所有从库池的状态, key 为主库 host
+func (d *DBProxy) ReplicaStatus() map[string][]*ReplicaStatus { // {{{
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	status := make(map[string][]*ReplicaStatus, len(d.replicas))
+	for _, pool := range d.replicas {
+		status[pool.host] = append(status[pool.host], pool.Status()...)
+	}
+
+	return status
+} // }}}
+
+// 检查所有已创建的连接池, 返回第一个失败的错误
+func (d *DBProxy) Ping(ctx context.Context) error { // {{{
+	d.mutex.RLock()
+	clients := make(map[string]db.DBClient, len(d.c))
+	for key, client := range d.c {
+		clients[key] = client
+	}
+	d.mutex.RUnlock()
+
+	for key, client := range clients {
+		if err := client.Ping(ctx); err != nil {
+			return fmt.Errorf("db [%s] ping error: %v", d.poolHost(key), err)
+		}
+	}
+
+	return nil
+} // }}}
+
+// 按配置名检查 db 连接, 连接池不存在时创建; 配置可以是单个 db 配置或从库列表, 不含 type 的配置跳过
+// 之后检查所有其他已创建的连接池
+func (d *DBProxy) PingNamed(ctx context.Context, names ...string) error { // {{{
+	for _, name := range names {
+		// 单个 db 配置使用 Named, 同时记录配置名与连接池的对应关系
+		if conf := Conf.GetMap(name); len(conf) > 0 {
+			if _, ok := conf["type"]; !ok {
+				continue
+			}
+
+			client, err := d.Named(name)
+			if err != nil {
+				return fmt.Errorf("db [%s] %v", name, err)
+			}
+
+			if err := client.Ping(ctx); err != nil {
+				return fmt.Errorf("db [%s] ping error: %v", name, err)
+			}
+
+			continue
+		}
+
+		for _, conf := range Conf.GetMapSlice(name) {
+			if _, ok := conf["type"]; !ok {
+				continue
+			}
+
+			client, _, err := d.get(conf)
+			if err != nil {
+				return fmt.Errorf("db [%s] %v", name, err)
+			}
+
+			if err := client.Ping(ctx); err != nil {
+				return fmt.Errorf("db [%s] ping error: %v", name, err)
+			}
+		}
+	}
+
+	return d.Ping(ctx)
+} // }}}
+
+// 所有连接池的状态, 按 host、database 排序
+func (d *DBProxy) Stats() []*DBPoolStats { // {{{
+	d.mutex.RLock()
+	list := make([]*DBPoolStats, 0, len(d.c))
+	for key, client := range d.c {
+		st := &DBPoolStats{Key: key, ID: client.ID()}
+		if pool, ok := d.pools[key]; ok {
+			st.Type, st.Host, st.Database = pool.dbType, pool.host, pool.database
+		}
+
+		for name, k := range d.names {
+			if k == key {
+				st.Names = append(st.Names, name)
+			}
+		}
+		sort.Strings(st.Names)
+
+		stats := client.Stats()
+		st.MaxOpen = stats.MaxOpenConnections
+		st.Open = stats.OpenConnections
+		st.InUse = stats.InUse
+		st.Idle = stats.Idle
+		st.WaitCount = stats.WaitCount
+		st.WaitDuration = float64(stats.WaitDuration.Microseconds()) / 1000
+		st.MaxIdleClosed = stats.MaxIdleClosed
+		st.MaxIdleTimeClosed = stats.MaxIdleTimeClosed
+		st.MaxLifetimeClosed = stats.MaxLifetimeClosed
+
+		if c, ok := client.(interface{ StmtCacheStats() *db.StmtCacheStats }); ok {
+			st.StmtCache = c.StmtCacheStats()
+		}
+
+		list = append(list, st)
+	}
+	d.mutex.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Host != list[j].Host {
+			return list[i].Host < list[j].Host
+		}
+
+		return list[i].Database < list[j].Database
+	})
+
+	return list
+} // }}}
+
+// 按当前配置重建命名的连接池, 配置未变化时返回原连接池
+// 旧连接池不再被其他配置名使用时, 延迟 DBCloseDelay 后关闭; 已取得旧连接池的 Dao 在关闭前仍可使用
+func (d *DBProxy) Reload(name string) (db.DBClient, error) { // {{{
+	d.mutex.RLock()
+	old, ok := d.names[name]
+	d.mutex.RUnlock()
+
+	client, err := d.Named(name)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mutex.RLock()
+	key := d.names[name]
+	d.mutex.RUnlock()
+
+	if ok && old != key {
+		Info("Reload DBProxy:", fmt.Sprintf(" name [ %s ] key [ %s ] -> [ %s ]", name, old, key))
+		d.release(old, false)
+	}
+
+	return client, nil
+} // }}}
+
+// 关闭命名的连接池, 下次 Get 或 Named 时重新创建; 指向同一连接池的其他配置名同时失效
+func (d *DBProxy) CloseNamed(name string) { // {{{
+	d.mutex.RLock()
+	key, ok := d.names[name]
+	d.mutex.RUnlock()
+
+	if ok {
+		d.release(key, true)
+	}
+} // }}}
+
+// 移除连接池并延迟关闭, force 为 false 时连接池仍被其他配置名使用则不处理
+// 以其为主库的从库池一并移除, 以其为从库的从库池在下次检查时重新连接
+func (d *DBProxy) release(key string, force bool) { // {{{
+	d.mutex.Lock()
+
+	for _, k := range d.names {
+		if k == key && !force {
+			d.mutex.Unlock()
+			return
+		}
+	}
+
+	for name, k := range d.names {
+		if k == key {
+			delete(d.names, name)
+		}
+	}
+
+	client, ok := d.c[key]
+	delete(d.c, key)
+	delete(d.pools, key)
+
+	var pools []*ReplicaPool
+	if ok {
+		for k, pool := range d.replicas {
+			if pool.master == client {
+				delete(d.replicas, k)
+				pools = append(pools, pool)
+			}
+		}
+	}
+	d.mutex.Unlock()
+
+	if !ok {
+		return
+	}
+
+	for _, pool := range pools {
+		pool.Close()
+	}
+
+	d.mutex.RLock()
+	for _, pool := range d.replicas {
+		pool.detach(client)
+	}
+	d.mutex.RUnlock()
+
+	time.AfterFunc(DBCloseDelay, client.Close)
+} // }}}
+
+func (d *DBProxy) poolHost(key string) string { // {{{
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	if pool, ok := d.pools[key]; ok && pool.host != "" {
+		return pool.host
+	} else if ok {
+		return pool.database
+	}
+
+	return key
+} // }}}
+
 func (d *DBProxy) Close() { // {{{
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 
+	for _, pool := range d.replicas {
+		pool.Close()
+	}
+	d.replicas = make(map[string]*ReplicaPool)
+
 	for _, client := range d.c {
 		client.Close()
 	}
 	d.c = make(map[string]db.DBClient)
+	d.pools = make(map[string]*dbPool)
+	d.names = make(map[string]string)
 } // }}}
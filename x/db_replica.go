@@ -0,0 +1,292 @@
+package x
+
+import (
+	"context"
+	"fmt"
+	"github.com/nyxless/nyx/x/db"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 从库池: 按权重选择健康的从库, 定期检查从库状态, 连续失败或复制延迟过大时摘除, 恢复后重新加入
+// 没有可用从库时使用主库
+//
+// 配置:
+//
+//	db_slave:
+//	  - host: 127.0.0.1:3307
+//	    weight: 2            # 权重, 默认 1
+//	    ...
+//	db_replica:
+//	  check_interval: 5      # 检查间隔, 单位秒, 默认 5
+//	  fail_threshold: 3      # 连续失败次数达到后摘除, 默认 3
+//	  max_lag: 0             # 最大复制延迟, 单位秒, 超过时摘除, 默认 0 不检查
+//	  lag_query: ""          # 查询复制延迟(秒)的 sql, 默认按 DB 类型: mysql SHOW REPLICA STATUS, postgres 已回放全部 WAL 时为 0, 否则为 pg_last_xact_replay_timestamp() 至今的时间
+type ReplicaPool struct {
+	host          string // 主库 host, 用于状态展示
+	master        db.DBClient
+	replicas      []*Replica
+	interval      time.Duration
+	failThreshold int
+	maxLag        time.Duration
+	lagQuery      string
+	stop          chan struct{}
+	stopOnce      sync.Once
+}
+
+type Replica struct {
+	mutex   sync.RWMutex
+	conf    MAP
+	host    string
+	weight  int
+	client  db.DBClient // 启动时连接失败为 nil, 检查时重新连接
+	healthy bool
+	fails   int
+	lag     time.Duration
+	err     error
+}
+
+// 从库状态
+type ReplicaStatus struct {
+	Host    string `json:"host"`
+	Weight  int    `json:"weight"`
+	Healthy bool   `json:"healthy"`
+	Lag     string `json:"lag"`
+	Error   string `json:"error,omitempty"`
+}
+
+func newReplicaPool(master db.DBClient, slave_confs []MAP) *ReplicaPool { // {{{
+	p := &ReplicaPool{
+		master:        master,
+		interval:      5 * time.Second,
+		failThreshold: 3,
+		stop:          make(chan struct{}),
+	}
+
+	if Conf != nil {
+		p.interval = time.Duration(Conf.GetDefInt(5, "db_replica", "check_interval")) * time.Second
+		p.failThreshold = Conf.GetDefInt(3, "db_replica", "fail_threshold")
+		p.maxLag = time.Duration(Conf.GetDefInt(0, "db_replica", "max_lag")) * time.Second
+		p.lagQuery = Conf.GetString("db_replica", "lag_query")
+	}
+
+	for _, conf := range slave_confs {
+		p.replicas = append(p.replicas, &Replica{
+			conf:   conf,
+			host:   AsString(conf["host"], AsString(conf["database"])),
+			weight: max(AsInt(conf["weight"], 1), 0),
+		})
+	}
+
+	return p
+} // }}}
+
+// 按权重选择一个健康的从库, 没有时返回主库
+func (p *ReplicaPool) Pick() db.DBClient { // {{{
+	var candidates []*Replica
+	var clients []db.DBClient
+	total := 0
+
+	for _, r := range p.replicas {
+		r.mutex.RLock()
+		if r.healthy && r.client != nil && r.weight > 0 {
+			candidates = append(candidates, r)
+			clients = append(clients, r.client)
+			total += r.weight
+		}
+		r.mutex.RUnlock()
+	}
+
+	if total == 0 {
+		return p.master
+	}
+
+	n := RandIntn(total)
+	for i, r := range candidates {
+		if n < r.weight {
+			return clients[i]
+		}
+		n -= r.weight
+	}
+
+	return clients[len(clients)-1]
+} // }}}
+
+func (p *ReplicaPool) Master() db.DBClient { // {{{
+	return p.master
+} // }}}
+
+func (p *ReplicaPool) Status() []*ReplicaStatus { // {{{
+	list := make([]*ReplicaStatus, 0, len(p.replicas))
+	for _, r := range p.replicas {
+		r.mutex.RLock()
+		st := &ReplicaStatus{
+			Host:    r.host,
+			Weight:  r.weight,
+			Healthy: r.healthy,
+			Lag:     r.lag.String(),
+		}
+		if r.err != nil {
+			st.Error = r.err.Error()
+		}
+		r.mutex.RUnlock()
+
+		list = append(list, st)
+	}
+
+	return list
+} // }}}
+
+// 首次检查后启动定期检查
+func (p *ReplicaPool) start(proxy *DBProxy) { // {{{
+	p.checkAll(proxy)
+
+	if p.interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.checkAll(proxy)
+			}
+		}
+	}()
+} // }}}
+
+// 移除已关闭的从库连接, 下次检查时重新连接
+func (p *ReplicaPool) detach(client db.DBClient) { // {{{
+	for _, r := range p.replicas {
+		r.mutex.Lock()
+		if r.client == client {
+			r.client = nil
+			r.healthy = false
+		}
+		r.mutex.Unlock()
+	}
+} // }}}
+
+func (p *ReplicaPool) Close() { // {{{
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+} // }}}
+
+func (p *ReplicaPool) checkAll(proxy *DBProxy) { // {{{
+	var wg sync.WaitGroup
+	for _, r := range p.replicas {
+		wg.Add(1)
+		go func(r *Replica) {
+			defer wg.Done()
+			p.check(proxy, r)
+		}(r)
+	}
+	wg.Wait()
+} // }}}
+
+func (p *ReplicaPool) check(proxy *DBProxy, r *Replica) { // {{{
+	r.mutex.RLock()
+	client := r.client
+	r.mutex.RUnlock()
+
+	var err error
+	var lag time.Duration
+
+	if client == nil {
+		client, err = proxy.Get(r.conf)
+	}
+
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		err = client.Ping(ctx)
+		if err == nil && p.maxLag > 0 {
+			lag, err = p.probeLag(ctx, client)
+			if err == nil && lag > p.maxLag {
+				err = fmt.Errorf("replication lag %v exceeds %v", lag, p.maxLag)
+			}
+		}
+		cancel()
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if client != nil {
+		r.client = client
+	}
+
+	r.lag = lag
+	r.err = err
+
+	if err == nil {
+		if !r.healthy {
+			Info("DB Replica Up:", fmt.Sprintf(" host [ %s ]", r.host))
+		}
+
+		r.fails = 0
+		r.healthy = true
+		return
+	}
+
+	r.fails++
+
+	// 延迟过大立即摘除, 连接失败达到阈值后摘除
+	if r.healthy && (lag > p.maxLag && p.maxLag > 0 || r.fails >= p.failThreshold) {
+		r.healthy = false
+		Warn("DB Replica Down:", fmt.Sprintf(" host [ %s ] error [ %v ]", r.host, err))
+	}
+} // }}}
+
+// 查询复制延迟
+func (p *ReplicaPool) probeLag(ctx context.Context, client db.DBClient) (time.Duration, error) { // {{{
+	query := p.lagQuery
+	if query == "" {
+		switch client.Dialect().Name() {
+		case "mysql":
+			return mysqlLag(ctx, client)
+		case "postgres":
+			// 已回放全部收到的 WAL 时延迟为 0, 否则主库空闲期间 now() - 最后回放时间会持续增长
+			query = "SELECT CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0 ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) END"
+		default:
+			return 0, nil
+		}
+	}
+
+	val, err := client.QueryOneContext(ctx, db.WithSql(query, nil))
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(AsFloat64(val) * float64(time.Second)), nil
+} // }}}
+
+// mysql 8.0.22 之后使用 SHOW REPLICA STATUS, 之前版本使用 SHOW SLAVE STATUS
+func mysqlLag(ctx context.Context, client db.DBClient) (time.Duration, error) { // {{{
+	row, err := client.QueryRowContext(ctx, db.WithSql("SHOW REPLICA STATUS", nil))
+	if err != nil && strings.Contains(err.Error(), "syntax") {
+		row, err = client.QueryRowContext(ctx, db.WithSql("SHOW SLAVE STATUS", nil))
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	val, ok := row["Seconds_Behind_Source"]
+	if !ok {
+		val = row["Seconds_Behind_Master"]
+	}
+
+	// 复制未运行时为 NULL
+	if val == nil || AsString(val) == "" {
+		return 0, fmt.Errorf("replication is not running")
+	}
+
+	return time.Duration(AsInt64(val)) * time.Second, nil
+} // }}}
@@ -54,6 +54,13 @@ var (
 
 var closeFlag int32
 
+// 是否已开始优雅退出(重启时的父进程或收到退出信号), 用于健康检查
+var shuttingDown atomic.Bool
+
+func IsShuttingDown() bool { // {{{
+	return shuttingDown.Load()
+} // }}}
+
 func init() { // {{{
 	runningServerReg = sync.RWMutex{}
 	runningServers = make(map[string]*endlessServer)
@@ -431,6 +438,7 @@ func (srv *endlessServer) shutdown() { // {{{
 	}
 
 	srv.setState(STATE_SHUTTING_DOWN)
+	shuttingDown.Store(true)
 	if DefaultHammerTime >= 0 {
 		go srv.hammerTime(DefaultHammerTime)
 	}
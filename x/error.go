@@ -2,6 +2,7 @@ package x
 
 import (
 	"fmt"
+	"runtime/debug"
 	"strings"
 	"sync"
 )
@@ -22,6 +23,7 @@ var (
 	ErrParams        = NewErr(13, "CN", "参数错误: %+v", "EN", "Invalid param: %+v")
 	ErrAuth          = NewErr(14, "CN", "认证失败", "EN", "Request unauthorized")
 	ErrNoRows        = NewErr(15, "CN", "数据不存在", "EN", "No record") //对应 sql.ErrNoRows = errors.New("sql: no rows in result set")
+	ErrTenant        = NewErr(16, "CN", "租户无效", "EN", "Invalid tenant")
 
 	ErrMap   = map[int32]MAPS{}
 	ErrMapRo = map[int32]MAPS{} //只读MAP
@@ -53,75 +55,141 @@ func NewErr(code int32, msgs ...string) *Error { // {{{
 	return &Error{code: code, msg: msgMap}
 } // }}}
 
+// 错误定义及实例: NewErr 创建的是错误定义, With/WithData/Wrap 返回新的实例, 不修改定义本身, 可并发使用
 type Error struct {
-	code int32
-	msg  MAPS
-	fmt  []any
-	data MAP
+	code  int32
+	msg   MAPS
+	fmt   []any
+	data  MAP
+	cause error  // 原始错误
+	stack []byte // 调用栈, 仅系统错误记录
 }
 
 func (e *Error) GetCode() int32 {
 	return e.code
 }
 
-func (e *Error) GetMessage(langs ...string) string { // {{{
-	if len(e.fmt) > 0 {
-		//fmts的可用值为string, 若fmts最后一个值为map, 则认为它是异常时返回的data
-		if data, ok := e.fmt[len(e.fmt)-1].(MAP); ok {
-			e.fmt = e.fmt[0 : len(e.fmt)-1]
-			e.data = data
+// 复制一个新实例, 系统错误时记录调用栈
+func (e *Error) clone() *Error { // {{{
+	ne := &Error{
+		code:  e.code,
+		msg:   e.msg,
+		fmt:   e.fmt,
+		data:  e.data,
+		cause: e.cause,
+		stack: e.stack,
+	}
+
+	if ne.stack == nil && ErrSystem != nil && e.code == ErrSystem.code {
+		ne.stack = debug.Stack()
+	}
+
+	return ne
+} // }}}
+
+// 返回使用指定格式化参数的新实例, 若最后一个参数类型为 MAP, 则作为返回的 data
+func (e *Error) With(args ...any) *Error { // {{{
+	ne := e.clone()
+
+	if len(args) > 0 {
+		if data, ok := args[len(args)-1].(MAP); ok {
+			ne.data = data
+			args = args[:len(args)-1]
 		}
 	}
 
-	var lang, msg string
+	ne.fmt = args
+
+	return ne
+} // }}}
+
+// 返回附带 data 的新实例
+func (e *Error) WithData(data MAP) *Error { // {{{
+	ne := e.clone()
+	ne.data = data
+
+	return ne
+} // }}}
+
+// 返回包装了原始错误的新实例, 可通过 errors.Unwrap 获取原始错误
+func (e *Error) Wrap(cause error) *Error { // {{{
+	ne := e.clone()
+	ne.cause = cause
 
-	if len(langs) > 0 {
+	return ne
+} // }}}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// 按错误码匹配, 支持 errors.Is(err, x.ErrParams)
+func (e *Error) Is(target error) bool { // {{{
+	t, ok := target.(*Error)
+	return ok && t != nil && t.code == e.code
+} // }}}
+
+func (e *Error) Cause() error {
+	return e.cause
+}
+
+// 系统错误的调用栈
+func (e *Error) Stack() []byte {
+	return e.stack
+}
+
+// 按语言回退链查找错误信息: 配置文件 err_msg -> 错误信息文件 -> 代码定义
+func (e *Error) GetMessage(langs ...string) string { // {{{
+	lang := DefaultLang
+	if len(langs) > 0 && langs[0] != "" {
 		lang = langs[0]
-	} else {
-		lang = DefaultLang
 	}
 
-	errMsgs, ok := ErrMapRo[e.code]
-	if ok {
-		msg, ok = errMsgs[lang]
-	}
+	var msg string
+	var found bool
 
-	if !ok {
-		msg, ok = e.msg[lang]
+	for _, l := range LangChain(lang) {
+		if errMsgs, ok := ErrMapRo[e.code]; ok {
+			if msg, found = errMsgs[l]; found {
+				break
+			}
+		}
+
+		if m, ok := getErrBundle(l, e.code); ok {
+			msg, found = m.pick(e.data), true
+			break
+		}
 
-		if !ok && lang != DefaultLang {
-			msg = e.msg[DefaultLang]
+		if msg, found = e.msg[l]; found {
+			break
 		}
 	}
 
 	if len(e.fmt) > 0 {
-		return fmt.Sprintf(msg, e.fmt)
+		msg = fmt.Sprintf(msg, e.fmt...)
 	}
 
-	return msg
+	return replacePlaceholders(msg, e.data)
 } // }}}
 
-// 从 fmt 参数中提取 data
-func (e *Error) GetData() MAP { // {{{
-	if e.data == nil && len(e.fmt) > 0 {
-		if data, ok := e.fmt[len(e.fmt)-1].(MAP); ok {
-			return data
-		}
-	}
-
+func (e *Error) GetData() MAP {
 	return e.data
-} // }}}
+}
+
+// 包含原始错误信息, 便于日志记录
+func (e *Error) Error() string { // {{{
+	if e.cause != nil {
+		return e.GetMessage() + ": " + e.cause.Error()
+	}
 
-func (e *Error) Error() string {
 	return e.GetMessage()
-}
+} // }}}
 
 // 捕获异常时，可同时返回data(通过fmts参数最后一个类型为map的值)
 func Interceptor(guard bool, errmsg any, fmts ...any) { // {{{
 	if !guard {
 		if v, ok := errmsg.(*Error); ok {
-			v.fmt = fmts
-			panic(v)
+			panic(v.With(fmts...))
 		}
 
 		panic(errmsg)
@@ -0,0 +1,294 @@
+package x
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/nyxless/nyx/x/endless"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	DefaultHealthTimeout  = time.Second     // 单项检查默认超时
+	DefaultHealthCacheTTL = 2 * time.Second // 检查结果默认缓存时间
+)
+
+// 健康检查函数, 返回 nil 表示正常
+type HealthChecker func(ctx context.Context) error
+
+// 健康检查项
+type HealthCheck struct {
+	Name     string
+	Check    HealthChecker
+	Timeout  time.Duration // 超时时间, 默认 DefaultHealthTimeout
+	CacheTTL time.Duration // 结果缓存时间, 默认 DefaultHealthCacheTTL, 小于 0 时不缓存
+	Liveness bool          // 是否参与存活检查(/livez), 默认只参与就绪检查(/readyz)
+
+	mu     sync.Mutex
+	result *HealthResult
+}
+
+// 单项检查结果
+type HealthResult struct {
+	Name      string    `json:"name"`
+	Ok        bool      `json:"ok"`
+	Error     string    `json:"error,omitempty"`
+	Duration  float64   `json:"duration_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// 整体检查结果
+type HealthReport struct {
+	Ok     bool            `json:"ok"`
+	Checks []*HealthResult `json:"checks"`
+}
+
+// 健康检查注册表
+type HealthRegistry struct {
+	mu     sync.RWMutex
+	checks map[string]*HealthCheck
+}
+
+// 全局健康检查注册表, 项目中可通过 x.Health.Register 添加自定义检查
+var Health = NewHealthRegistry()
+
+func NewHealthRegistry() *HealthRegistry { // {{{
+	return &HealthRegistry{
+		checks: map[string]*HealthCheck{},
+	}
+} // }}}
+
+// 注册检查项, 同名覆盖
+func (h *HealthRegistry) Register(check *HealthCheck) { // {{{
+	if check.Timeout <= 0 {
+		check.Timeout = DefaultHealthTimeout
+	}
+
+	if check.CacheTTL == 0 {
+		check.CacheTTL = DefaultHealthCacheTTL
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.checks[check.Name] = check
+} // }}}
+
+// 注册就绪检查项的简便方法
+func (h *HealthRegistry) RegisterFunc(name string, fn HealthChecker) { // {{{
+	h.Register(&HealthCheck{Name: name, Check: fn})
+} // }}}
+
+func (h *HealthRegistry) Unregister(name string) { // {{{
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.checks, name)
+} // }}}
+
+// 执行检查, liveness 为 true 时只执行存活检查项; 就绪检查在优雅退出期间直接返回失败
+func (h *HealthRegistry) Check(ctx context.Context, liveness bool) *HealthReport { // {{{
+	h.mu.RLock()
+	checks := make([]*HealthCheck, 0, len(h.checks))
+	for _, check := range h.checks {
+		if !liveness || check.Liveness {
+			checks = append(checks, check)
+		}
+	}
+	h.mu.RUnlock()
+
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].Name < checks[j].Name
+	})
+
+	report := &HealthReport{Ok: true, Checks: make([]*HealthResult, len(checks))}
+
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report.Checks[i] = check.run(ctx)
+		}()
+	}
+	wg.Wait()
+
+	if !liveness && endless.IsShuttingDown() {
+		report.Checks = append(report.Checks, &HealthResult{
+			Name:      "shutdown",
+			Error:     "server is shutting down",
+			CheckedAt: time.Now(),
+		})
+	}
+
+	for _, result := range report.Checks {
+		if !result.Ok {
+			report.Ok = false
+		}
+	}
+
+	return report
+} // }}}
+
+// 执行单项检查, 缓存期内直接返回上次结果
+func (c *HealthCheck) run(ctx context.Context) *HealthResult { // {{{
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.result != nil && c.CacheTTL > 0 && time.Since(c.result.CheckedAt) < c.CacheTTL {
+		return c.result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				done <- fmt.Errorf("panic: %v", err)
+			}
+		}()
+
+		done <- c.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("timeout after %v", c.Timeout)
+	}
+
+	result := &HealthResult{
+		Name:      c.Name,
+		Ok:        err == nil,
+		Duration:  float64(time.Since(start).Microseconds()) / 1000,
+		CheckedAt: start,
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	c.result = result
+
+	return result
+} // }}}
+
+// 处理 /livez、/readyz 请求: 正常时返回 200, 否则 503; 带 verbose 参数或 Accept json 时返回 json 明细
+func (h *HealthRegistry) serve(rw http.ResponseWriter, r *http.Request, liveness bool) { // {{{
+	report := h.Check(r.Context(), liveness)
+
+	status := http.StatusOK
+	if !report.Ok {
+		status = http.StatusServiceUnavailable
+	}
+
+	rw.Header().Set("Cache-Control", "no-cache")
+
+	if r.URL.Query().Has("verbose") || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+		rw.WriteHeader(status)
+		json.NewEncoder(rw).Encode(report)
+		return
+	}
+
+	rw.WriteHeader(status)
+	if report.Ok {
+		rw.Write([]byte("ok\n"))
+	} else {
+		rw.Write([]byte("fail\n"))
+	}
+} // }}}
+
+// 处理健康检查相关路径, 已处理时返回 true
+func serveHealth(rw http.ResponseWriter, r *http.Request) bool { // {{{
+	switch r.URL.Path {
+	case ConfLivezPath:
+		Health.serve(rw, r, true)
+	case ConfReadyzPath:
+		Health.serve(rw, r, false)
+	case ConfDBStatsPath:
+		if !ConfDBStatsEnabled || DB == nil {
+			return false
+		}
+
+		serveDBStats(rw)
+	default:
+		if ConfMonitorPath != "" && strings.HasPrefix(r.URL.Path, ConfMonitorPath) { //用于lvs监控, 与 /readyz 一致
+			Health.serve(rw, r, false)
+			return true
+		}
+
+		return false
+	}
+
+	return true
+} // }}}
+
+// 连接池及从库状态
+func serveDBStats(rw http.ResponseWriter) { // {{{
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	json.NewEncoder(rw).Encode(map[string]any{
+		"pools":    DB.Stats(),
+		"replicas": DB.ReplicaStatus(),
+	})
+} // }}}
+
+// 注册框架内置检查项: db、redis 连接及日志队列
+// db、redis 检查所有已配置的资源(见 healthConfNames), 未创建的连接池在检查时创建, 启动时即不可用的资源同样使就绪检查失败
+func RegisterDefaultHealthChecks(log_queue_threshold float64) { // {{{
+	if DB != nil {
+		db_names := healthConfNames("db", "db_")
+		Health.RegisterFunc("db", func(ctx context.Context) error {
+			return DB.PingNamed(ctx, db_names...)
+		})
+	}
+
+	if Redis != nil {
+		redis_names := healthConfNames("redis", "redis")
+		if name := Conf.GetString("dao_cache", "redis"); name != "" && !slices.Contains(redis_names, name) {
+			redis_names = append(redis_names, name)
+		}
+
+		Health.RegisterFunc("redis", func(ctx context.Context) error {
+			return Redis.PingNamed(ctx, redis_names...)
+		})
+	}
+
+	if Logger != nil && log_queue_threshold > 0 {
+		Health.RegisterFunc("log_queue", func(ctx context.Context) error {
+			if usage := Logger.QueueUsage(); usage >= log_queue_threshold {
+				return fmt.Errorf("log queue usage %.2f exceeds %.2f", usage, log_queue_threshold)
+			}
+
+			return nil
+		})
+	}
+} // }}}
+
+// 就绪检查的资源配置名: 配置了 health.{kind}(配置名列表)时使用该列表, 否则为以 prefix 开头的所有顶层配置
+// 不是资源配置的项(如 db_stats)在检查时跳过
+func healthConfNames(kind, prefix string) []string { // {{{
+	if names := Conf.GetStringSlice("health", kind); len(names) > 0 {
+		return names
+	}
+
+	var names []string
+	for _, key := range Conf.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			names = append(names, key)
+		}
+	}
+
+	return names
+} // }}}
@@ -166,8 +166,7 @@ func (h *httpHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) { // {{
 		} else if ConfDebugRpcEnabled && strings.HasPrefix(r.URL.Path, "/debug/rpc/") { //如果开启了 rpc 选项, 可使用 http 协议代理方式调式 rpc 方法
 			DebugRpc(rw, r)
 			return
-		} else if strings.HasPrefix(r.URL.Path, ConfMonitorPath) { //用于lvs监控
-			rw.Write([]byte("ok\n"))
+		} else if serveHealth(rw, r) { //用于lvs监控及 /livez、/readyz、连接池状态
 			return
 		}
 	}
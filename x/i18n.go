@@ -0,0 +1,402 @@
+package x
+
+import (
+	"fmt"
+	"github.com/nyxless/nyx/x/yaml"
+	"google.golang.org/grpc/codes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 多语言错误信息, 支持复数形式: 按 data 中 count 的值选择, 未定义的形式使用 Other
+type ErrMessage struct {
+	Zero  string
+	One   string
+	Other string
+}
+
+// 错误码对应的 http 状态码及 grpc 状态码
+type ErrStatus struct {
+	HttpStatus int
+	GrpcCode   codes.Code
+}
+
+var (
+	// 语言别名, 用于 Accept-Language 等标准语言标签与配置中语言名的转换, 可在项目中修改
+	LangAlias = MAPS{
+		"ZH":      "CN",
+		"ZH-CN":   "CN",
+		"ZH-HANS": "CN",
+		"EN-US":   "EN",
+		"EN-GB":   "EN",
+	}
+
+	// 语言回退链, 如 {"TW": {"HK", "CN"}}, 未找到时最终回退到 DefaultLang
+	LangFallback = map[string][]string{}
+
+	// 错误码 => http/grpc 状态码, 仅在开启 err_status 时生效
+	ErrStatusMap = map[int32]ErrStatus{
+		10: {500, codes.Unknown},
+		11: {500, codes.Internal},
+		12: {404, codes.Unimplemented},
+		13: {400, codes.InvalidArgument},
+		14: {401, codes.Unauthenticated},
+		15: {404, codes.NotFound},
+		16: {403, codes.PermissionDenied},
+	}
+
+	errBundles = map[string]map[int32]*ErrMessage{} // lang => code => 信息
+	errLangs   map[string]struct{}                  // 所有可用语言
+	langMu     sync.RWMutex
+
+	errPlaceholderRegex = regexp.MustCompile(`\{(\w+)\}`)
+	errBundleFileRegex  = regexp.MustCompile(`^errors\.([\w\-]+)\.ya?ml$`)
+)
+
+// 指定错误码对应的 http 状态码及 grpc 状态码
+func SetErrStatus(code int32, http_status int, grpc_code codes.Code) { // {{{
+	mu.Lock()
+	defer mu.Unlock()
+
+	ErrStatusMap[code] = ErrStatus{http_status, grpc_code}
+} // }}}
+
+func GetErrStatus(code int32) (ErrStatus, bool) { // {{{
+	mu.Lock()
+	defer mu.Unlock()
+
+	st, ok := ErrStatusMap[code]
+	return st, ok
+} // }}}
+
+func (e *Error) HttpStatus() int { // {{{
+	if st, ok := GetErrStatus(e.code); ok && st.HttpStatus > 0 {
+		return st.HttpStatus
+	}
+
+	return 200
+} // }}}
+
+func (e *Error) GrpcCode() codes.Code { // {{{
+	if st, ok := GetErrStatus(e.code); ok {
+		return st.GrpcCode
+	}
+
+	return codes.Unknown
+} // }}}
+
+// 添加某个语言的错误信息, 同一错误码覆盖
+func AddErrBundle(lang string, msgs map[int32]*ErrMessage) { // {{{
+	lang = NormalizeLang(lang)
+
+	langMu.Lock()
+	defer langMu.Unlock()
+
+	bundle, ok := errBundles[lang]
+	if !ok {
+		bundle = map[int32]*ErrMessage{}
+		errBundles[lang] = bundle
+	}
+
+	for code, msg := range msgs {
+		bundle[code] = msg
+	}
+
+	errLangs = nil
+} // }}}
+
+// 从目录加载 errors.<lang>.yaml 文件, 文件格式:
+//
+//	101: "认证失败"
+//	102: "参数 {name} 错误"
+//	103:
+//	  zero: "没有剩余次数"
+//	  one: "还剩 1 次"
+//	  other: "还剩 {count} 次"
+//
+// 同目录下的 errors.yaml (不带语言) 用于配置状态码:
+//
+//	101: {http_status: 401, grpc_code: Unauthenticated}
+func LoadErrBundles(dir string) error { // {{{
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		file := filepath.Join(dir, entry.Name())
+
+		if entry.Name() == "errors.yaml" || entry.Name() == "errors.yml" {
+			if err := loadErrStatus(file); err != nil {
+				return err
+			}
+			continue
+		}
+
+		m := errBundleFileRegex.FindStringSubmatch(entry.Name())
+		if m == nil {
+			continue
+		}
+
+		data, err := yaml.NewYaml(file).YamlToMap()
+		if err != nil {
+			return fmt.Errorf("load error bundle %s: %v", file, err)
+		}
+
+		msgs := map[int32]*ErrMessage{}
+		for code, v := range data {
+			c, err := strconv.ParseInt(code, 10, 32)
+			if err != nil {
+				return fmt.Errorf("load error bundle %s: invalid code %q", file, code)
+			}
+
+			if s, ok := v.(string); ok {
+				msgs[int32(c)] = &ErrMessage{Other: s}
+				continue
+			}
+
+			forms := AsStringMap(v)
+			msgs[int32(c)] = &ErrMessage{
+				Zero:  forms["zero"],
+				One:   forms["one"],
+				Other: forms["other"],
+			}
+		}
+
+		AddErrBundle(m[1], msgs)
+	}
+
+	return nil
+} // }}}
+
+func loadErrStatus(file string) error { // {{{
+	data, err := yaml.NewYaml(file).YamlToMap()
+	if err != nil {
+		return fmt.Errorf("load error status %s: %v", file, err)
+	}
+
+	for code, v := range data {
+		c, err := strconv.ParseInt(code, 10, 32)
+		if err != nil {
+			return fmt.Errorf("load error status %s: invalid code %q", file, code)
+		}
+
+		conf := AsMap(v)
+		grpc_code := codes.Unknown
+		if name := AsString(conf["grpc_code"]); name != "" {
+			if err := grpc_code.UnmarshalJSON([]byte(strconv.Quote(toGrpcCodeName(name)))); err != nil {
+				return fmt.Errorf("load error status %s: %v", file, err)
+			}
+		}
+
+		SetErrStatus(int32(c), AsInt(conf["http_status"]), grpc_code)
+	}
+
+	return nil
+} // }}}
+
+// Unauthenticated => UNAUTHENTICATED, InvalidArgument => INVALID_ARGUMENT
+func toGrpcCodeName(name string) string { // {{{
+	if strings.ToUpper(name) == name {
+		return name
+	}
+
+	var b strings.Builder
+	for i, r := range name {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			b.WriteByte('_')
+		}
+		b.WriteRune(r)
+	}
+
+	return strings.ToUpper(b.String())
+} // }}}
+
+func getErrBundle(lang string, code int32) (*ErrMessage, bool) { // {{{
+	langMu.RLock()
+	defer langMu.RUnlock()
+
+	msg, ok := errBundles[lang][code]
+	return msg, ok
+} // }}}
+
+// 统一语言名: 大写, 下划线转为中划线, 并转换别名
+func NormalizeLang(lang string) string { // {{{
+	lang = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(lang), "_", "-"))
+	if alias, ok := LangAlias[lang]; ok {
+		return alias
+	}
+
+	return lang
+} // }}}
+
+// 语言回退链: 指定语言 -> 配置的回退语言 -> 主语言(如 EN-AU -> EN) -> DefaultLang
+func LangChain(lang string) []string { // {{{
+	lang = NormalizeLang(lang)
+	chain := []string{}
+
+	add := func(l string) {
+		if l != "" && !slices.Contains(chain, l) {
+			chain = append(chain, l)
+		}
+	}
+
+	add(lang)
+
+	for _, l := range LangFallback[lang] {
+		add(NormalizeLang(l))
+	}
+
+	if base, _, ok := strings.Cut(lang, "-"); ok {
+		add(NormalizeLang(base))
+	}
+
+	add(DefaultLang)
+
+	return chain
+} // }}}
+
+// 所有可用语言: 代码中定义、配置文件 err_msg 及错误信息文件
+func ErrLangs() map[string]struct{} { // {{{
+	langMu.RLock()
+	langs := errLangs
+	langMu.RUnlock()
+
+	if langs != nil {
+		return langs
+	}
+
+	langs = map[string]struct{}{DefaultLang: {}}
+
+	mu.Lock()
+	for _, m := range []map[int32]MAPS{ErrMap, ErrMapRo} {
+		for _, msgs := range m {
+			for l := range msgs {
+				langs[NormalizeLang(l)] = struct{}{}
+			}
+		}
+	}
+	mu.Unlock()
+
+	langMu.Lock()
+	for l := range errBundles {
+		langs[l] = struct{}{}
+	}
+	errLangs = langs
+	langMu.Unlock()
+
+	return langs
+} // }}}
+
+// 清除可用语言缓存, 修改 ErrMapRo 后调用
+func RefreshErrLangs() { // {{{
+	langMu.Lock()
+	errLangs = nil
+	langMu.Unlock()
+} // }}}
+
+// 根据 Accept-Language 选择可用语言, 无匹配时返回空字符串
+// 如: zh-CN,zh;q=0.9,en;q=0.8
+func NegotiateLang(accept_language string) string { // {{{
+	if accept_language == "" {
+		return ""
+	}
+
+	type tag struct {
+		lang string
+		q    float64
+	}
+
+	var tags []tag
+	for _, part := range strings.Split(accept_language, ",") {
+		lang, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if lang == "" || lang == "*" {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+
+		if q > 0 {
+			tags = append(tags, tag{lang, q})
+		}
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].q > tags[j].q
+	})
+
+	langs := ErrLangs()
+	for _, t := range tags {
+		lang := NormalizeLang(t.lang)
+		if _, ok := langs[lang]; ok {
+			return lang
+		}
+
+		if base, _, ok := strings.Cut(lang, "-"); ok {
+			if _, ok := langs[NormalizeLang(base)]; ok {
+				return NormalizeLang(base)
+			}
+		}
+	}
+
+	return ""
+} // }}}
+
+// 选择复数形式
+func (m *ErrMessage) pick(data MAP) string { // {{{
+	if count, ok := data["count"]; ok {
+		switch AsInt(count) {
+		case 0:
+			if m.Zero != "" {
+				return m.Zero
+			}
+		case 1:
+			if m.One != "" {
+				return m.One
+			}
+		}
+	}
+
+	if m.Other != "" {
+		return m.Other
+	}
+
+	if m.One != "" {
+		return m.One
+	}
+
+	return m.Zero
+} // }}}
+
+// 使用 data 替换 {name} 形式的占位符, data 中不存在的保留原样
+func replacePlaceholders(msg string, data MAP) string { // {{{
+	if len(data) == 0 || !strings.Contains(msg, "{") {
+		return msg
+	}
+
+	return errPlaceholderRegex.ReplaceAllStringFunc(msg, func(s string) string {
+		if v, ok := data[s[1:len(s)-1]]; ok {
+			return AsString(v)
+		}
+
+		return s
+	})
+} // }}}
@@ -10,6 +10,7 @@ import (
 type Entry struct {
 	Level    string
 	Time     string
+	At       time.Time // 日志产生时间, 供需要标准时间格式的编码器使用
 	File     string
 	Msg      string
 	Args     []any
@@ -43,6 +44,7 @@ func GetEntry(is_format_msg bool, time_format, level_name, msg string, args ...a
 		entry.Time = t.Format(time_format)
 	}
 
+	entry.At = t
 	entry.Level = level_name
 	entry.Msg = msg
 	entry.Args = args
@@ -54,6 +56,7 @@ func GetEntry(is_format_msg bool, time_format, level_name, msg string, args ...a
 func PutEntry(entry *Entry) {
 	entry.Level = ""
 	entry.Time = ""
+	entry.At = time.Time{}
 	entry.File = ""
 	entry.Msg = ""
 	entry.Args = entry.Args[:0]
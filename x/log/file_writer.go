@@ -3,15 +3,19 @@ package log
 import (
 	"bufio"
 	"compress/gzip"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/klauspost/compress/zstd"
 )
 
 var (
@@ -21,8 +25,11 @@ var (
 	DefaultCompressBefore int   = 60 * 24           // 压缩24小时前修改的文件，单位分钟
 	DefaultRemove         bool  = false             // 默认不删除历史文件
 	DefaultRemoveBefore   int   = 60 * 24 * 7       // 删除7天前修改的文件，单位分钟
+	DefaultCompressType         = "gzip"            // 默认压缩格式, 可选 gzip | zstd
 )
 
+const manifestSuffix = ".manifest.json"
+
 type FileWriter struct {
 	file          *os.File      // 当前日志文件
 	writer        *bufio.Writer // 缓冲
@@ -36,12 +43,16 @@ type FileWriter struct {
 }
 
 type Options struct {
-	maxBufferSize  int   // 缓冲区大小（字节）
-	maxFileSize    int64 // 单个日志文件最大大小（字节）
-	compress       bool  // 是否压缩旧日志
-	compressBefore int   // 压缩N分钟前修改旧日志, 单位分钟
-	remove         bool  // 是否删除旧文件
-	removeBefore   int   // 删除N分钟前修改旧日志, 单位分钟
+	maxBufferSize  int    // 缓冲区大小（字节）
+	maxFileSize    int64  // 单个日志文件最大大小（字节）
+	compress       bool   // 是否压缩旧日志
+	compressBefore int    // 压缩N分钟前修改旧日志, 单位分钟
+	remove         bool   // 是否删除旧文件
+	removeBefore   int    // 删除N分钟前修改旧日志, 单位分钟
+	compressType   string // 压缩格式 gzip | zstd
+	maxTotalSize   int64  // 同一日志族(相同命名规则)所有文件总大小上限(字节), 超出时从最旧的文件开始删除, 0 不限制
+	maxFiles       int    // 同一日志族文件数上限(含当前文件), 0 不限制
+	manifest       bool   // 是否生成 manifest 文件, 记录已写完(不再写入)的文件列表
 }
 
 type FuncOption func(r *Options)
@@ -88,6 +99,34 @@ func WithRemoveBefore(i int) FuncOption { // {{{
 	}
 } // }}}
 
+// 设置配置 compressType
+func WithCompressType(t string) FuncOption { // {{{
+	return func(o *Options) {
+		o.compressType = strings.ToLower(t)
+	}
+} // }}}
+
+// 设置配置 maxTotalSize
+func WithMaxTotalSize(i int64) FuncOption { // {{{
+	return func(o *Options) {
+		o.maxTotalSize = i
+	}
+} // }}}
+
+// 设置配置 maxFiles
+func WithMaxFiles(i int) FuncOption { // {{{
+	return func(o *Options) {
+		o.maxFiles = i
+	}
+} // }}}
+
+// 设置配置 manifest
+func WithManifest(b bool) FuncOption { // {{{
+	return func(o *Options) {
+		o.manifest = b
+	}
+} // }}}
+
 func NewFileWriter(logPath, namingFormat string, opts ...FuncOption) (*FileWriter, error) { // {{{
 	options := &Options{
 		maxBufferSize:  DefaultBufferSize,
@@ -96,6 +135,7 @@ func NewFileWriter(logPath, namingFormat string, opts ...FuncOption) (*FileWrite
 		compressBefore: DefaultCompressBefore,
 		remove:         DefaultRemove,
 		removeBefore:   DefaultRemoveBefore,
+		compressType:   DefaultCompressType,
 	}
 
 	for _, opt := range opts {
@@ -173,6 +213,14 @@ func (fw *FileWriter) Close() error { // {{{
 	return nil
 } // }}}
 
+// 重新打开日志文件, 供外部 logrotate 移走文件后通过信号触发
+func (fw *FileWriter) Reopen() error { // {{{
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	return fw.rotateLog()
+} // }}}
+
 // 检查是否需要轮转（时间或大小）
 func (fw *FileWriter) checkRotate() error {
 	// 获取当前时间对应的文件名
@@ -232,8 +280,8 @@ func (fw *FileWriter) rotateLog() error { // {{{
 		fw.currentSize = 0
 	}
 
-	if fw.options.compress || fw.options.remove {
-		go fw.cleanOldFile()
+	if fw.needClean() {
+		go fw.cleanOldFile(fw.fileName)
 	}
 
 	return nil
@@ -274,81 +322,206 @@ func (fw *FileWriter) genFilename() string { // {{{
 func extractSequence(match, filename string) int {
 	trimmed := strings.TrimPrefix(match, filename+".")
 	trimmed = strings.TrimSuffix(trimmed, ".gz")
+	trimmed = strings.TrimSuffix(trimmed, ".zst")
 
 	var seq int
 	fmt.Sscanf(trimmed, "%d", &seq)
 	return seq
 }
 
-func (fw *FileWriter) cleanOldFile() { // {{{
+func (fw *FileWriter) needClean() bool { // {{{
+	o := fw.options
+	return o.compress || o.remove || o.maxTotalSize > 0 || o.maxFiles > 0 || o.manifest
+} // }}}
+
+// current: 当前正在写入的文件, 不参与删除和压缩
+func (fw *FileWriter) cleanOldFile(current string) { // {{{
 	// 删除旧日志（如果启用）
 	if fw.options.remove {
-		fw.removeFiles()
+		fw.removeFiles(current)
 	}
 
 	// 压缩旧日志（如果启用）
 	if fw.options.compress {
-		fw.compressFiles()
+		fw.compressFiles(current)
+	}
+
+	// 按总大小及文件数保留
+	if fw.options.maxTotalSize > 0 || fw.options.maxFiles > 0 {
+		fw.retainFiles(current)
+	}
+
+	if fw.options.manifest {
+		fw.writeManifest(current)
 	}
 } // }}}
 
-func (fw *FileWriter) removeFiles() error { // {{{
-	cutoff := time.Now().Add(-(time.Duration(fw.options.removeBefore) * time.Minute))
+type familyFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
 
-	err := filepath.Walk(fw.logPath, func(path string, info os.FileInfo, err error) error {
+// 列出同一日志族(相同命名规则)的所有文件, 按修改时间从新到旧排序
+func (fw *FileWriter) familyFiles() []familyFile { // {{{
+	pattern := regexp.MustCompile(familyPatternToRegex(filepath.Base(fw.namingFormat)))
+
+	var files []familyFile
+	filepath.Walk(fw.logPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			return err
+			return nil
 		}
 
-		pattern := timePatternToRegex(filepath.Base(fw.namingFormat))
-		if matched, err := regexp.MatchString(pattern, filepath.Base(path)); err == nil && matched {
-			if !info.IsDir() && info.ModTime().Before(cutoff) {
-				if fw.file != nil && path == fw.file.Name() {
-					fmt.Println("skip current file")
-					return nil
-				}
-
-				if err := os.Remove(path); err != nil {
-					fmt.Printf("删除失败: %s, 错误: %v\n", path, err)
-				} else {
-					fmt.Printf("已删除: %s\n", path)
-				}
-			}
+		if info.IsDir() || !pattern.MatchString(filepath.Base(path)) {
+			return nil
 		}
+
+		files = append(files, familyFile{path, info.Size(), info.ModTime()})
 		return nil
 	})
 
-	return err
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.After(files[j].modTime)
+	})
+
+	return files
 } // }}}
 
-func (fw *FileWriter) compressFiles() error { // {{{
+func (fw *FileWriter) removeFiles(current string) error { // {{{
 	cutoff := time.Now().Add(-(time.Duration(fw.options.removeBefore) * time.Minute))
 
-	err := filepath.Walk(fw.logPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	for _, f := range fw.familyFiles() {
+		if f.path == current || !f.modTime.Before(cutoff) {
+			continue
 		}
 
-		pattern := timePatternToRegex(filepath.Base(fw.namingFormat))
-		if matched, err := regexp.MatchString(pattern, filepath.Base(path)); err == nil && matched {
-			if !info.IsDir() && info.ModTime().Before(cutoff) {
-				fw.compressFile(path)
-			}
+		if err := os.Remove(f.path); err != nil {
+			fmt.Printf("删除失败: %s, 错误: %v\n", f.path, err)
+		} else {
+			fmt.Printf("已删除: %s\n", f.path)
 		}
-		return nil
-	})
+	}
 
-	return err
+	return nil
 } // }}}
 
-// 压缩日志文件
-func (fw *FileWriter) compressFile(filename string) { // {{{
-	// 跳过正在写入的文件和已压缩文件
-	if strings.HasSuffix(filename, ".gz") {
-		return
+func (fw *FileWriter) compressFiles(current string) error { // {{{
+	cutoff := time.Now().Add(-(time.Duration(fw.options.compressBefore) * time.Minute))
+
+	for _, f := range fw.familyFiles() {
+		if f.path == current || !f.modTime.Before(cutoff) {
+			continue
+		}
+
+		fw.compressFile(f.path)
 	}
 
-	if fw.file != nil && filename == fw.file.Name() {
+	return nil
+} // }}}
+
+// 超出总大小或文件数上限时, 从最旧的文件开始删除, 当前文件始终保留并计入总量
+func (fw *FileWriter) retainFiles(current string) { // {{{
+	var total int64
+	var count int
+
+	files := fw.familyFiles()
+	for _, f := range files {
+		if f.path == current {
+			total += f.size
+			count++
+		}
+	}
+
+	for _, f := range files {
+		if f.path == current {
+			continue
+		}
+
+		total += f.size
+		count++
+
+		over_size := fw.options.maxTotalSize > 0 && total > fw.options.maxTotalSize
+		over_count := fw.options.maxFiles > 0 && count > fw.options.maxFiles
+		if !over_size && !over_count {
+			continue
+		}
+
+		if err := os.Remove(f.path); err != nil {
+			fmt.Printf("删除失败: %s, 错误: %v\n", f.path, err)
+			continue
+		}
+
+		fmt.Printf("已删除: %s\n", f.path)
+		total -= f.size
+		count--
+	}
+} // }}}
+
+// manifest 记录
+type ManifestFile struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Complete bool      `json:"complete"` // true: 已写完, 可安全采集或归档
+	ModTime  time.Time `json:"mod_time"`
+}
+
+type Manifest struct {
+	Family    string         `json:"family"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	Files     []ManifestFile `json:"files"`
+}
+
+// manifest 路径: 日志目录下 .{命名规则}.manifest.json
+func (fw *FileWriter) manifestPath() string { // {{{
+	return filepath.Join(fw.logPath, "."+filepath.Base(fw.namingFormat)+manifestSuffix)
+} // }}}
+
+// 写入 manifest, 先写临时文件再重命名, 保证读取方看到完整内容
+func (fw *FileWriter) writeManifest(current string) error { // {{{
+	m := &Manifest{
+		Family:    fw.namingFormat,
+		UpdatedAt: time.Now(),
+	}
+
+	for _, f := range fw.familyFiles() {
+		rel, err := filepath.Rel(fw.logPath, f.path)
+		if err != nil {
+			rel = f.path
+		}
+
+		// 压缩中的文件未完成
+		complete := f.path != current && !fw.isCompressing(f.path)
+		m.Files = append(m.Files, ManifestFile{rel, f.size, complete, f.modTime})
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := fw.manifestPath()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+} // }}}
+
+// 压缩过程中原文件和目标文件同时存在
+func (fw *FileWriter) isCompressing(filename string) bool { // {{{
+	if strings.HasSuffix(filename, ".gz") || strings.HasSuffix(filename, ".zst") {
+		_, err := os.Stat(strings.TrimSuffix(strings.TrimSuffix(filename, ".gz"), ".zst"))
+		return err == nil
+	}
+
+	return false
+} // }}}
+
+// 压缩日志文件
+func (fw *FileWriter) compressFile(filename string) { // {{{
+	// 跳过已压缩文件
+	if strings.HasSuffix(filename, ".gz") || strings.HasSuffix(filename, ".zst") {
 		return
 	}
 
@@ -359,20 +532,49 @@ func (fw *FileWriter) compressFile(filename string) { // {{{
 	}
 	defer src.Close()
 
+	ext := ".gz"
+	if fw.options.compressType == "zstd" {
+		ext = ".zst"
+	}
+
 	// 创建压缩文件
-	dst, err := os.Create(filename + ".gz")
+	dst, err := os.Create(filename + ext)
 	if err != nil {
 		return
 	}
-	defer dst.Close()
+
+	var zw io.WriteCloser
+	if ext == ".zst" {
+		zw, err = zstd.NewWriter(dst)
+		if err != nil {
+			dst.Close()
+			os.Remove(filename + ext)
+			return
+		}
+	} else {
+		zw = gzip.NewWriter(dst)
+	}
 
 	// 执行压缩
-	gz := gzip.NewWriter(dst)
-	defer gz.Close()
+	_, err = io.Copy(zw, src)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
 
-	if _, err := io.Copy(gz, src); err == nil {
-		os.Remove(filename) // 压缩成功后删除原文件
+	if err != nil {
+		os.Remove(filename + ext)
+		return
 	}
+
+	os.Remove(filename) // 压缩成功后删除原文件
+} // }}}
+
+// 匹配同一日志族的文件名: 命名规则 + 可选序号 + 可选压缩后缀
+func familyPatternToRegex(timePattern string) string { // {{{
+	return "^" + timePatternToRegex(timePattern) + `(\.\d+)?(\.gz|\.zst)?$`
 } // }}}
 
 func timePatternToRegex(timePattern string) string { // {{{
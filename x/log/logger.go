@@ -4,10 +4,12 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -52,7 +54,9 @@ type Logger struct {
 	writer         io.Writer                         // 日志输出位置
 	writers        map[io.Writer]struct{}            // 当使用 MultiWriter 时，保存所有 writer
 	writer_accepts map[io.Writer]map[string]struct{} // 当使用 MultiWriter 时， 不同writer接受的日志级别，未指定则全接受
+	sinks          atomic.Pointer[[]*Sink]           // 独立编码、异步发送的输出端(syslog, 网络, stdout), 写时复制, 读取时无需加锁
 	encoder        Encoder                           //输出格式化
+	redactor       *Redactor                         // 脱敏规则, 在编码阶段生效
 	stopChan       chan struct{}                     // 停止信号
 	wg             sync.WaitGroup                    // 等待日志写完
 	mu             sync.Mutex
@@ -95,6 +99,8 @@ type LogOptions struct {
 	FileEnabled   bool
 	FileRule      *LogFileRule
 	FileLevelRule map[string]*LogFileRule
+	Sinks         []*SinkRule
+	Redactor      *Redactor
 	Prefix        string
 	TimeFormat    string
 }
@@ -110,6 +116,8 @@ func (o *LogOptions) clone() *LogOptions {
 		FileEnabled:   o.FileEnabled,
 		FileRule:      o.FileRule,
 		FileLevelRule: o.FileLevelRule,
+		Sinks:         o.Sinks,
+		Redactor:      o.Redactor,
 		Prefix:        o.Prefix,
 		TimeFormat:    o.TimeFormat,
 	}
@@ -143,6 +151,12 @@ func (o *LogOptions) merge(from *LogOptions) {
 	if from.FileLevelRule != nil {
 		o.FileLevelRule = from.FileLevelRule
 	}
+	if from.Sinks != nil {
+		o.Sinks = from.Sinks
+	}
+	if from.Redactor != nil {
+		o.Redactor = from.Redactor
+	}
 	if from.Prefix != "" {
 		o.Prefix = from.Prefix
 	}
@@ -160,6 +174,10 @@ type LogFileRule struct {
 	Remove         bool
 	Path           string
 	NamingFormat   string
+	CompressType   string // gzip | zstd
+	MaxTotalSize   int64  // 同一日志族文件总大小上限(字节)
+	MaxFiles       int    // 同一日志族文件数上限
+	Manifest       bool   // 生成 manifest 文件
 }
 
 func NewLogger(opts ...*LogOptions) (*Logger, error) { // {{{
@@ -199,6 +217,20 @@ func NewLogger(opts ...*LogOptions) (*Logger, error) { // {{{
 		}
 	}
 
+	for _, rule := range opt.Sinks {
+		sink, err := NewSinkFromRule(rule)
+		if err != nil {
+			logger.closeSinks()
+			return nil, err
+		}
+
+		logger.AddSink(sink)
+	}
+
+	if opt.Redactor != nil {
+		logger.SetRedactor(opt.Redactor)
+	}
+
 	//后台处理日志
 	logger.wg.Add(1)
 	go func() {
@@ -233,6 +265,18 @@ func buildFileWriterOpts(rule *LogFileRule) []FuncOption {
 	if rule.Remove {
 		opts = append(opts, WithRemoveBefore(rule.RemoveBefore))
 	}
+	if rule.CompressType != "" {
+		opts = append(opts, WithCompressType(rule.CompressType))
+	}
+	if rule.MaxTotalSize > 0 {
+		opts = append(opts, WithMaxTotalSize(rule.MaxTotalSize))
+	}
+	if rule.MaxFiles > 0 {
+		opts = append(opts, WithMaxFiles(rule.MaxFiles))
+	}
+	if rule.Manifest {
+		opts = append(opts, WithManifest(true))
+	}
 
 	return opts
 }
@@ -285,6 +329,47 @@ func (l *Logger) UseFileWriter(file_rule *LogFileRule, file_level_rule map[strin
 	return nil
 } // }}}
 
+// 所有 FileWriter 重新打开文件, 用于配合外部 logrotate
+func (l *Logger) Rotate() error { // {{{
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var err error
+	for w := range l.writers {
+		if fw, ok := w.(*FileWriter); ok {
+			if e := fw.Reopen(); e != nil {
+				err = e
+			}
+		}
+	}
+
+	return err
+} // }}}
+
+// 收到指定信号时执行 Rotate
+func (l *Logger) RotateOnSignal(sigs ...os.Signal) { // {{{
+	if len(sigs) == 0 {
+		return
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+
+	go func() {
+		for {
+			select {
+			case <-ch:
+				if err := l.Rotate(); err != nil {
+					fmt.Println("logger rotate err:", err)
+				}
+			case <-l.stopChan:
+				signal.Stop(ch)
+				return
+			}
+		}
+	}()
+} // }}}
+
 func (l *Logger) processLogs() { // {{{
 	defer l.wg.Done()
 
@@ -428,6 +513,11 @@ func (l *Logger) prepareWrite(entrys ...*Entry) { // {{{
 		defer PutEntry(entry) // 归还对象池
 
 		entryLevel := entry.Level
+
+		for _, sink := range l.getSinks() {
+			sink.Send(entryLevel, entry)
+		}
+
 		if !l.showLevel {
 			entry.Level = ""
 		}
@@ -630,6 +720,84 @@ func (l *Logger) RemoveWriter(w io.Writer) { // {{{
 	}
 } // }}}
 
+// 增加 sink, 与 writer 相互独立, 使用各自的编码器、级别过滤和队列
+func (l *Logger) AddSink(s *Sink) { // {{{
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.redactor != nil {
+		s.SetRedactor(l.redactor)
+	}
+
+	sinks := append(append([]*Sink{}, l.getSinks()...), s)
+	l.sinks.Store(&sinks)
+} // }}}
+
+// 当前 sink 列表的快照
+func (l *Logger) getSinks() []*Sink { // {{{
+	if sinks := l.sinks.Load(); sinks != nil {
+		return *sinks
+	}
+
+	return nil
+} // }}}
+
+// 设置脱敏规则, 对主编码器及所有 sink 编码器生效
+func (l *Logger) SetRedactor(r *Redactor) { // {{{
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.redactor = r
+	l.encoder = NewRedactEncoder(l.encoder, r)
+
+	for _, sink := range l.getSinks() {
+		sink.SetRedactor(r)
+	}
+} // }}}
+
+func (l *Logger) Redactor() *Redactor {
+	return l.redactor
+}
+
+// 异步队列使用率(0~1), 包括主队列及所有 sink 队列中最高者, 用于健康检查
+func (l *Logger) QueueUsage() float64 { // {{{
+	var usage float64
+	if l.useQueue && cap(l.queue) > 0 {
+		usage = float64(len(l.queue)) / float64(cap(l.queue))
+	}
+
+	for _, sink := range l.getSinks() {
+		stats := sink.Stats()
+		if stats.QueueCap > 0 {
+			usage = max(usage, float64(stats.QueueLen)/float64(stats.QueueCap))
+		}
+	}
+
+	return usage
+} // }}}
+
+// 返回所有 sink 的统计信息(写入、丢弃、失败条数及队列长度)
+func (l *Logger) SinkStats() []SinkStats { // {{{
+	sinks := l.getSinks()
+	stats := make([]SinkStats, 0, len(sinks))
+	for _, sink := range sinks {
+		stats = append(stats, sink.Stats())
+	}
+
+	return stats
+} // }}}
+
+func (l *Logger) closeSinks() { // {{{
+	sinks := l.sinks.Swap(nil)
+	if sinks == nil {
+		return
+	}
+
+	for _, sink := range *sinks {
+		sink.Close()
+	}
+} // }}}
+
 func (l *Logger) updateMultiWriter() { // {{{
 	writers := make([]io.Writer, 0, len(l.writers))
 	for w := range l.writers {
@@ -662,4 +830,6 @@ func (l *Logger) Close() { // {{{
 
 	l.writers = make(map[io.Writer]struct{})
 	l.updateMultiWriter()
+
+	l.closeSinks()
 } // }}}
@@ -0,0 +1,197 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	DefaultSpoolSize      int64         = 100 * 1024 * 1024 // 默认落盘文件最大 100MB
+	DefaultReconnectDelay time.Duration = time.Second       // 重连初始间隔, 失败后翻倍
+	MaxReconnectDelay     time.Duration = 30 * time.Second  // 重连最大间隔
+)
+
+// 按行发送日志到远端 (如 json lines 采集服务), 断线时自动重连,
+// 重连期间写入本地落盘文件(有大小上限), 连接恢复后先补发落盘数据
+type NetWriter struct {
+	network   string
+	addr      string
+	conn      net.Conn
+	mu        sync.Mutex
+	spoolPath string
+	spoolSize int64
+	spool     *os.File
+	spooled   int64     // 当前落盘字节数
+	nextDial  time.Time // 下次允许重连时间
+	delay     time.Duration
+}
+
+// spool_path 为空时不落盘, 断线期间的数据直接丢弃
+func NewNetWriter(network, addr, spool_path string, spool_size int64) (*NetWriter, error) { // {{{
+	if network == "" {
+		network = "tcp"
+	}
+
+	if addr == "" {
+		return nil, fmt.Errorf("net writer addr is empty")
+	}
+
+	if spool_size <= 0 {
+		spool_size = DefaultSpoolSize
+	}
+
+	w := &NetWriter{
+		network:   network,
+		addr:      addr,
+		spoolPath: spool_path,
+		spoolSize: spool_size,
+		delay:     DefaultReconnectDelay,
+	}
+
+	if spool_path != "" {
+		if err := w.openSpool(); err != nil {
+			return nil, err
+		}
+	}
+
+	// 启动时连接失败不报错, 数据先落盘
+	w.dial()
+
+	return w, nil
+} // }}}
+
+func (w *NetWriter) openSpool() error { // {{{
+	if err := os.MkdirAll(filepath.Dir(w.spoolPath), 0755); err != nil {
+		return fmt.Errorf("failed to create spool directory: %v", err)
+	}
+
+	f, err := os.OpenFile(w.spoolPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open spool file: %v", err)
+	}
+
+	if info, err := f.Stat(); err == nil {
+		w.spooled = info.Size()
+	}
+
+	w.spool = f
+	return nil
+} // }}}
+
+func (w *NetWriter) dial() bool { // {{{
+	now := time.Now()
+	if now.Before(w.nextDial) {
+		return false
+	}
+
+	conn, err := net.DialTimeout(w.network, w.addr, DefaultDialTimeout)
+	if err != nil {
+		w.nextDial = now.Add(w.delay)
+		w.delay = min(w.delay*2, MaxReconnectDelay)
+		return false
+	}
+
+	w.conn = conn
+	w.delay = DefaultReconnectDelay
+
+	if err := w.replay(); err != nil {
+		w.disconnect()
+		return false
+	}
+
+	return true
+} // }}}
+
+func (w *NetWriter) disconnect() { // {{{
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+
+	w.nextDial = time.Now().Add(w.delay)
+} // }}}
+
+// 补发落盘数据, 成功后清空落盘文件
+func (w *NetWriter) replay() error { // {{{
+	if w.spool == nil || w.spooled == 0 {
+		return nil
+	}
+
+	if _, err := w.spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w.conn, w.spool); err != nil {
+		return err
+	}
+
+	if err := w.spool.Truncate(0); err != nil {
+		return err
+	}
+
+	w.spooled = 0
+	return nil
+} // }}}
+
+func (w *NetWriter) writeSpool(p []byte) (int, error) { // {{{
+	if w.spool == nil {
+		return 0, fmt.Errorf("net writer is disconnected: %s", w.addr)
+	}
+
+	if w.spooled+int64(len(p)) > w.spoolSize {
+		return 0, fmt.Errorf("net writer spool is full: %s", w.spoolPath)
+	}
+
+	n, err := w.spool.Write(p)
+	w.spooled += int64(n)
+
+	return n, err
+} // }}}
+
+// 实现 io.Writer 接口
+func (w *NetWriter) Write(p []byte) (int, error) { // {{{
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn == nil && !w.dial() {
+		return w.writeSpool(p)
+	}
+
+	if _, err := w.conn.Write(p); err != nil {
+		w.disconnect()
+		return w.writeSpool(p)
+	}
+
+	return len(p), nil
+} // }}}
+
+// 当前落盘字节数
+func (w *NetWriter) Spooled() int64 { // {{{
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.spooled
+} // }}}
+
+func (w *NetWriter) Close() error { // {{{
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.spool != nil {
+		w.spool.Close()
+		w.spool = nil
+	}
+
+	if w.conn != nil {
+		err := w.conn.Close()
+		w.conn = nil
+		return err
+	}
+
+	return nil
+} // }}}
@@ -0,0 +1,393 @@
+package log
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 脱敏规则, 对应配置文件 log.redact.rules 下的每一项
+// Keys 与 Pattern 至少指定一个: Keys 按字段名匹配(不区分大小写), Pattern 按正则匹配字符串值中的片段
+// Luhn 为 true 时, Pattern 匹配的片段须通过 Luhn 校验(如银行卡号)才脱敏, 避免误伤订单号等长数字
+// Mask 掩码格式:
+//
+//	full (默认)  整体替换为 ******
+//	keep:N,M    保留前 N 个和后 M 个字符, 中间替换为 *
+//	email       保留邮箱用户名首字符和域名
+//	hash        替换为 sha256 前 16 位
+//	remove      删除该字段(仅 Keys 规则有效, Pattern 规则时替换为空)
+type RedactRule struct {
+	Keys    []string
+	Pattern string
+	Mask    string
+	Luhn    bool
+}
+
+// 默认脱敏规则
+var DefaultRedactRules = []*RedactRule{
+	{Keys: []string{"password", "passwd", "pwd", "secret", "token", "access_token", "refresh_token", "authorization", "cookie"}},
+	{Keys: []string{"id_card", "idcard", "id_no"}, Mask: "keep:3,4"},
+	{Keys: []string{"phone", "mobile", "tel"}, Mask: "keep:3,4"},
+	{Keys: []string{"email"}, Mask: "email"},
+	{Pattern: `\b1[3-9]\d{9}\b`, Mask: "keep:3,4"},                                          // 手机号
+	{Pattern: `\b\d{6}(?:19|20)\d{2}(?:0[1-9]|1[0-2])\d{2}\d{3}[\dXx]\b`, Mask: "keep:3,4"}, // 身份证号
+	{Pattern: `\b(?:\d[ -]?){15,18}\d\b`, Mask: "keep:0,4", Luhn: true},                     // 银行卡号
+}
+
+const redactFullMask = "******"
+
+type maskFunc func(s string) string
+
+type redactValueRule struct {
+	re   *regexp.Regexp
+	mask maskFunc
+}
+
+// 日志脱敏器: 按字段名和值的正则对日志参数脱敏, 递归处理嵌套的 map、slice 及 json 字符串
+// 脱敏时复制数据, 不修改调用方传入的原始数据
+type Redactor struct {
+	keys   map[string]maskFunc // key 名(小写) => 掩码, 值为 nil 时删除字段
+	values []*redactValueRule
+}
+
+func NewRedactor(rules ...*RedactRule) (*Redactor, error) { // {{{
+	r := &Redactor{
+		keys: map[string]maskFunc{},
+	}
+
+	for _, rule := range rules {
+		if err := r.AddRule(rule); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+} // }}}
+
+func (r *Redactor) AddRule(rule *RedactRule) error { // {{{
+	if len(rule.Keys) == 0 && rule.Pattern == "" {
+		return fmt.Errorf("redact rule requires keys or pattern")
+	}
+
+	mask, err := parseMask(rule.Mask)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range rule.Keys {
+		r.keys[strings.ToLower(key)] = mask
+	}
+
+	if rule.Pattern != "" {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid redact pattern %q: %v", rule.Pattern, err)
+		}
+
+		if mask == nil {
+			mask = func(string) string { return "" }
+		}
+
+		if rule.Luhn {
+			masker := mask
+			mask = func(s string) string {
+				if !luhnValid(s) {
+					return s
+				}
+
+				return masker(s)
+			}
+		}
+
+		r.values = append(r.values, &redactValueRule{re: re, mask: mask})
+	}
+
+	return nil
+} // }}}
+
+func parseMask(format string) (maskFunc, error) { // {{{
+	name, param, _ := strings.Cut(strings.TrimSpace(format), ":")
+
+	switch strings.ToLower(name) {
+	case "", "full":
+		return func(string) string { return redactFullMask }, nil
+	case "remove":
+		return nil, nil
+	case "hash":
+		return func(s string) string {
+			sum := sha256.Sum256([]byte(s))
+			return "sha256:" + hex.EncodeToString(sum[:8])
+		}, nil
+	case "email":
+		return maskEmail, nil
+	case "keep":
+		head_str, tail_str, _ := strings.Cut(param, ",")
+		head, err1 := strconv.Atoi(strings.TrimSpace(head_str))
+		tail, err2 := strconv.Atoi(strings.TrimSpace(tail_str))
+		if err1 != nil || err2 != nil || head < 0 || tail < 0 {
+			return nil, fmt.Errorf("invalid redact mask: %s", format)
+		}
+
+		return func(s string) string {
+			return maskKeep(s, head, tail)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported redact mask: %s", format)
+	}
+} // }}}
+
+// 保留前 head 个和后 tail 个字符, 长度不足时整体替换
+func maskKeep(s string, head, tail int) string { // {{{
+	n := utf8.RuneCountInString(s)
+	if n <= head+tail {
+		return redactFullMask
+	}
+
+	runes := []rune(s)
+	for i := head; i < n-tail; i++ {
+		runes[i] = '*'
+	}
+
+	return string(runes)
+} // }}}
+
+// Luhn 校验, 忽略数字以外的字符(空格、中划线)
+func luhnValid(s string) bool { // {{{
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+
+		sum += d
+		n++
+	}
+
+	return n > 0 && sum%10 == 0
+} // }}}
+
+func maskEmail(s string) string { // {{{
+	name, domain, ok := strings.Cut(s, "@")
+	if !ok || name == "" {
+		return redactFullMask
+	}
+
+	first, _ := utf8.DecodeRuneInString(name)
+
+	return string(first) + "***@" + domain
+} // }}}
+
+// 是否未配置任何规则
+func (r *Redactor) Empty() bool { // {{{
+	return r == nil || (len(r.keys) == 0 && len(r.values) == 0)
+} // }}}
+
+// 对日志参数列表脱敏, 返回新的参数列表
+func (r *Redactor) RedactArgs(args []any) []any { // {{{
+	if r.Empty() || len(args) == 0 {
+		return args
+	}
+
+	ret := make([]any, len(args))
+	for i, arg := range args {
+		ret[i] = r.Redact(arg)
+	}
+
+	return ret
+} // }}}
+
+// 按字段名脱敏, 返回脱敏后的值, 第二个返回值为 false 时表示该字段需要删除
+func (r *Redactor) RedactField(key string, v any) (any, bool) { // {{{
+	if r.Empty() {
+		return v, true
+	}
+
+	if mask, ok := r.keys[strings.ToLower(key)]; ok {
+		if mask == nil || v == nil {
+			return nil, mask != nil
+		}
+
+		return mask(asString(v)), true
+	}
+
+	return r.Redact(v), true
+} // }}}
+
+// 递归脱敏任意值
+func (r *Redactor) Redact(v any) any { // {{{
+	if r.Empty() || v == nil {
+		return v
+	}
+
+	switch val := v.(type) {
+	case string:
+		return r.RedactString(val)
+	case []byte:
+		return r.RedactString(string(val))
+	case Field:
+		value, ok := r.RedactField(val.Key, val.Value)
+		if !ok {
+			return Field{Key: val.Key, Value: redactFullMask}
+		}
+		return Field{Key: val.Key, Value: value}
+	case map[string]any:
+		return r.redactMap(val)
+	case []any:
+		ret := make([]any, len(val))
+		for i, item := range val {
+			ret[i] = r.Redact(item)
+		}
+		return ret
+	case []string:
+		ret := make([]string, len(val))
+		for i, item := range val {
+			ret[i] = r.RedactString(item)
+		}
+		return ret
+	case json.Number:
+		if masked := r.RedactString(val.String()); masked != val.String() {
+			return masked
+		}
+		return v
+	case []map[string]any:
+		ret := make([]map[string]any, len(val))
+		for i, item := range val {
+			ret[i] = r.redactMap(item)
+		}
+		return ret
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, bool:
+		if len(r.values) == 0 {
+			return v
+		}
+
+		s := asString(val)
+		if masked := r.RedactString(s); masked != s {
+			return masked
+		}
+		return v
+	}
+
+	return r.redactReflect(v)
+} // }}}
+
+func (r *Redactor) redactMap(m map[string]any) map[string]any { // {{{
+	ret := make(map[string]any, len(m))
+	for k, v := range m {
+		if value, ok := r.RedactField(k, v); ok {
+			ret[k] = value
+		}
+	}
+
+	return ret
+} // }}}
+
+// 其他类型的 map (key 为字符串) 及 slice, 如 url.Values
+func (r *Redactor) redactReflect(v any) any { // {{{
+	rv := reflect.ValueOf(v)
+
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return v
+		}
+
+		ret := make(map[string]any, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			if value, ok := r.RedactField(iter.Key().String(), iter.Value().Interface()); ok {
+				ret[iter.Key().String()] = value
+			}
+		}
+		return ret
+	case reflect.Slice, reflect.Array:
+		ret := make([]any, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			ret[i] = r.Redact(rv.Index(i).Interface())
+		}
+		return ret
+	case reflect.Pointer:
+		if rv.IsNil() {
+			return v
+		}
+
+		switch rv.Elem().Kind() {
+		case reflect.Map, reflect.Slice, reflect.Array:
+			return r.Redact(rv.Elem().Interface())
+		}
+	}
+
+	return v
+} // }}}
+
+// 字符串脱敏: json 对象/数组按字段递归处理, 其他按正则规则替换
+func (r *Redactor) RedactString(s string) string { // {{{
+	if r.Empty() || s == "" {
+		return s
+	}
+
+	if trimmed := strings.TrimSpace(s); len(trimmed) > 1 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		var data any
+		decoder := json.NewDecoder(strings.NewReader(trimmed))
+		decoder.UseNumber()
+		if err := decoder.Decode(&data); err == nil && !decoder.More() {
+			if b, err := json.Marshal(r.Redact(data)); err == nil {
+				return string(b)
+			}
+		}
+	}
+
+	for _, rule := range r.values {
+		s = rule.re.ReplaceAllStringFunc(s, rule.mask)
+	}
+
+	return s
+} // }}}
+
+// 带脱敏的编码器, 对日志条目的副本脱敏后交给内部编码器
+type RedactEncoder struct {
+	Encoder
+	redactor *Redactor
+}
+
+func NewRedactEncoder(inner Encoder, r *Redactor) Encoder { // {{{
+	if inner == nil {
+		inner = DefaultEncoder
+	}
+
+	// 避免重复包装
+	if re, ok := inner.(*RedactEncoder); ok {
+		inner = re.Encoder
+	}
+
+	if r.Empty() {
+		return inner
+	}
+
+	return &RedactEncoder{Encoder: inner, redactor: r}
+} // }}}
+
+func (e *RedactEncoder) Encode(entry *Entry) ([]byte, error) { // {{{
+	redacted := *entry
+	redacted.Args = e.redactor.RedactArgs(entry.Args)
+
+	if !entry.Formated {
+		redacted.Msg = e.redactor.RedactString(entry.Msg)
+	}
+
+	return e.Encoder.Encode(&redacted)
+} // }}}
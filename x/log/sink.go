@@ -0,0 +1,223 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	DefaultSinkQueueSize = 1024 // 默认 sink 队列长度
+)
+
+// Sink 日志输出端: 独立的编码器、级别过滤及非阻塞队列, 队列满时丢弃并计数
+type Sink struct {
+	name    string
+	writer  io.Writer
+	encoder Encoder
+	levels  map[string]struct{} // 接受的级别名, 为空时全接受
+	queue   chan []byte
+	wg      sync.WaitGroup
+	closed  atomic.Bool
+	mu      sync.RWMutex
+
+	written atomic.Uint64 // 成功写入条数
+	dropped atomic.Uint64 // 队列满丢弃条数
+	failed  atomic.Uint64 // 写入失败条数
+}
+
+// sink 统计信息
+type SinkStats struct {
+	Name     string `json:"name"`
+	Written  uint64 `json:"written"`
+	Dropped  uint64 `json:"dropped"`
+	Failed   uint64 `json:"failed"`
+	QueueLen int    `json:"queue_len"`
+	QueueCap int    `json:"queue_cap"`
+}
+
+// sink 配置, 对应配置文件 log.sinks 下的每一项
+type SinkRule struct {
+	Name      string   // 名称, 用于统计
+	Type      string   // syslog | net | stdout
+	Network   string   // syslog: unix/unixgram/udp/tcp; net: tcp/udp
+	Addr      string   // 地址, unix socket 时为路径
+	Tag       string   // syslog APP-NAME
+	Facility  int      // syslog facility, 默认 16 (local0)
+	Format    string   // 编码格式: text | json | journald, syslog 类型时作为 MSG 部分的格式
+	Levels    []string // 接受的级别名
+	QueueSize int      // 队列长度
+	SpoolPath string   // net: 断线时落盘的文件路径
+	SpoolSize int64    // net: 落盘文件最大字节数
+}
+
+func NewSink(name string, w io.Writer, encoder Encoder, queue_size int, levels ...string) *Sink { // {{{
+	if queue_size <= 0 {
+		queue_size = DefaultSinkQueueSize
+	}
+
+	if encoder == nil {
+		encoder = DefaultEncoder
+	}
+
+	s := &Sink{
+		name:    name,
+		writer:  w,
+		encoder: encoder,
+		queue:   make(chan []byte, queue_size),
+	}
+
+	if len(levels) > 0 {
+		s.levels = map[string]struct{}{}
+		for _, level_name := range levels {
+			s.levels[strings.ToUpper(level_name)] = struct{}{}
+		}
+	}
+
+	s.wg.Add(1)
+	go s.process()
+
+	return s
+} // }}}
+
+// 根据 SinkRule 创建 Sink
+func NewSinkFromRule(rule *SinkRule) (*Sink, error) { // {{{
+	var w io.Writer
+	var encoder Encoder
+	var err error
+
+	switch strings.ToLower(rule.Format) {
+	case "json":
+		encoder = &JsonEncoder{}
+	case "journald":
+		encoder = &JournaldEncoder{Encoder: DefaultEncoder}
+	default:
+		encoder = &TextEncoder{}
+	}
+
+	switch strings.ToLower(rule.Type) {
+	case "syslog":
+		w, err = NewSyslogWriter(rule.Network, rule.Addr)
+		if err != nil {
+			return nil, err
+		}
+		encoder = NewSyslogEncoder(rule.Tag, rule.Facility, encoder)
+	case "net":
+		if rule.Format == "" {
+			encoder = &JsonEncoder{}
+		}
+
+		w, err = NewNetWriter(rule.Network, rule.Addr, rule.SpoolPath, rule.SpoolSize)
+		if err != nil {
+			return nil, err
+		}
+	case "stdout":
+		w = DefaultWriter
+	default:
+		return nil, fmt.Errorf("unsupported log sink type: %s", rule.Type)
+	}
+
+	name := rule.Name
+	if name == "" {
+		name = rule.Type + ":" + rule.Addr
+	}
+
+	return NewSink(name, w, encoder, rule.QueueSize, rule.Levels...), nil
+} // }}}
+
+// 为 sink 的编码器增加脱敏处理
+func (s *Sink) SetRedactor(r *Redactor) { // {{{
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.encoder = NewRedactEncoder(s.encoder, r)
+} // }}}
+
+func (s *Sink) Name() string {
+	return s.name
+}
+
+// 是否接受指定级别
+func (s *Sink) Accepts(level_name string) bool { // {{{
+	if len(s.levels) == 0 {
+		return true
+	}
+
+	_, ok := s.levels[level_name]
+	return ok
+} // }}}
+
+// 编码并放入队列, 队列满时丢弃, 不阻塞调用方
+func (s *Sink) Send(level_name string, entry *Entry) { // {{{
+	if !s.Accepts(level_name) {
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed.Load() {
+		s.dropped.Add(1)
+		return
+	}
+
+	encoded, err := s.encoder.Encode(entry)
+	if err != nil {
+		s.failed.Add(1)
+		return
+	}
+
+	line := make([]byte, 0, len(encoded)+1)
+	line = append(line, encoded...)
+	line = append(line, '\n')
+
+	select {
+	case s.queue <- line:
+	default:
+		s.dropped.Add(1)
+	}
+} // }}}
+
+func (s *Sink) process() { // {{{
+	defer s.wg.Done()
+
+	for line := range s.queue {
+		if _, err := s.writer.Write(line); err != nil {
+			s.failed.Add(1)
+			continue
+		}
+
+		s.written.Add(1)
+	}
+} // }}}
+
+func (s *Sink) Stats() SinkStats { // {{{
+	return SinkStats{
+		Name:     s.name,
+		Written:  s.written.Load(),
+		Dropped:  s.dropped.Load(),
+		Failed:   s.failed.Load(),
+		QueueLen: len(s.queue),
+		QueueCap: cap(s.queue),
+	}
+} // }}}
+
+// 关闭队列, 等待剩余数据写完后关闭 writer (保留Stdout和Stderr)
+func (s *Sink) Close() { // {{{
+	s.mu.Lock()
+	if s.closed.Swap(true) {
+		s.mu.Unlock()
+		return
+	}
+	close(s.queue)
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	if closer, ok := s.writer.(io.Closer); ok && s.writer != os.Stdout && s.writer != os.Stderr {
+		closer.Close()
+	}
+} // }}}
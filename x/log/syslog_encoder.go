@@ -0,0 +1,126 @@
+package log
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"time"
+)
+
+// syslog 默认 facility: local0
+const DefaultSyslogFacility = 16
+
+// 日志级别名转换为 syslog severity, 自定义级别按 INFO 处理
+func levelSeverity(level_name string) int { // {{{
+	switch level_name {
+	case "FATAL":
+		return 2 // crit
+	case "ERROR":
+		return 3 // err
+	case "WARN":
+		return 4 // warning
+	case "NOTICE":
+		return 5 // notice
+	case "DEBUG":
+		return 7 // debug
+	default:
+		return 6 // info
+	}
+} // }}}
+
+// RFC 5424 格式编码, MSG 部分由内部 Encoder 生成
+type SyslogEncoder struct {
+	Encoder
+	tag      string
+	facility int
+	hostname string
+	pid      string
+}
+
+func NewSyslogEncoder(tag string, facility int, inner Encoder) *SyslogEncoder { // {{{
+	if inner == nil {
+		inner = &TextEncoder{}
+	}
+
+	if facility <= 0 {
+		facility = DefaultSyslogFacility
+	}
+
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+
+	if tag == "" {
+		tag = "-"
+	}
+
+	return &SyslogEncoder{
+		Encoder:  inner,
+		tag:      tag,
+		facility: facility,
+		hostname: hostname,
+		pid:      strconv.Itoa(os.Getpid()),
+	}
+} // }}}
+
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func (e *SyslogEncoder) Encode(entry *Entry) ([]byte, error) { // {{{
+	level_name := entry.Level
+
+	// 级别已体现在 PRI 中, MSG 部分不再重复
+	entry.Level = ""
+	msg, err := e.Encoder.Encode(entry)
+	entry.Level = level_name
+	if err != nil {
+		return nil, err
+	}
+
+	at := entry.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	var b bytes.Buffer
+
+	b.WriteByte('<')
+	b.WriteString(strconv.Itoa(e.facility*8 + levelSeverity(level_name)))
+	b.WriteString(">1 ")
+	b.WriteString(at.Format(time.RFC3339Nano))
+	b.WriteByte(' ')
+	b.WriteString(e.hostname)
+	b.WriteByte(' ')
+	b.WriteString(e.tag)
+	b.WriteByte(' ')
+	b.WriteString(e.pid)
+	b.WriteString(" - - ")
+	b.Write(msg)
+
+	return b.Bytes(), nil
+} // }}}
+
+// journald 友好的 stdout 格式: 行首添加 <N> 级别前缀, 由 systemd 解析为日志优先级
+type JournaldEncoder struct {
+	Encoder
+}
+
+func (e *JournaldEncoder) Encode(entry *Entry) ([]byte, error) { // {{{
+	inner := e.Encoder
+	if inner == nil {
+		inner = &TextEncoder{}
+	}
+
+	msg, err := inner.Encode(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+
+	b.WriteByte('<')
+	b.WriteString(strconv.Itoa(levelSeverity(entry.Level)))
+	b.WriteByte('>')
+	b.Write(msg)
+
+	return b.Bytes(), nil
+} // }}}
@@ -0,0 +1,127 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var DefaultDialTimeout = 3 * time.Second // 网络类 writer 连接超时
+
+// syslog 写入: 支持 unix socket、udp、tcp, tcp 时使用 RFC 6587 octet-counting 分帧
+type SyslogWriter struct {
+	network string
+	addr    string
+	conn    net.Conn
+	mu      sync.Mutex
+}
+
+// network 为空时依次尝试本机 /dev/log、/var/run/syslog
+func NewSyslogWriter(network, addr string) (*SyslogWriter, error) { // {{{
+	w := &SyslogWriter{
+		network: network,
+		addr:    addr,
+	}
+
+	if err := w.connect(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+} // }}}
+
+func (w *SyslogWriter) connect() error { // {{{
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+
+	if w.network == "" || w.network == "unix" || w.network == "unixgram" {
+		addrs := []string{w.addr}
+		if w.addr == "" {
+			addrs = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+		}
+
+		networks := []string{"unixgram", "unix"}
+		if w.network != "" {
+			networks = []string{w.network}
+		}
+
+		for _, network := range networks {
+			for _, addr := range addrs {
+				conn, err := net.DialTimeout(network, addr, DefaultDialTimeout)
+				if err == nil {
+					w.network = network
+					w.addr = addr
+					w.conn = conn
+					return nil
+				}
+			}
+		}
+
+		return fmt.Errorf("syslog unix socket is unavailable: %v", addrs)
+	}
+
+	conn, err := net.DialTimeout(w.network, w.addr, DefaultDialTimeout)
+	if err != nil {
+		return err
+	}
+
+	w.conn = conn
+	return nil
+} // }}}
+
+// 实现 io.Writer 接口, 写入失败时重连一次
+func (w *SyslogWriter) Write(p []byte) (int, error) { // {{{
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	frame := w.frame(p)
+
+	if w.conn != nil {
+		if _, err := w.conn.Write(frame); err == nil {
+			return len(p), nil
+		}
+	}
+
+	if err := w.connect(); err != nil {
+		return 0, err
+	}
+
+	if _, err := w.conn.Write(frame); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+} // }}}
+
+func (w *SyslogWriter) frame(p []byte) []byte { // {{{
+	p = bytes.TrimRight(p, "\n")
+
+	if w.network != "tcp" && w.network != "tcp4" && w.network != "tcp6" {
+		return p
+	}
+
+	frame := make([]byte, 0, len(p)+8)
+	frame = strconv.AppendInt(frame, int64(len(p)), 10)
+	frame = append(frame, ' ')
+	frame = append(frame, p...)
+
+	return frame
+} // }}}
+
+func (w *SyslogWriter) Close() error { // {{{
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn != nil {
+		err := w.conn.Close()
+		w.conn = nil
+		return err
+	}
+
+	return nil
+} // }}}
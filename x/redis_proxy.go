@@ -186,6 +186,45 @@ func (r *RedisProxy) add(conf MAP, key string) (*redis.RedisClient, error) { //{
 	return client, nil
 } // }}}
 
+// 检查所有已创建的连接, 返回第一个失败的错误
+func (r *RedisProxy) Ping(ctx context.Context) error { // {{{
+	r.mutex.RLock()
+	clients := make(map[string]*redis.RedisClient, len(r.c))
+	for key, client := range r.c {
+		clients[key] = client
+	}
+	r.mutex.RUnlock()
+
+	for key, client := range clients {
+		if err := client.Ping(ctx).Err(); err != nil {
+			return fmt.Errorf("redis [%s] ping error: %v", key, err)
+		}
+	}
+
+	return nil
+} // }}}
+
+// 按配置名检查 redis 连接, 连接不存在时创建; 不含 host 的配置跳过, 之后检查所有其他已创建的连接
+func (r *RedisProxy) PingNamed(ctx context.Context, names ...string) error { // {{{
+	for _, name := range names {
+		conf := Conf.GetMap(name)
+		if _, ok := conf["host"]; !ok {
+			continue
+		}
+
+		client, err := r.Get(conf)
+		if err != nil {
+			return fmt.Errorf("redis [%s] %v", name, err)
+		}
+
+		if err := client.Ping(ctx).Err(); err != nil {
+			return fmt.Errorf("redis [%s] ping error: %v", name, err)
+		}
+	}
+
+	return r.Ping(ctx)
+} // }}}
+
 func (r *RedisProxy) Close() { // {{{
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
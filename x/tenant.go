@@ -0,0 +1,99 @@
+package x
+
+import (
+	"context"
+	"regexp"
+)
+
+// 多租户: 由中间件从 JWT claim、header 或子域名解析租户, 存入 ctx
+// Dao 按租户自动过滤及填充租户字段、选择 DB 及 schema; 缓存 key 及日志中带租户
+// 配置:
+//
+//	tenant:
+//	  enabled: true
+//	  jwt_claim: tid               # 从 Authorization: Bearer <jwt> 的 claim 取租户, 须同时配置 jwt_secret(HS256)
+//	  jwt_secret: xxx
+//	  header: X-Tenant-ID          # 从 header 取租户
+//	  metadata: x-tenant-id        # rpc 从 metadata 取租户
+//	  trust_header: false          # header 及 metadata 由可信的网关设置时才作为租户来源, 否则只用于与 jwt 比对
+//	  subdomain: example.com       # 从子域名取租户, 如 acme.example.com
+//	  required: false              # 无法取得租户时返回 ErrTenant
+//	  allowed_groups: []
+//	  tenants:                     # 配置了 tenants 时只接受其中的租户
+//	    acme:
+//	      db:                      # DB 配置名映射, Dao 及事务使用映射后的配置
+//	        db_master: db_acme_master
+//	        db_slave: db_acme_slave
+//	      schema: acme             # 按租户隔离的表(Dao.SetTenantScope)加 schema 前缀, 如 acme.user
+//
+// 以上来源依次检查, 取第一个非空的值; header、metadata 或子域名与 jwt 中的租户不一致时返回 ErrTenant
+const tenantCtxKey = "tenant"
+
+// 租户 id 只允许字母、数字、下划线及中划线, 避免用于 schema 及缓存 key 时被注入
+var tenantRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// 在 ctx 中设置租户
+func WithTenant(ctx context.Context, tenant string) context.Context { // {{{
+	return context.WithValue(ctx, tenantCtxKey, tenant)
+} // }}}
+
+// ctx 中的租户, 不存在时返回空
+func GetTenant(ctx context.Context) string { // {{{
+	if ctx == nil {
+		return ""
+	}
+
+	tenant, _ := ctx.Value(tenantCtxKey).(string)
+	return tenant
+} // }}}
+
+// 租户是否有效: 格式合法, 且配置了 tenants 时在其中
+func ValidTenant(tenant string) bool { // {{{
+	if !tenantRegex.MatchString(tenant) {
+		return false
+	}
+
+	if Conf == nil || len(Conf.GetMap("tenant", "tenants")) == 0 {
+		return true
+	}
+
+	return Conf.Get("tenant", "tenants", tenant).found
+} // }}}
+
+// 租户配置
+func TenantConf(tenant string) MAP { // {{{
+	if tenant == "" || Conf == nil {
+		return nil
+	}
+
+	return Conf.GetMap("tenant", "tenants", tenant)
+} // }}}
+
+// ctx 中租户对应的 DB 配置名, 未配置映射时返回原配置名
+func TenantDBName(ctx context.Context, name string) string { // {{{
+	tenant := GetTenant(ctx)
+	if tenant == "" || Conf == nil {
+		return name
+	}
+
+	return Conf.GetDefString(name, "tenant", "tenants", tenant, "db", name)
+} // }}}
+
+// ctx 中租户的 schema, 未配置时返回空
+func TenantSchema(ctx context.Context) string { // {{{
+	tenant := GetTenant(ctx)
+	if tenant == "" || Conf == nil {
+		return ""
+	}
+
+	return Conf.GetString("tenant", "tenants", tenant, "schema")
+} // }}}
+
+// 带租户前缀的缓存 key, 用于 LocalCache 及 Redis; ctx 中没有租户时返回原 key
+func TenantKey(ctx context.Context, key string) string { // {{{
+	if tenant := GetTenant(ctx); tenant != "" {
+		return "tenant:" + tenant + ":" + key
+	}
+
+	return key
+} // }}}
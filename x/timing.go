@@ -0,0 +1,70 @@
+package x
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// 请求耗时分解: 由 HttpLog 中间件创建并放入 ctx, 控制器记录 action 耗时, Dao 累加 DB 耗时
+type Timing struct {
+	start   time.Time
+	action  atomic.Int64
+	db      atomic.Int64
+	dbCount atomic.Int64
+}
+
+func NewTiming() *Timing { // {{{
+	return &Timing{start: time.Now()}
+} // }}}
+
+func WithTiming(ctx context.Context, t *Timing) context.Context { // {{{
+	return context.WithValue(ctx, "log_timing", t)
+} // }}}
+
+// 从 ctx 中获取 Timing, 未开启时返回 nil
+func GetTiming(ctx context.Context) *Timing { // {{{
+	if ctx == nil {
+		return nil
+	}
+
+	t, _ := ctx.Value("log_timing").(*Timing)
+	return t
+} // }}}
+
+func (t *Timing) AddAction(d time.Duration) { // {{{
+	t.action.Add(int64(d))
+} // }}}
+
+func (t *Timing) AddDB(d time.Duration) { // {{{
+	t.db.Add(int64(d))
+	t.dbCount.Add(1)
+} // }}}
+
+// 配合 defer 使用: defer t.TrackDB(time.Now())
+func (t *Timing) TrackDB(start time.Time) { // {{{
+	t.AddDB(time.Since(start))
+} // }}}
+
+// 总耗时
+func (t *Timing) Total() time.Duration { // {{{
+	return time.Since(t.start)
+} // }}}
+
+// action 耗时(包含 DB 耗时)
+func (t *Timing) Action() time.Duration { // {{{
+	return time.Duration(t.action.Load())
+} // }}}
+
+// 中间件耗时: 总耗时 - action 耗时
+func (t *Timing) Middleware() time.Duration { // {{{
+	return max(t.Total()-t.Action(), 0)
+} // }}}
+
+func (t *Timing) DB() time.Duration { // {{{
+	return time.Duration(t.db.Load())
+} // }}}
+
+func (t *Timing) DBCount() int64 { // {{{
+	return t.dbCount.Load()
+} // }}}
@@ -44,7 +44,12 @@ var (
 	ConfMaxPostSize            int64
 	ConfMonitorPort            string
 	ConfMonitorPath            string
+	ConfLivezPath              string
+	ConfReadyzPath             string
 	ConfPprofEnabled           bool
+	ConfDBStatsEnabled         bool // 是否开启连接池状态接口
+	ConfDBStatsPath            string
+	ConfErrStatusEnabled       bool // 按错误码返回 http 状态码及 grpc 状态码
 	ConfStaticEnabled          bool
 	ConfStaticPath             string
 	ConfStaticRoot             string
@@ -56,6 +61,7 @@ var (
 	ConfRpcLogOmitParams       []string
 	ConfDefaultController      string
 	ConfDefaultAction          string
+	ConfCursorSecret           string // 游标分页的签名密钥
 
 	//由路由配置衍生的map
 	/*